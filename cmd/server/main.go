@@ -13,39 +13,168 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"agent-comm-hub/internal/auth"
 	"agent-comm-hub/internal/config"
 	"agent-comm-hub/internal/handlers"
+	"agent-comm-hub/internal/idgen"
+	"agent-comm-hub/internal/logging"
+	"agent-comm-hub/internal/metrics"
+	hubmiddleware "agent-comm-hub/internal/middleware"
 	"agent-comm-hub/internal/services/memory"
 	"agent-comm-hub/internal/services/messaging"
 	"agent-comm-hub/internal/services/redis"
 	"agent-comm-hub/internal/services/registry"
+	"agent-comm-hub/internal/services/webhook"
+)
+
+// Build information, injected at build time via -ldflags "-X main.version=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Direct logs to the configured destination
+	logOutput, closeLog, err := logging.Open(cfg.Logging.Output)
+	if err != nil {
+		log.Fatalf("Invalid log output: %v", err)
+	}
+	defer closeLog()
+	log.SetOutput(logOutput)
 
 	// Initialize Redis manager
-	redisManager, err := redis.NewManager(&cfg.Redis)
+	redisManager := connectRedis(cfg)
+
+	// Initialize ID generation
+	ids, err := idgen.New(cfg.Server.IDStrategy)
 	if err != nil {
-		log.Fatalf("Failed to initialize Redis: %v", err)
+		log.Fatalf("Invalid ID strategy: %v", err)
+	}
+
+	// Initialize API keys
+	keyEntries, err := loadAPIKeys(&cfg.Auth)
+	if err != nil {
+		log.Fatalf("Failed to load API keys: %v", err)
+	}
+	keys, err := auth.NewKeyStore(keyEntries)
+	if err != nil {
+		log.Fatalf("Invalid API key configuration: %v", err)
+	}
+	if !keys.Enabled() {
+		log.Println("Warning: no API keys configured, authentication is disabled")
+	}
+
+	// Initialize registry storage
+	registryStore, err := registry.NewStore(cfg.Registry.StoreBackend, redisManager.Standard())
+	if err != nil {
+		log.Fatalf("Invalid registry store: %v", err)
+	}
+	if cfg.Registry.StoreBackend == registry.StoreMemory {
+		log.Println("Warning: registry is stored in memory and will not survive restarts")
+	}
+
+	// Initialize message transport
+	transport, err := messaging.NewTransport(&cfg.Messaging, redisManager.PubSub(), cfg.Redis.PubSubKeepalive)
+	if err != nil {
+		log.Fatalf("Failed to initialize message transport: %v", err)
+	}
+
+	channels, err := messaging.NewChannelNames(&cfg.Messaging)
+	if err != nil {
+		log.Fatalf("Invalid message channel templates: %v", err)
 	}
-	defer redisManager.Close()
-	log.Println("Redis connections established")
 
 	// Initialize services
-	agentRegistry := registry.NewAgentRegistry(redisManager.Standard())
-	messageBroker := messaging.NewMessageBroker(redisManager.PubSub(), redisManager.Standard())
-	memoryManager := memory.NewMemoryManager(&cfg.Memory)
+	agentRegistry := registry.NewAgentRegistry(registryStore, ids, &cfg.Registry)
+	reindexCtx, cancelReindex := context.WithTimeout(context.Background(), time.Minute)
+	if indexed, err := agentRegistry.Reindex(reindexCtx); err != nil {
+		log.Printf("Warning: failed to reindex agents: %v", err)
+	} else {
+		log.Printf("Indexed %d registered agents", indexed)
+	}
+	cancelReindex()
+	messageBroker := messaging.NewMessageBroker(transport, channels, redisManager.Standard(), ids, &cfg.Messaging)
+	routes, err := messaging.ParseTypeRoutes(cfg.Messaging.TypeRoutes, channels)
+	if err != nil {
+		log.Fatalf("Invalid message type routes: %v", err)
+	}
+	messageBroker.SetTypeRoutes(routes)
+	messageBroker.SetRetention(agentRegistry)
+	memoryObjects, err := memory.NewObjectStore(&cfg.Memory)
+	if err != nil {
+		log.Fatalf("Invalid memory offload configuration: %v", err)
+	}
+	memoryManager := memory.NewMemoryManager(&cfg.Memory, memoryObjects, redisManager.Standard())
+	webhooks, err := webhook.NewDispatcher(&cfg.Webhook, agentRegistry, redisManager.Standard())
+	if err != nil {
+		log.Fatalf("Invalid webhook configuration: %v", err)
+	}
+	messageBroker.SetWebhooks(webhooks)
+	webhooks.SetFallback(messageBroker.HoldUndelivered)
+	if cfg.Webhook.SigningSecret == "" {
+		log.Println("Warning: WEBHOOK_SIGNING_SECRET is not set, webhook deliveries are unsigned")
+	}
+
+	// Reclaim messaging and memory state when agents unregister
+	agentRegistry.OnUnregister(messageBroker.PurgeAgent)
+	agentRegistry.OnUnregister(memoryManager.DeleteAgent)
+
+	// Mark agents whose heartbeat expires offline
+	sweepCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	if cfg.Registry.SweepInterval > 0 {
+		go agentRegistry.RunSweeper(sweepCtx, cfg.Registry.SweepInterval)
+	}
+	// Requeue inbox messages that were not acknowledged in time
+	if cfg.Messaging.StoreAndForward && cfg.Messaging.RedeliveryInterval > 0 {
+		go messageBroker.RunRedeliverySweeper(sweepCtx, cfg.Messaging.RedeliveryInterval)
+	}
+	// Tell senders about queued messages that expired undelivered
+	if cfg.Messaging.StoreAndForward && cfg.Messaging.ExpirySweepInterval > 0 {
+		go messageBroker.RunExpirySweeper(sweepCtx, cfg.Messaging.ExpirySweepInterval)
+	}
+	// Deliver messages to agent webhooks
+	webhooksDone := make(chan struct{})
+	go func() {
+		webhooks.Run(sweepCtx)
+		close(webhooksDone)
+	}()
 
 	// Initialize handlers
-	healthHandler := handlers.NewHealthHandler(redisManager)
-	agentHandler := handlers.NewAgentHandler(agentRegistry)
-	messageHandler := handlers.NewMessageHandler(messageBroker, agentRegistry)
-	memoryHandler := handlers.NewMemoryHandler(memoryManager, agentRegistry)
+	h := &appHandlers{
+		health:  handlers.NewHealthHandler(redisManager, &cfg.Health),
+		agent:   handlers.NewAgentHandler(agentRegistry),
+		message: handlers.NewMessageHandler(messageBroker, agentRegistry, &cfg.Messaging),
+		memory:  handlers.NewMemoryHandler(memoryManager, agentRegistry),
+		stream:  handlers.NewStreamHandler(messageBroker, agentRegistry, &cfg.Stream),
+		version: handlers.NewVersionHandler(version, commit, buildTime),
+		config:  handlers.NewConfigHandler(cfg),
+		debug:   handlers.NewDebugHandler(redisManager, agentRegistry, cfg.Registry.StoreBackend),
+		webhook: handlers.NewWebhookHandler(webhooks),
+	}
+	h.drain = handlers.NewDrainHandler(h.stream, cfg.Server.DrainDelay, cfg.Server.DrainTimeout)
+	h.health.AddCheck("memory", handlers.StatusDegraded, memoryManager.Check)
+	if cfg.Messaging.Backend == messaging.BackendNATS {
+		h.health.AddCheck("nats", handlers.StatusDegraded, transport.Check)
+	}
+	if cfg.Health.MinReadyAgents > 0 {
+		h.health.AddReadinessCheck("agents", handlers.MinAgentsCheck(agentRegistry, cfg.Health.MinReadyAgents))
+	}
+	h.health.AddReadinessCheck("drain", h.drain.Check)
 
 	// Setup router
-	router := setupRouter(healthHandler, agentHandler, messageHandler, memoryHandler)
+	realIP, err := hubmiddleware.RealIP(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	router := setupRouter(cfg, keys, h, redisManager, realIP)
 
 	// Create server
 	server := &http.Server{
@@ -58,65 +187,275 @@ func main() {
 
 	// Start server in goroutine
 	go func() {
-		log.Printf("Starting server on %s:%s", cfg.Server.Host, cfg.Server.Port)
+		log.Printf("Starting server %s (commit %s) on %s:%s", version, commit, cfg.Server.Host, cfg.Server.Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
 
-	// Wait for interrupt signal
+	// Start the internal listener for observability endpoints, if configured
+	var internalServer *http.Server
+	if cfg.Server.InternalPort != "" {
+		internalServer = &http.Server{
+			Addr:         fmt.Sprintf("%s:%s", cfg.Server.InternalHost, cfg.Server.InternalPort),
+			Handler:      setupInternalRouter(cfg, h),
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+		go func() {
+			log.Printf("Starting internal server on %s:%s", cfg.Server.InternalHost, cfg.Server.InternalPort)
+			if err := internalServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start internal server: %v", err)
+			}
+		}()
+	}
+
+	// Reload API keys on SIGHUP, for rotation without a restart
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go reloadAPIKeys(reload, keys, &cfg.Auth)
+
+	// Wait for interrupt signal, or for a draining instance to drain
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	select {
+	case <-quit:
+	case <-h.drain.Drained():
+		log.Println("Drained")
+	}
 
 	log.Println("Shutting down server...")
+	stopSweeper()
 
-	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Graceful shutdown: servers, streams and Redis share one deadline
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+	if internalServer != nil {
+		if err := internalServer.Shutdown(ctx); err != nil {
+			log.Printf("Internal server forced to shutdown: %v", err)
+		}
+	}
+	if err := h.stream.Shutdown(ctx); err != nil {
+		log.Printf("Streams forced to close: %v", err)
+	}
+	// Let the webhook dispatcher queue the deliveries it still holds
+	select {
+	case <-webhooksDone:
+	case <-ctx.Done():
+		log.Println("Warning: webhook dispatcher did not stop in time, pending deliveries may be lost")
+	}
+	if err := transport.Close(); err != nil {
+		log.Printf("Warning: failed to close message transport: %v", err)
+	}
+	if err := redisManager.Close(); err != nil {
+		log.Printf("Warning: %v", err)
 	}
 
 	log.Println("Server exited properly")
 }
 
-func setupRouter(healthHandler *handlers.HealthHandler, agentHandler *handlers.AgentHandler, messageHandler *handlers.MessageHandler, memoryHandler *handlers.MemoryHandler) *chi.Mux {
+// connectRedis creates the Redis manager. With the in-memory registry the hub
+// starts even if Redis is down, serving the registry while messaging, memory,
+// webhooks and the other Redis-backed features fail until Redis is reachable.
+func connectRedis(cfg *config.Config) *redis.Manager {
+	if cfg.Registry.StoreBackend != registry.StoreMemory {
+		redisManager, err := redis.NewManager(&cfg.Redis)
+		if err != nil {
+			log.Fatalf("Failed to initialize Redis: %v", err)
+		}
+		log.Println("Redis connections established")
+		checkRedisTopology(redisManager, &cfg.Redis)
+		return redisManager
+	}
+
+	redisManager, err := redis.NewLazyManager(&cfg.Redis)
+	if err != nil {
+		log.Fatalf("Failed to initialize Redis: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Redis.StartupTimeout)
+	defer cancel()
+	if err := redisManager.Ping(ctx); err != nil {
+		log.Printf("Warning: Redis is unavailable, only the in-memory registry works until it is reachable: %v", err)
+		return redisManager
+	}
+	log.Println("Redis connections established")
+	checkRedisTopology(redisManager, &cfg.Redis)
+	return redisManager
+}
+
+// checkRedisTopology warns when the pub/sub Redis is a different server from
+// the standard Redis without that being acknowledged, as hub instances whose
+// pub/sub URLs disagree silently fail to deliver each other's messages.
+func checkRedisTopology(redisManager *redis.Manager, cfg *config.RedisConfig) {
+	if cfg.SeparatePubSub {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	same, err := redisManager.SameServer(ctx)
+	if err != nil {
+		log.Printf("Warning: could not compare standard and pub/sub Redis servers: %v", err)
+		return
+	}
+	if !same {
+		log.Println("WARNING: REDIS_PUBSUB_URL points at a different Redis server than REDIS_STANDARD_URL. " +
+			"Messages are only delivered between hub instances using the same pub/sub server. " +
+			"Set REDIS_PUBSUB_SEPARATE=true if the separation is intended.")
+	}
+}
+
+// loadAPIKeys returns the API key entries from API_KEYS and, if set, the
+// API_KEYS_FILE.
+func loadAPIKeys(cfg *config.AuthConfig) ([]string, error) {
+	entries := append([]string(nil), cfg.APIKeys...)
+	if cfg.APIKeysFile == "" {
+		return entries, nil
+	}
+	fileEntries, err := auth.ReadKeyFile(cfg.APIKeysFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, fileEntries...), nil
+}
+
+// reloadAPIKeys re-reads the API keys each time a signal arrives. The
+// API_KEYS variable can't change while the hub runs, so rotation goes
+// through the API_KEYS_FILE. A failed reload keeps the current keys.
+func reloadAPIKeys(signals <-chan os.Signal, keys *auth.KeyStore, cfg *config.AuthConfig) {
+	for range signals {
+		entries, err := loadAPIKeys(cfg)
+		if err != nil {
+			log.Printf("Failed to reload API keys, keeping the current keys: %v", err)
+			continue
+		}
+		revoked, err := keys.Reload(entries)
+		if err != nil {
+			log.Printf("Failed to reload API keys, keeping the current keys: %v", err)
+			continue
+		}
+		log.Printf("Reloaded %d API keys, %d revoked", len(entries), revoked)
+	}
+}
+
+// appHandlers holds the HTTP handlers served by the router.
+type appHandlers struct {
+	health  *handlers.HealthHandler
+	agent   *handlers.AgentHandler
+	message *handlers.MessageHandler
+	memory  *handlers.MemoryHandler
+	stream  *handlers.StreamHandler
+	version *handlers.VersionHandler
+	config  *handlers.ConfigHandler
+	debug   *handlers.DebugHandler
+	webhook *handlers.WebhookHandler
+	drain   *handlers.DrainHandler
+}
+
+func setupRouter(cfg *config.Config, keys *auth.KeyStore, h *appHandlers, redisManager *redis.Manager, realIP func(http.Handler) http.Handler) *chi.Mux {
 	router := chi.NewRouter()
 
 	// Middleware
 	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
+	router.Use(realIP)
+	router.Use(hubmiddleware.AccessLog(log.Default(), cfg.Logging.SampleRate, cfg.Logging.SlowThreshold))
 	router.Use(middleware.Recoverer)
-	router.Use(middleware.Timeout(60 * time.Second))
+	router.Use(hubmiddleware.Timeout(60 * time.Second))
+	if len(cfg.Server.CORSAllowedOrigins) > 0 {
+		router.Use(hubmiddleware.CORS(cfg.Server.CORSAllowedOrigins))
+	}
 
 	// Health endpoints
-	router.Get("/health", healthHandler.Handle)
-	router.Get("/ready", healthHandler.Ready)
+	router.Get("/health", h.health.Handle)
+	router.Get("/ready", h.health.Ready)
+
+	// Metrics endpoint, unless served on the internal listener
+	if cfg.Server.InternalPort == "" {
+		router.Handle("/metrics", metricsHandler(cfg))
+	}
 
 	// API routes
 	router.Route("/api/v1", func(r chi.Router) {
+		r.Use(hubmiddleware.Authenticate(keys))
+		r.Use(hubmiddleware.Authorize)
+		if cfg.Server.IdempotencyTTL > 0 {
+			r.Use(hubmiddleware.Idempotency(redisManager.Standard(), cfg.Server.IdempotencyTTL))
+		}
+
+		r.Get("/version", h.version.Get)
+		r.With(hubmiddleware.RequireAdmin).Get("/config", h.config.Get)
+		r.With(hubmiddleware.RequireAdmin).Get("/debug/agents/{id}/keys", h.debug.AgentKeys)
+		r.With(hubmiddleware.RequireAdmin).Get("/debug/connections", h.stream.Connections)
+		r.With(hubmiddleware.RequireAdmin).Get("/admin/drain", h.drain.Status)
+		r.With(hubmiddleware.RequireAdmin).Post("/admin/drain", h.drain.Drain)
+		r.Get("/agent-types", h.agent.ListTypes)
+		r.Get("/registry/events", h.agent.ListEvents)
+
+		// System-wide message monitoring (privileged)
+		r.With(hubmiddleware.RequireAdmin).Get("/messages/stream/all", h.stream.StreamAll)
+		r.Get("/messages/stream/pattern", h.stream.StreamPattern)
+
+		// Combined history of several agents, for incident analysis
+		r.Get("/messages/history", h.message.MergedHistory)
+		// Latest state of each compaction key on a topic
+		r.Get("/topics/{name}/compacted", h.message.CompactedTopic)
+
 		// Agent routes
+		maxBody := hubmiddleware.MaxBodySize(cfg.Server.MaxRequestBody)
+		register := []func(http.Handler) http.Handler{h.drain.RefuseNew, maxBody}
+		if cfg.Server.RegisterRateLimit > 0 {
+			register = append(register, hubmiddleware.RateLimitByIP(redisManager.Standard(), "register", cfg.Server.RegisterRateLimit, cfg.Server.RegisterRateBurst))
+		}
 		r.Route("/agents", func(r chi.Router) {
-			r.Post("/", agentHandler.Register)
-			r.Get("/", agentHandler.List)
+			r.With(register...).Post("/", h.agent.Register)
+			r.Get("/", h.agent.List)
+			r.With(hubmiddleware.RequireAdmin).Delete("/", h.agent.DeleteByType)
+			r.Get("/status", h.agent.Liveness)
+			r.With(maxBody).Post("/heartbeat", h.agent.BulkHeartbeat)
+			// Public discovery view, open to agents that may not act as {id}
+			r.Get("/{id}/profile", h.agent.Profile)
 			r.Route("/{id}", func(r chi.Router) {
-				r.Get("/", agentHandler.Get)
-				r.Put("/", agentHandler.Update)
-				r.Delete("/", agentHandler.Delete)
-				r.Post("/heartbeat", agentHandler.Heartbeat)
+				r.Use(hubmiddleware.ScopeAgent)
+
+				r.Get("/", h.agent.Get)
+				r.With(maxBody).Put("/", h.agent.Update)
+				r.With(maxBody).Patch("/", h.agent.Patch)
+				r.With(hubmiddleware.RequireAdmin).Delete("/", h.agent.Delete)
+				r.With(maxBody).Post("/heartbeat", h.agent.Heartbeat)
+				r.With(hubmiddleware.RequireMutate).Head("/heartbeat", h.agent.Touch)
+				r.With(maxBody).Post("/status", h.agent.ReportStatus)
+				r.With(maxBody).Post("/capabilities", h.agent.AddCapabilities)
+				r.Delete("/capabilities/{capability}", h.agent.RemoveCapability)
+				r.Get("/stats", h.message.Stats)
+				r.Post("/ping", h.message.Ping)
+				r.Post("/drain", h.message.Drain)
+				r.Get("/inbox", h.message.Inbox)
+				r.With(maxBody).Post("/inbox/ack", h.message.Ack)
+				r.Get("/inbox/dead-letters", h.message.DeadLetters)
+				r.Get("/broadcast-categories", h.message.BroadcastCategories)
+				r.With(maxBody).Put("/broadcast-categories", h.message.SetBroadcastCategories)
 				// Message routes
 				r.Route("/messages", func(r chi.Router) {
-					r.Post("/", messageHandler.Send)
-					r.Get("/", messageHandler.List)
+					r.With(maxBody).Post("/", h.message.Send)
+					r.With(maxBody).Post("/batch", h.message.SendBatch)
+					r.Get("/", h.message.List)
+					r.Get("/search", h.message.Search)
+					r.Get("/queue/depth", h.message.QueueDepth)
+					r.Get("/stream", h.stream.Stream)
+					r.Get("/{messageID}/delivery", h.webhook.Delivery)
 				})
-				// Memory routes
+				// Memory routes, which allow larger bodies for offloaded values
 				r.Route("/memory", func(r chi.Router) {
-					r.Post("/", memoryHandler.Store)
-					r.Get("/", memoryHandler.Get)
-					r.Delete("/", memoryHandler.Delete)
+					r.With(hubmiddleware.MaxBodySize(cfg.Server.MaxMemoryBody)).Post("/", h.memory.Store)
+					r.With(hubmiddleware.MaxBodySize(cfg.Server.MaxMemoryBody)).Post("/append", h.memory.Append)
+					r.Get("/", h.memory.Get)
+					r.Delete("/", h.memory.Delete)
 				})
 			})
 		})
@@ -124,3 +463,24 @@ func setupRouter(healthHandler *handlers.HealthHandler, agentHandler *handlers.A
 
 	return router
 }
+
+// setupInternalRouter serves observability endpoints on the internal listener,
+// keeping them off the public API surface.
+func setupInternalRouter(cfg *config.Config, h *appHandlers) *chi.Mux {
+	router := chi.NewRouter()
+
+	router.Use(middleware.Recoverer)
+
+	router.Get("/health", h.health.Handle)
+	router.Get("/ready", h.health.Ready)
+	router.Handle("/metrics", metricsHandler(cfg))
+
+	return router
+}
+
+func metricsHandler(cfg *config.Config) http.Handler {
+	if cfg.Server.MetricsToken != "" {
+		return hubmiddleware.BearerToken(cfg.Server.MetricsToken)(metrics.Handler())
+	}
+	return metrics.Handler()
+}
@@ -0,0 +1,206 @@
+// Package auth provides API key authentication and role-based authorization.
+package auth
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Role represents the access level granted to an API key.
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleAgent    Role = "agent"
+	RoleReadOnly Role = "readonly"
+)
+
+// Principal is the authenticated identity behind a request.
+type Principal struct {
+	Role     Role
+	AgentIDs map[string]bool // Agents the key may act as
+
+	revoked chan struct{} // Closed when the key is revoked or its grant changes
+}
+
+// Revoked returns a channel that is closed when the principal's key is
+// revoked by a reload, so long-lived requests can end. It is never closed
+// for a key that survives a reload unchanged.
+func (p *Principal) Revoked() <-chan struct{} {
+	return p.revoked
+}
+
+// sameGrant reports whether two principals grant the same access.
+func (p *Principal) sameGrant(other *Principal) bool {
+	return p.Role == other.Role && maps.Equal(p.AgentIDs, other.AgentIDs)
+}
+
+// IsAdmin reports whether the principal has the admin role.
+func (p *Principal) IsAdmin() bool {
+	return p.Role == RoleAdmin
+}
+
+// CanMutate reports whether the principal may change state.
+func (p *Principal) CanMutate() bool {
+	return p.Role != RoleReadOnly
+}
+
+// CanActAs reports whether the principal may act on behalf of an agent.
+// Admins may act as any agent, and read-only keys without bound agents may
+// read any agent. Everyone else is limited to their bound agents.
+func (p *Principal) CanActAs(agentID string) bool {
+	return p.CanActAsAny() || p.AgentIDs[agentID]
+}
+
+// CanActAsAny reports whether the principal may act on behalf of every agent.
+func (p *Principal) CanActAsAny() bool {
+	return p.IsAdmin() || (p.Role == RoleReadOnly && len(p.AgentIDs) == 0)
+}
+
+// anonymousAdmin is used for every request when authentication is disabled.
+var anonymousAdmin = &Principal{Role: RoleAdmin}
+
+// ErrDisableAuth is returned by Reload when the new key set is empty while
+// authentication is enabled.
+var ErrDisableAuth = errors.New("reload would remove every API key and disable authentication")
+
+// KeyStore holds the valid API keys and the principal each authenticates as.
+// The keys can be replaced at runtime with Reload.
+type KeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*Principal
+}
+
+// NewKeyStore parses API key entries of the form "key:role[:agent1|agent2]",
+// where the optional agent IDs are the agents the key may act as. An empty
+// set of entries disables authentication.
+func NewKeyStore(entries []string) (*KeyStore, error) {
+	keys, err := parseKeys(entries)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyStore{keys: keys}, nil
+}
+
+// Reload replaces the key set with entries, in the same form as for
+// NewKeyStore. Removed keys, and keys whose role or agents changed, stop
+// authenticating immediately and their principals are revoked. Invalid
+// entries, or an empty set while authentication is enabled, leave the
+// current keys in place. It returns the number of keys revoked.
+func (s *KeyStore) Reload(entries []string) (int, error) {
+	keys, err := parseKeys(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.keys) > 0 && len(keys) == 0 {
+		return 0, ErrDisableAuth
+	}
+
+	revoked := 0
+	for key, old := range s.keys {
+		if p, ok := keys[key]; ok && p.sameGrant(old) {
+			// Keep the principal so its open streams aren't revoked
+			keys[key] = old
+			continue
+		}
+		close(old.revoked)
+		revoked++
+	}
+	s.keys = keys
+	return revoked, nil
+}
+
+// parseKeys parses API key entries into principals by key.
+func parseKeys(entries []string) (map[string]*Principal, error) {
+	keys := make(map[string]*Principal, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid API key entry %q: expected key:role[:agents]", entry)
+		}
+
+		role := Role(parts[1])
+		switch role {
+		case RoleAdmin, RoleAgent, RoleReadOnly:
+		default:
+			return nil, fmt.Errorf("invalid role %q for API key", parts[1])
+		}
+
+		principal := &Principal{Role: role, AgentIDs: make(map[string]bool), revoked: make(chan struct{})}
+		if len(parts) == 3 {
+			for _, agentID := range strings.Split(parts[2], "|") {
+				if agentID != "" {
+					principal.AgentIDs[agentID] = true
+				}
+			}
+		}
+		keys[parts[0]] = principal
+	}
+	return keys, nil
+}
+
+// ReadKeyFile reads API key entries from a file, one per line. Blank lines
+// and lines starting with # are skipped.
+func ReadKeyFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open API key file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read API key file: %w", err)
+	}
+	return entries, nil
+}
+
+// Enabled reports whether any API keys are configured.
+func (s *KeyStore) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys) > 0
+}
+
+// Lookup returns the principal for an API key. When authentication is
+// disabled every lookup succeeds as an admin.
+func (s *KeyStore) Lookup(key string) (*Principal, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.keys) == 0 {
+		return anonymousAdmin, true
+	}
+	p, ok := s.keys[key]
+	return p, ok
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok
+}
@@ -2,69 +2,352 @@
 package config
 
 import (
+	"cmp"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // Config holds all application configuration.
 type Config struct {
-	Server  ServerConfig
-	Redis   RedisConfig
-	Memory  MemoryConfig
-	Logging LoggingConfig
+	Server    ServerConfig
+	Health    HealthConfig
+	Redis     RedisConfig
+	Memory    MemoryConfig
+	Auth      AuthConfig
+	Registry  RegistryConfig
+	Messaging MessagingConfig
+	Stream    StreamConfig
+	Webhook   WebhookConfig
+	Logging   LoggingConfig
 }
 
 // ServerConfig holds HTTP server configuration.
 type ServerConfig struct {
-	Host string
-	Port string
+	Host               string
+	Port               string
+	InternalHost       string // Listener for internal endpoints such as /metrics
+	InternalPort       string // Empty serves internal endpoints on the public listener
+	MetricsToken       string // Bearer token required for /metrics, empty = none
+	CORSAllowedOrigins []string
+	IDStrategy         string        // "uuid" or "ulid"
+	ShutdownTimeout    time.Duration // Deadline for draining requests, streams and connections on shutdown
+	DrainDelay         time.Duration // Least time a draining instance keeps serving before it shuts down
+	DrainTimeout       time.Duration // Most time a draining instance waits for streams to close, 0 = no limit
+	IdempotencyTTL     time.Duration // How long Idempotency-Key responses are replayed, 0 disables
+	MaxRequestBody     int64         // Largest accepted request body in bytes
+	MaxMemoryBody      int64         // Largest accepted memory store body in bytes
+	RegisterRateLimit  int           // Agent registrations per minute per client IP, 0 disables
+	RegisterRateBurst  int           // Registrations a client IP may make at once
+	TrustedProxies     []string      // Proxies whose forwarded client addresses are believed, as IPs or CIDR prefixes
+}
+
+// HealthConfig holds health check configuration.
+type HealthConfig struct {
+	CacheTTL       time.Duration // How long a health check result is reused
+	MinReadyAgents int           // Online agents required for readiness, 0 = none
 }
 
 // RedisConfig holds Redis connection configuration.
 type RedisConfig struct {
 	StandardURL string
 	PubSubURL   string
-	PoolSize    int
-	MinIdleConn int
-	Timeout     time.Duration
+	// SeparatePubSub acknowledges that PubSubURL deliberately points at a
+	// different server than StandardURL, silencing the startup warning
+	SeparatePubSub bool
+	PoolSize       int
+	MinIdleConn    int
+
+	// DialTimeout bounds establishing a connection; ReadTimeout and
+	// WriteTimeout bound individual commands. PubSubReadTimeout applies to
+	// the pub/sub client, where long-lived subscriptions wait on reads.
+	// OpTimeout caps each command or pipeline end to end, including waiting
+	// for a pooled connection (0 leaves only the caller's deadline).
+	DialTimeout       time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	PubSubReadTimeout time.Duration
+	OpTimeout         time.Duration
+
+	// PubSubKeepalive is how often an idle subscription connection is
+	// pinged; a failed ping reconnects and resubscribes (0 disables).
+	PubSubKeepalive time.Duration
+
+	// Connecting at startup is attempted up to StartupAttempts times, each
+	// ping bounded by StartupTimeout, waiting StartupBackoff after the first
+	// failure and twice as long after each further one, up to 30s
+	StartupAttempts int
+	StartupBackoff  time.Duration
+	StartupTimeout  time.Duration
 }
 
 // MemoryConfig holds agent memory server configuration.
 type MemoryConfig struct {
-	URL     string
-	Timeout time.Duration
+	URL          string
+	ShortTermURL string        // Memory server for short-term memory, defaults to URL
+	LongTermURL  string        // Memory server for long-term memory, defaults to URL
+	Timeout      time.Duration // Upper bound on each memory server request; sooner caller deadlines win
+
+	// Values whose JSON encoding exceeds OffloadThreshold bytes are kept in
+	// the OffloadBackend ("inline" keeps everything in the memory server)
+	OffloadBackend   string
+	OffloadThreshold int
+	S3               S3Config
+
+	AppendMaxChunks int           // Chunks an appended long-term value may hold
+	AppendTTL       time.Duration // Appended values expire this long after their last append, 0 keeps them
+}
+
+// S3Config holds S3-compatible object store configuration.
+type S3Config struct {
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Timeout         time.Duration
+}
+
+// AuthConfig holds API authentication configuration.
+type AuthConfig struct {
+	APIKeys     []string // Entries of the form "key:role", empty disables authentication
+	APIKeysFile string   // File of further entries, one per line, re-read on SIGHUP
+}
+
+// RegistryConfig holds agent registry configuration.
+type RegistryConfig struct {
+	MaxAgents    int      // Maximum number of registered agents, 0 = unlimited
+	AgentTypes   []string // Valid agent types, empty allows any type
+	EventsMax    int64    // Approximate number of registry events retained
+	StoreBackend string   // "redis" or "memory"
+	// How often agents with expired heartbeats are marked offline, 0 disables
+	SweepInterval time.Duration
+	// Up to this much random time is added to each heartbeat's TTL so agents
+	// that registered together don't expire together, 0 disables
+	HeartbeatJitter time.Duration
+	// Largest per-agent message history retention overrides
+	MaxRetentionMessages int
+	MaxRetentionTTL      time.Duration
+}
+
+// MessagingConfig holds message broker configuration.
+type MessagingConfig struct {
+	AllowedTypes         []string      // Empty allows all message types
+	CompressionThreshold int           // History entries larger than this many bytes are gzipped, 0 disables
+	DefaultTTL           time.Duration // TTL applied when a sender omits one, 0 = no expiration
+	StoreAndForward      bool          // Queue direct messages published while the recipient has no subscriber
+	Persist              bool          // Record sent messages in history
+	QueueMax             int           // Maximum queued messages per agent
+	VisibilityTimeout    time.Duration // How long pulled inbox messages await acknowledgement, 0 removes them
+	RedeliveryInterval   time.Duration // How often unacknowledged messages are requeued, 0 disables the sweeper
+	MaxDeliveries        int           // Deliveries of an unacknowledged inbox message before it is dead-lettered, 0 = unlimited
+	ExpirySweepInterval  time.Duration // How often expired notify_expiry messages are reported, 0 disables the notices
+	Backend              string        // Message transport: "redis" or "nats"
+	NATSURL              string        // NATS server URL for the "nats" backend
+	DefaultHistoryLimit  int           // Messages returned by history requests without a limit
+	MaxHistoryLimit      int           // Largest accepted limit, and messages retained per agent
+	DrainNotify          bool          // Track recent peers so draining agents can notify them
+	PeerWindow           time.Duration // How long a direct message exchange keeps agents peers
+	AllowFields          []string      // Top-level payload fields kept, empty keeps all
+	RedactFields         []string      // Dotted payload field paths removed
+	TypeRoutes           []string      // "type=recipient" rules for messages sent without a recipient
+
+	// Channel name templates; {id} is replaced by the recipient agent ID and
+	// {name} by the topic or group name
+	DirectChannel    string
+	TopicChannel     string
+	GroupChannel     string
+	BroadcastChannel string
+}
+
+// StreamConfig holds message streaming configuration.
+type StreamConfig struct {
+	HeartbeatInterval time.Duration // How often a live connection refreshes the agent heartbeat
+	BufferSize        int           // Outbound messages buffered per connection
+	OverflowPolicy    string        // "drop_oldest" or "disconnect" when the buffer is full
+	MaxConnections    int           // Open streams allowed on this instance, 0 = unlimited
+	MaxPerAgent       int           // Open streams allowed per agent on this instance, 0 = unlimited
+}
+
+// WebhookConfig holds configuration for delivering messages to agent
+// endpoints.
+type WebhookConfig struct {
+	SigningSecret string        // HMAC-SHA256 key for signing deliveries, empty sends them unsigned
+	Timeout       time.Duration // Upper bound on each delivery attempt
+	MaxAttempts   int           // Attempts before a delivery is marked failed
+	RetryBackoff  time.Duration // Delay before the first retry, doubling after each
+	Workers       int           // Concurrent deliveries
+	QueueSize     int           // Deliveries waiting for a worker
+	AllowedHosts  []string      // Host names, IPs and CIDRs deliveries may reach, empty allows public addresses only
 }
 
 // LoggingConfig holds logging configuration.
 type LoggingConfig struct {
-	Level string
+	Level         string
+	Output        string        // "stdout", "stderr" or a file path
+	SampleRate    int           // Log 1 in N successful requests, 1 = all
+	SlowThreshold time.Duration // Requests at least this slow are always logged
 }
 
 // Load loads configuration from environment variables.
 func Load() *Config {
+	// REDIS_TIMEOUT remains the default for each of the split Redis timeouts
+	redisTimeout := getEnvDuration("REDIS_TIMEOUT", 5*time.Second)
+	// AGENT_MEMORY_URL serves both memory types unless either is split out
+	memoryURL := getEnv("AGENT_MEMORY_URL", "http://localhost:8081")
+
 	return &Config{
 		Server: ServerConfig{
-			Host: getEnv("SERVER_HOST", "0.0.0.0"),
-			Port: getEnv("SERVER_PORT", "8080"),
+			Host:               getEnv("SERVER_HOST", "0.0.0.0"),
+			Port:               getEnv("SERVER_PORT", "8080"),
+			InternalHost:       getEnv("INTERNAL_HOST", "0.0.0.0"),
+			InternalPort:       getEnv("INTERNAL_PORT", ""),
+			MetricsToken:       getEnv("METRICS_TOKEN", ""),
+			CORSAllowedOrigins: getEnvList("CORS_ALLOWED_ORIGINS", nil),
+			ShutdownTimeout:    getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+			DrainDelay:         getEnvDuration("DRAIN_DELAY", 15*time.Second),
+			DrainTimeout:       getEnvDuration("DRAIN_TIMEOUT", 10*time.Minute),
+			IdempotencyTTL:     getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+			MaxRequestBody:     int64(getEnvInt("MAX_REQUEST_BODY", 1<<20)),
+			MaxMemoryBody:      int64(getEnvInt("MAX_MEMORY_BODY", 16<<20)),
+			RegisterRateLimit:  getEnvInt("REGISTER_RATE_LIMIT", 0),
+			RegisterRateBurst:  getEnvInt("REGISTER_RATE_BURST", 10),
+			TrustedProxies:     getEnvList("TRUSTED_PROXIES", nil),
+			IDStrategy:         getEnv("ID_STRATEGY", "uuid"),
+		},
+		Health: HealthConfig{
+			CacheTTL:       getEnvDuration("HEALTH_CACHE_TTL", 1*time.Second),
+			MinReadyAgents: getEnvInt("MIN_READY_AGENTS", 0),
 		},
 		Redis: RedisConfig{
-			StandardURL: getEnv("REDIS_STANDARD_URL", "redis://localhost:6379"),
-			PubSubURL:   getEnv("REDIS_PUBSUB_URL", "redis://localhost:6380"),
-			PoolSize:    getEnvInt("REDIS_POOL_SIZE", 10),
-			MinIdleConn: getEnvInt("REDIS_MIN_IDLE_CONN", 5),
-			Timeout:     getEnvDuration("REDIS_TIMEOUT", 5*time.Second),
+			StandardURL:       getEnv("REDIS_STANDARD_URL", "redis://localhost:6379"),
+			PubSubURL:         getEnv("REDIS_PUBSUB_URL", "redis://localhost:6380"),
+			SeparatePubSub:    getEnvBool("REDIS_PUBSUB_SEPARATE", false),
+			PoolSize:          getEnvInt("REDIS_POOL_SIZE", 10),
+			MinIdleConn:       getEnvInt("REDIS_MIN_IDLE_CONN", 5),
+			DialTimeout:       getEnvDuration("REDIS_DIAL_TIMEOUT", redisTimeout),
+			ReadTimeout:       getEnvDuration("REDIS_READ_TIMEOUT", redisTimeout),
+			WriteTimeout:      getEnvDuration("REDIS_WRITE_TIMEOUT", redisTimeout),
+			PubSubReadTimeout: getEnvDuration("REDIS_PUBSUB_READ_TIMEOUT", getEnvDuration("REDIS_READ_TIMEOUT", redisTimeout)),
+			OpTimeout:         getEnvDuration("REDIS_OP_TIMEOUT", redisTimeout),
+			PubSubKeepalive:   getEnvDuration("REDIS_PUBSUB_KEEPALIVE", 30*time.Second),
+			StartupAttempts:   getEnvInt("REDIS_STARTUP_ATTEMPTS", 5),
+			StartupBackoff:    getEnvDuration("REDIS_STARTUP_BACKOFF", 1*time.Second),
+			StartupTimeout:    getEnvDuration("REDIS_STARTUP_TIMEOUT", 5*time.Second),
 		},
 		Memory: MemoryConfig{
-			URL:     getEnv("AGENT_MEMORY_URL", "http://localhost:8081"),
-			Timeout: getEnvDuration("AGENT_MEMORY_TIMEOUT", 10*time.Second),
+			URL:          memoryURL,
+			ShortTermURL: cmp.Or(os.Getenv("SHORT_TERM_MEMORY_URL"), memoryURL),
+			LongTermURL:  cmp.Or(os.Getenv("LONG_TERM_MEMORY_URL"), memoryURL),
+			Timeout:      getEnvDuration("AGENT_MEMORY_TIMEOUT", 10*time.Second),
+
+			OffloadBackend:   getEnv("MEMORY_OFFLOAD_BACKEND", "inline"),
+			OffloadThreshold: getEnvInt("MEMORY_OFFLOAD_THRESHOLD", 1<<20),
+			AppendMaxChunks:  getEnvInt("MEMORY_APPEND_MAX_CHUNKS", 10000),
+			AppendTTL:        getEnvDuration("MEMORY_APPEND_TTL", 0),
+			S3: S3Config{
+				Endpoint:        getEnv("S3_ENDPOINT", ""),
+				Bucket:          getEnv("S3_BUCKET", ""),
+				Region:          getEnv("S3_REGION", "us-east-1"),
+				AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+				SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+				Timeout:         getEnvDuration("S3_TIMEOUT", 30*time.Second),
+			},
+		},
+		Auth: AuthConfig{
+			APIKeys:     getEnvList("API_KEYS", nil),
+			APIKeysFile: getEnv("API_KEYS_FILE", ""),
+		},
+		Registry: RegistryConfig{
+			MaxAgents:       getEnvInt("MAX_AGENTS", 0),
+			AgentTypes:      getEnvList("AGENT_TYPES", nil),
+			EventsMax:       int64(getEnvInt("REGISTRY_EVENTS_MAX", 10000)),
+			StoreBackend:    getEnv("STORE_BACKEND", "redis"),
+			SweepInterval:   getEnvDuration("REGISTRY_SWEEP_INTERVAL", 30*time.Second),
+			HeartbeatJitter: getEnvDuration("HEARTBEAT_TTL_JITTER", 30*time.Second),
+
+			MaxRetentionMessages: getEnvInt("RETENTION_MAX_MESSAGES", 10000),
+			MaxRetentionTTL:      getEnvDuration("RETENTION_MAX_TTL", 7*24*time.Hour),
+		},
+		Messaging: MessagingConfig{
+			AllowedTypes:         getEnvList("MESSAGE_ALLOWED_TYPES", nil),
+			CompressionThreshold: getEnvInt("MESSAGE_COMPRESSION_THRESHOLD", 1024),
+			DefaultTTL:           getEnvDuration("MESSAGE_DEFAULT_TTL", 0),
+			StoreAndForward:      getEnvBool("MESSAGE_STORE_AND_FORWARD", false),
+			Persist:              getEnvBool("MESSAGE_PERSIST", true),
+			QueueMax:             getEnvInt("MESSAGE_QUEUE_MAX", 1000),
+			VisibilityTimeout:    getEnvDuration("MESSAGE_VISIBILITY_TIMEOUT", 0),
+			RedeliveryInterval:   getEnvDuration("MESSAGE_REDELIVERY_INTERVAL", 5*time.Second),
+			MaxDeliveries:        getEnvInt("MESSAGE_MAX_DELIVERIES", 0),
+			ExpirySweepInterval:  getEnvDuration("MESSAGE_EXPIRY_SWEEP_INTERVAL", 5*time.Second),
+			Backend:              getEnv("MESSAGE_BACKEND", "redis"),
+			NATSURL:              getEnv("NATS_URL", "nats://localhost:4222"),
+			DefaultHistoryLimit:  getEnvInt("DEFAULT_HISTORY_LIMIT", 50),
+			MaxHistoryLimit:      getEnvInt("MAX_HISTORY_LIMIT", 100),
+			DrainNotify:          getEnvBool("MESSAGE_DRAIN_NOTIFY", false),
+			PeerWindow:           getEnvDuration("MESSAGE_PEER_WINDOW", 10*time.Minute),
+			AllowFields:          getEnvList("MESSAGE_ALLOW_FIELDS", nil),
+			RedactFields:         getEnvList("MESSAGE_REDACT_FIELDS", nil),
+			TypeRoutes:           getEnvList("MESSAGE_TYPE_ROUTES", nil),
+
+			DirectChannel:    getEnv("MESSAGE_CHANNEL_DIRECT", "agent:message:{id}"),
+			TopicChannel:     getEnv("MESSAGE_CHANNEL_TOPIC", "agent:topic:{name}"),
+			GroupChannel:     getEnv("MESSAGE_CHANNEL_GROUP", "agent:group:{name}"),
+			BroadcastChannel: getEnv("MESSAGE_CHANNEL_BROADCAST", "agent:broadcast"),
+		},
+		Stream: StreamConfig{
+			HeartbeatInterval: getEnvDuration("STREAM_HEARTBEAT_INTERVAL", 1*time.Minute),
+			BufferSize:        getEnvInt("STREAM_BUFFER_SIZE", 256),
+			OverflowPolicy:    getEnv("STREAM_OVERFLOW_POLICY", "drop_oldest"),
+			MaxConnections:    getEnvInt("STREAM_MAX_CONNECTIONS", 10000),
+			MaxPerAgent:       getEnvInt("STREAM_MAX_PER_AGENT", 10),
+		},
+		Webhook: WebhookConfig{
+			SigningSecret: getEnv("WEBHOOK_SIGNING_SECRET", ""),
+			Timeout:       getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+			MaxAttempts:   getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+			RetryBackoff:  getEnvDuration("WEBHOOK_RETRY_BACKOFF", 1*time.Second),
+			Workers:       getEnvInt("WEBHOOK_WORKERS", 4),
+			QueueSize:     getEnvInt("WEBHOOK_QUEUE_SIZE", 1000),
+			AllowedHosts:  getEnvList("WEBHOOK_ALLOWED_HOSTS", nil),
 		},
 		Logging: LoggingConfig{
-			Level: getEnv("LOG_LEVEL", "info"),
+			Level:         getEnv("LOG_LEVEL", "info"),
+			Output:        getEnv("LOG_OUTPUT", "stderr"),
+			SampleRate:    getEnvInt("LOG_SAMPLE_RATE", 1),
+			SlowThreshold: getEnvDuration("LOG_SLOW_THRESHOLD", time.Second),
 		},
 	}
 }
 
+// Validate reports settings the hub cannot run with, so it refuses to start
+// rather than failing once they are first used.
+func (c *Config) Validate() error {
+	if c.Redis.StartupTimeout <= 0 {
+		return fmt.Errorf("REDIS_STARTUP_TIMEOUT must be positive, got %v", c.Redis.StartupTimeout)
+	}
+	if c.Server.DrainTimeout != 0 && c.Server.DrainTimeout < c.Server.DrainDelay {
+		return fmt.Errorf("DRAIN_TIMEOUT must be 0 or at least DRAIN_DELAY (%v), got %v", c.Server.DrainDelay, c.Server.DrainTimeout)
+	}
+	if c.Stream.HeartbeatInterval <= 0 {
+		return fmt.Errorf("STREAM_HEARTBEAT_INTERVAL must be positive, got %v", c.Stream.HeartbeatInterval)
+	}
+	switch c.Stream.OverflowPolicy {
+	case "drop_oldest", "disconnect":
+	default:
+		return fmt.Errorf("STREAM_OVERFLOW_POLICY must be \"drop_oldest\" or \"disconnect\", got %q", c.Stream.OverflowPolicy)
+	}
+	if c.Messaging.MaxHistoryLimit <= 0 {
+		return fmt.Errorf("MAX_HISTORY_LIMIT must be positive, got %d", c.Messaging.MaxHistoryLimit)
+	}
+	if c.Messaging.DefaultHistoryLimit <= 0 || c.Messaging.DefaultHistoryLimit > c.Messaging.MaxHistoryLimit {
+		return fmt.Errorf("DEFAULT_HISTORY_LIMIT must be between 1 and MAX_HISTORY_LIMIT (%d), got %d", c.Messaging.MaxHistoryLimit, c.Messaging.DefaultHistoryLimit)
+	}
+	return nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -81,6 +364,15 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	if value, exists := os.LookupEnv(key); exists {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -89,3 +381,16 @@ func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+func getEnvList(key string, defaultValue []string) []string {
+	if value, exists := os.LookupEnv(key); exists && value != "" {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return defaultValue
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Redacted replaces secrets in sanitized configuration.
+const Redacted = "REDACTED"
+
+// sensitiveParams are URL query parameters treated as credentials.
+var sensitiveParams = []string{"password", "pass", "pwd", "token", "secret", "key", "auth", "credential", "signature"}
+
+// Sanitized returns a copy of the configuration that is safe to show to
+// operators: credentials in URLs, API keys, tokens and S3 credentials are
+// replaced by Redacted.
+func (c *Config) Sanitized() *Config {
+	out := *c
+
+	out.Server.MetricsToken = redactValue(c.Server.MetricsToken)
+
+	out.Redis.StandardURL = redactURL(c.Redis.StandardURL)
+	out.Redis.PubSubURL = redactURL(c.Redis.PubSubURL)
+
+	out.Memory.URL = redactURL(c.Memory.URL)
+	out.Memory.ShortTermURL = redactURL(c.Memory.ShortTermURL)
+	out.Memory.LongTermURL = redactURL(c.Memory.LongTermURL)
+	out.Memory.S3.Endpoint = redactURL(c.Memory.S3.Endpoint)
+	out.Memory.S3.AccessKeyID = redactValue(c.Memory.S3.AccessKeyID)
+	out.Memory.S3.SecretAccessKey = redactValue(c.Memory.S3.SecretAccessKey)
+
+	out.Messaging.NATSURL = redactURL(c.Messaging.NATSURL)
+
+	out.Webhook.SigningSecret = redactValue(c.Webhook.SigningSecret)
+
+	// Keep each key's role and agent binding, which are useful when checking
+	// permissions, but not the key itself
+	out.Auth.APIKeys = make([]string, len(c.Auth.APIKeys))
+	for i, entry := range c.Auth.APIKeys {
+		_, rest, found := strings.Cut(entry, ":")
+		if found {
+			out.Auth.APIKeys[i] = Redacted + ":" + rest
+		} else {
+			out.Auth.APIKeys[i] = Redacted
+		}
+	}
+
+	return &out
+}
+
+// redactValue hides a secret, leaving empty values visible so operators can
+// tell whether it is set.
+func redactValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// redactURL hides the user info and credential query parameters of a URL,
+// keeping the scheme, host and path. Values that can't be parsed as URLs are
+// hidden entirely, as they may be credentials in an unexpected form.
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Opaque != "" {
+		return Redacted
+	}
+
+	if u.User != nil {
+		// A bare user name may itself be a token, e.g. nats://token@host
+		u.User = url.User(Redacted)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			if isSensitiveParam(name) {
+				query.Set(name, Redacted)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String()
+}
+
+// isSensitiveParam reports whether a query parameter name looks like it
+// holds a credential.
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveParams {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
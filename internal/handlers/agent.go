@@ -4,31 +4,44 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"agent-comm-hub/internal/auth"
 	"agent-comm-hub/internal/models"
 	"agent-comm-hub/internal/services/registry"
 )
 
+// maxBulkHeartbeat caps the agents heartbeated by one bulk request.
+const maxBulkHeartbeat = 1000
+
+// reservedNameMessage explains why an agent name was rejected as reserved.
+const reservedNameMessage = "agent name is reserved: broadcast and names starting with topic:, group: or capability: address channels"
+
 // AgentHandler handles agent-related HTTP requests.
 type AgentHandler struct {
-	registry *registry.AgentRegistry
+	registry registry.Registry
 }
 
 // NewAgentHandler creates a new agent handler.
-func NewAgentHandler(registry *registry.AgentRegistry) *AgentHandler {
+func NewAgentHandler(registry registry.Registry) *AgentHandler {
 	return &AgentHandler{
 		registry: registry,
 	}
 }
 
 // Register handles POST /api/v1/agents - Register a new agent.
+// Re-registering an existing name and type is idempotent and returns 200.
 func (h *AgentHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req models.RegisterAgentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
@@ -38,39 +51,256 @@ func (h *AgentHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	agent, err := h.registry.Register(r.Context(), &req)
+	agent, created, err := h.registry.Register(r.Context(), &req)
+	if errors.Is(err, registry.ErrInvalidType) {
+		http.Error(w, "invalid agent type", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, registry.ErrAgentExists) {
+		http.Error(w, "agent name already registered with a different type", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, registry.ErrAgentLimit) {
+		http.Error(w, "maximum number of agents reached", http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, registry.ErrReservedName) {
+		http.Error(w, reservedNameMessage, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, registry.ErrInvalidDelivery) || errors.Is(err, registry.ErrInvalidRetention) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(models.RegisterAgentResponse{
-		ID:     agent.ID,
-		Status: agent.Status,
-	})
+	w.Header().Set("Location", "/api/v1/agents/"+agent.ID)
+	w.Header().Set("X-Created", strconv.FormatBool(created))
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(agent)
 }
 
 // List handles GET /api/v1/agents - List all agents.
+// Large fleets can be listed without buffering: "Accept: application/x-ndjson"
+// streams one agent per line, and ?stream=true streams the usual JSON
+// response incrementally. Buffered lists are ordered by ?sort= (name,
+// created_at or last_seen; default created_at) and ?order= (asc or desc),
+// and paged by ?offset= and ?limit= (default unlimited); streamed lists are
+// unordered and unpaged. Agents whose records can't be loaded are left out
+// and counted as skipped, unless ?strict=true, which fails the request.
+// ?fields= limits each agent to the listed fields.
 func (h *AgentHandler) List(w http.ResponseWriter, r *http.Request) {
-	agents, err := h.registry.List(r.Context())
+	query := &models.AgentQuery{
+		Capabilities: r.URL.Query()["capability"],
+		Match:        models.CapabilityMatch(r.URL.Query().Get("match")),
+		Strict:       r.URL.Query().Get("strict") == "true",
+	}
+	switch query.Match {
+	case "":
+		query.Match = models.MatchAll
+	case models.MatchAll, models.MatchAny:
+	default:
+		http.Error(w, "match must be all or any", http.StatusBadRequest)
+		return
+	}
+
+	query.Sort = models.AgentSort(r.URL.Query().Get("sort"))
+	switch query.Sort {
+	case "":
+		query.Sort = models.SortByCreatedAt
+	case models.SortByName, models.SortByCreatedAt, models.SortByLastSeen:
+	default:
+		http.Error(w, "sort must be name, created_at or last_seen", http.StatusBadRequest)
+		return
+	}
+
+	switch r.URL.Query().Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		http.Error(w, "order must be asc or desc", http.StatusBadRequest)
+		return
+	}
+
+	offset, ok := queryOffset(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	fields, ok := queryFields(w, r)
+	if !ok {
+		return
+	}
+
+	// The registry version changes with every write, so an unchanged
+	// version means an unchanged listing
+	version, err := h.registry.Version(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if notModified(w, r, listETag(version, r.Header.Get("Accept")+"?"+r.URL.RawQuery)) {
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		h.streamNDJSON(w, r, query, fields)
+		return
+	}
+	if r.URL.Query().Get("stream") == "true" {
+		h.streamJSON(w, r, query, fields)
+		return
+	}
+
+	agents, skipped, err := h.registry.List(r.Context(), query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	page := models.Page(agents, offset, limit)
+	page.Skipped = skipped
+	now := time.Now()
+	items := make([]any, len(page.Items))
+	for i := range page.Items {
+		if items[i], err = fields.view(page.Items[i].View(now)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.AgentListResponse{
-		Agents: agents,
-		Count:  len(agents),
+	json.NewEncoder(w).Encode(models.PagedResponse[any]{
+		Items:   items,
+		Total:   page.Total,
+		Limit:   page.Limit,
+		Offset:  page.Offset,
+		Skipped: page.Skipped,
 	})
 }
 
-// Get handles GET /api/v1/agents/:id - Get agent details.
+// streamNDJSON writes matching agents as newline-delimited JSON.
+func (h *AgentHandler) streamNDJSON(w http.ResponseWriter, r *http.Request, query *models.AgentQuery, fields fieldSet) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+
+	now := time.Now()
+	err := h.registry.Each(r.Context(), query, func(agent *models.Agent) error {
+		view, err := fields.view(agent.View(now))
+		if err != nil {
+			return err
+		}
+		return enc.Encode(view)
+	})
+	if err != nil {
+		// The status has already been sent; the client sees a truncated body
+		log.Printf("Warning: agent list stream failed: %v", err)
+	}
+}
+
+// streamJSON writes matching agents as a PagedResponse, one agent at a time,
+// with the total trailing the items array.
+func (h *AgentHandler) streamJSON(w http.ResponseWriter, r *http.Request, query *models.AgentQuery, fields fieldSet) {
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"items":[`)
+
+	count := 0
+	now := time.Now()
+	err := h.registry.Each(r.Context(), query, func(agent *models.Agent) error {
+		view, err := fields.view(agent.View(now))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(view)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			io.WriteString(w, ",")
+		}
+		count++
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		// The status has already been sent; the client sees invalid JSON
+		log.Printf("Warning: agent list stream failed: %v", err)
+		return
+	}
+
+	fmt.Fprintf(w, "],\"total\":%d,\"limit\":0,\"offset\":0}\n", count)
+}
+
+// ListTypes handles GET /api/v1/agent-types - Count agents per type.
+func (h *AgentHandler) ListTypes(w http.ResponseWriter, r *http.Request) {
+	types, err := h.registry.ListTypes(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Page(types, 0, 0))
+}
+
+// ListEvents handles GET /api/v1/registry/events - Query registry event history.
+func (h *AgentHandler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	query := models.RegistryEventQuery{
+		Type:   models.RegistryEventType(r.URL.Query().Get("event_type")),
+		Cursor: r.URL.Query().Get("cursor"),
+		Limit:  50,
+	}
+
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			http.Error(w, "since must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		query.Since = since
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			query.Limit = l
+		}
+	}
+
+	events, next, err := h.registry.ListEvents(r.Context(), query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PagedResponse[models.RegistryEvent]{
+		Items:      events,
+		Total:      models.TotalUnknown,
+		Limit:      query.Limit,
+		NextCursor: next,
+	})
+}
+
+// Get handles GET /api/v1/agents/:id - Get agent details. ?fields= limits
+// the response to the listed fields.
 func (h *AgentHandler) Get(w http.ResponseWriter, r *http.Request) {
 	agentID := chi.URLParam(r, "id")
 
+	fields, ok := queryFields(w, r)
+	if !ok {
+		return
+	}
+
 	agent, err := h.registry.Get(r.Context(), agentID)
 	if err != nil {
 		if errors.Is(err, registry.ErrAgentNotFound) {
@@ -81,21 +311,25 @@ func (h *AgentHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if notModified(w, r, agentETag(agent)) {
+		return
+	}
+	view, err := fields.view(agent.View(time.Now()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(agent)
+	json.NewEncoder(w).Encode(view)
 }
 
-// Update handles PUT /api/v1/agents/:id - Update agent.
-func (h *AgentHandler) Update(w http.ResponseWriter, r *http.Request) {
+// Profile handles GET /api/v1/agents/:id/profile - Get an agent's public
+// profile. Unlike Get it is open to any authenticated caller.
+func (h *AgentHandler) Profile(w http.ResponseWriter, r *http.Request) {
 	agentID := chi.URLParam(r, "id")
 
-	var req models.UpdateAgentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	agent, err := h.registry.Update(r.Context(), agentID, &req)
+	agent, err := h.registry.Get(r.Context(), agentID)
 	if err != nil {
 		if errors.Is(err, registry.ErrAgentNotFound) {
 			http.Error(w, "agent not found", http.StatusNotFound)
@@ -105,10 +339,117 @@ func (h *AgentHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agent.Profile())
+}
+
+// Update handles PUT /api/v1/agents/:id - Replace agent. With If-Match, the
+// agent is only replaced if its current ETag matches.
+func (h *AgentHandler) Update(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	var req models.UpdateAgentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// Validate required fields
+	if req.Name == "" || req.Type == "" {
+		http.Error(w, "name and type are required", http.StatusBadRequest)
+		return
+	}
+
+	agent, err := h.registry.Replace(r.Context(), agentID, &req, ifMatch(r))
+	if err != nil {
+		h.writeUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", agentETag(agent))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agent)
+}
+
+// Patch handles PATCH /api/v1/agents/:id - Partially update agent. With
+// If-Match, the agent is only updated if its current ETag matches.
+func (h *AgentHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	var req models.PatchAgentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	agent, err := h.registry.Patch(r.Context(), agentID, &req, ifMatch(r))
+	if err != nil {
+		h.writeUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", agentETag(agent))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agent)
+}
+
+// AddCapabilities handles POST /api/v1/agents/:id/capabilities - Add
+// capabilities to an agent without replacing the ones it has.
+func (h *AgentHandler) AddCapabilities(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	var req models.AddCapabilitiesRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Capabilities) == 0 {
+		http.Error(w, "capabilities are required", http.StatusBadRequest)
+		return
+	}
+
+	agent, err := h.registry.AddCapabilities(r.Context(), agentID, req.Capabilities)
+	if err != nil {
+		h.writeUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agent)
+}
+
+// RemoveCapability handles DELETE /api/v1/agents/:id/capabilities/:capability -
+// Remove a capability from an agent.
+func (h *AgentHandler) RemoveCapability(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	agent, err := h.registry.RemoveCapability(r.Context(), agentID, chi.URLParam(r, "capability"))
+	if err != nil {
+		h.writeUpdateError(w, err)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(agent)
 }
 
+// writeUpdateError maps registry update errors to HTTP responses.
+func (h *AgentHandler) writeUpdateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, registry.ErrAgentNotFound):
+		http.Error(w, "agent not found", http.StatusNotFound)
+	case errors.Is(err, registry.ErrAgentExists):
+		http.Error(w, "agent name already taken", http.StatusConflict)
+	case errors.Is(err, registry.ErrInvalidType):
+		http.Error(w, "invalid agent type", http.StatusBadRequest)
+	case errors.Is(err, registry.ErrReservedName):
+		http.Error(w, reservedNameMessage, http.StatusBadRequest)
+	case errors.Is(err, registry.ErrInvalidUpdate), errors.Is(err, registry.ErrInvalidCapability), errors.Is(err, registry.ErrInvalidDelivery), errors.Is(err, registry.ErrInvalidRetention), errors.Is(err, registry.ErrInvalidStatus):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, registry.ErrPreconditionFailed):
+		http.Error(w, "agent has been modified", http.StatusPreconditionFailed)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 // Delete handles DELETE /api/v1/agents/:id - Unregister agent.
 func (h *AgentHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	agentID := chi.URLParam(r, "id")
@@ -126,11 +467,73 @@ func (h *AgentHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Liveness handles GET /api/v1/agents/status?type= - Report whether each
+// agent, optionally of one type, is online, from heartbeat presence alone.
+func (h *AgentHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	agentType := r.URL.Query().Get("type")
+
+	statuses, err := h.registry.Liveness(r.Context(), agentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AgentLivenessResponse{
+		Type:   agentType,
+		Agents: statuses,
+		Count:  len(statuses),
+	})
+}
+
+// DeleteByType handles DELETE /api/v1/agents?type=&confirm=true - Unregister
+// every agent of a type, releasing their messages and memory.
+func (h *AgentHandler) DeleteByType(w http.ResponseWriter, r *http.Request) {
+	agentType := r.URL.Query().Get("type")
+	if agentType == "" {
+		http.Error(w, "type is required", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("confirm") != "true" {
+		http.Error(w, "confirm=true is required to delete every agent of a type", http.StatusBadRequest)
+		return
+	}
+
+	removed, err := h.registry.UnregisterType(r.Context(), agentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.FlushTypeResponse{
+		Type:    agentType,
+		Removed: removed,
+	})
+}
+
 // Heartbeat handles POST /api/v1/agents/:id/heartbeat - Agent heartbeat.
+// An optional StatusReport body updates the agent's status and last error.
 func (h *AgentHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 	agentID := chi.URLParam(r, "id")
 
+	var report *models.StatusReport
+	if r.ContentLength != 0 {
+		report = &models.StatusReport{}
+		if err := json.NewDecoder(r.Body).Decode(report); err != nil && !errors.Is(err, io.EOF) {
+			writeDecodeError(w, err)
+			return
+		}
+		if report.Status != "" && !report.Status.Valid() {
+			http.Error(w, registry.ErrInvalidStatus.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	err := h.registry.Heartbeat(r.Context(), agentID)
+	if err == nil && report != nil {
+		_, err = h.registry.ReportStatus(r.Context(), agentID, report)
+	}
 	if err != nil {
 		if errors.Is(err, registry.ErrAgentNotFound) {
 			http.Error(w, "agent not found", http.StatusNotFound)
@@ -142,3 +545,94 @@ func (h *AgentHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// Touch handles HEAD /api/v1/agents/:id/heartbeat - Refresh the agent's
+// heartbeat without rewriting its record, so last_seen is not updated.
+// Responds 200 or 404 with no body.
+func (h *AgentHandler) Touch(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	err := h.registry.Touch(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// BulkHeartbeat handles POST /api/v1/agents/heartbeat - Refresh the
+// heartbeats of several agents in one call, for proxies that manage many
+// agents. Like HEAD /api/v1/agents/:id/heartbeat it leaves last_seen alone.
+// Agents the API key may not act as are skipped and reported as forbidden.
+func (h *AgentHandler) BulkHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req models.BulkHeartbeatRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.AgentIDs) == 0 || len(req.AgentIDs) > maxBulkHeartbeat {
+		http.Error(w, fmt.Sprintf("agent_ids must list 1 to %d agents", maxBulkHeartbeat), http.StatusBadRequest)
+		return
+	}
+
+	resp := models.BulkHeartbeatResponse{Results: make([]models.HeartbeatResult, len(req.AgentIDs))}
+	var allowed []string
+	var positions []int // Index in the results of each allowed agent
+	principal, ok := auth.FromContext(r.Context())
+	for i, agentID := range req.AgentIDs {
+		resp.Results[i] = models.HeartbeatResult{AgentID: agentID, Status: models.HeartbeatForbidden}
+		if ok && principal.CanActAs(agentID) {
+			allowed = append(allowed, agentID)
+			positions = append(positions, i)
+		}
+	}
+
+	if len(allowed) > 0 {
+		refreshed, err := h.registry.TouchMany(r.Context(), allowed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for j, i := range positions {
+			resp.Results[i].Status = models.HeartbeatNotFound
+			if refreshed[j] {
+				resp.Results[i].Status = models.HeartbeatOK
+				resp.Refreshed++
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ReportStatus handles POST /api/v1/agents/:id/status - Report agent status and last error.
+func (h *AgentHandler) ReportStatus(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	var report models.StatusReport
+	if !decodeJSON(w, r, &report) {
+		return
+	}
+
+	agent, err := h.registry.ReportStatus(r.Context(), agentID, &report)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, registry.ErrInvalidStatus) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agent)
+}
@@ -0,0 +1,27 @@
+// Package handlers provides HTTP request handlers.
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"agent-comm-hub/internal/config"
+)
+
+// ConfigHandler serves the hub's effective configuration.
+type ConfigHandler struct {
+	cfg *config.Config
+}
+
+// NewConfigHandler creates a new config handler. Secrets are redacted once,
+// up front.
+func NewConfigHandler(cfg *config.Config) *ConfigHandler {
+	return &ConfigHandler{cfg: cfg.Sanitized()}
+}
+
+// Get handles GET /api/v1/config - Get the loaded configuration with secrets
+// redacted.
+func (h *ConfigHandler) Get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.cfg)
+}
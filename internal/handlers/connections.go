@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"agent-comm-hub/internal/models"
+)
+
+// connections tracks the streams open on this hub instance, so operators can
+// tell a connected agent from one that is only registered.
+type connections struct {
+	mu    sync.Mutex
+	next  uint64
+	conns map[uint64]models.StreamConnection
+}
+
+func newConnections() *connections {
+	return &connections{conns: make(map[uint64]models.StreamConnection)}
+}
+
+// add records an open stream and returns a function that removes it.
+func (c *connections) add(conn models.StreamConnection) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.next++
+	id := c.next
+	c.conns[id] = conn
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.conns, id)
+	}
+}
+
+// list returns the open streams, oldest first.
+func (c *connections) list() []models.StreamConnection {
+	c.mu.Lock()
+	list := make([]models.StreamConnection, 0, len(c.conns))
+	for _, conn := range c.conns {
+		list = append(list, conn)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ConnectedAt.Before(list[j].ConnectedAt)
+	})
+	return list
+}
+
+// Connections handles GET /api/v1/debug/connections - List the WebSocket
+// streams open on this hub instance, with the agents they belong to and when
+// they connected. Other replicas' connections are not included.
+func (h *StreamHandler) Connections(w http.ResponseWriter, r *http.Request) {
+	list := h.conns.list()
+
+	agents := []string{}
+	seen := make(map[string]bool)
+	for _, conn := range list {
+		if conn.AgentID != "" && !seen[conn.AgentID] {
+			seen[conn.AgentID] = true
+			agents = append(agents, conn.AgentID)
+		}
+	}
+	sort.Strings(agents)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ConnectionsResponse{
+		Connections: list,
+		Count:       len(list),
+		Agents:      agents,
+	})
+}
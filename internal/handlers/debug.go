@@ -0,0 +1,69 @@
+// Package handlers provides HTTP request handlers.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"agent-comm-hub/internal/models"
+	"agent-comm-hub/internal/services/redis"
+	"agent-comm-hub/internal/services/registry"
+)
+
+// maxDebugKeys caps the keys returned by a debug key listing.
+const maxDebugKeys = 1000
+
+// DebugHandler serves troubleshooting views of the hub's Redis state.
+type DebugHandler struct {
+	redisManager *redis.Manager
+	registry     registry.Registry
+	redisIndexes bool // Whether the registry keeps its indexes in Redis
+}
+
+// NewDebugHandler creates a new debug handler. storeBackend is the registry
+// store backend, which decides whether agent indexes are looked up in Redis.
+func NewDebugHandler(redisManager *redis.Manager, agents registry.Registry, storeBackend string) *DebugHandler {
+	return &DebugHandler{
+		redisManager: redisManager,
+		registry:     agents,
+		redisIndexes: storeBackend == registry.StoreRedis,
+	}
+}
+
+// AgentKeys handles GET /api/v1/debug/agents/:id/keys - List the Redis keys
+// associated with an agent, with their types and TTLs. Keys left behind by
+// an agent that no longer exists are listed too.
+func (h *DebugHandler) AgentKeys(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	keys, truncated, err := h.redisManager.ScanKeys(r.Context(), agentID, maxDebugKeys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	indexes := []string{}
+	agent, err := h.registry.Get(r.Context(), agentID)
+	if err != nil && !errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if agent != nil && h.redisIndexes {
+		indexes, err = registry.RedisIndexes(r.Context(), h.redisManager.Standard(), agent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AgentKeysResponse{
+		AgentID:   agentID,
+		Keys:      keys,
+		Indexes:   indexes,
+		Truncated: truncated,
+	})
+}
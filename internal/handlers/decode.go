@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// decodeJSON decodes a JSON request body into v, writing an error response
+// and reporting false if it can't. Bodies over the limit set by the
+// MaxBodySize middleware are rejected with 413.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeDecodeError(w, err)
+		return false
+	}
+	return true
+}
+
+// writeDecodeError responds to a request body that failed to decode.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "invalid request body", http.StatusBadRequest)
+}
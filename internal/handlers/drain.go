@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"agent-comm-hub/internal/models"
+)
+
+// errDraining fails readiness while the instance drains.
+var errDraining = errors.New("draining")
+
+// DrainHandler puts this hub instance into draining mode for maintenance:
+// new registrations and streams are refused and readiness fails, so the load
+// balancer takes the instance out of rotation, while open streams are served
+// until they close or the drain times out.
+type DrainHandler struct {
+	stream  *StreamHandler
+	delay   time.Duration
+	timeout time.Duration
+
+	mu      sync.Mutex
+	since   *time.Time
+	drained chan struct{} // Closed once the instance may shut down
+}
+
+// NewDrainHandler creates a new drain handler. A draining instance waits at
+// least delay before it is drained, giving load balancers time to see
+// readiness fail, and at most timeout, if positive, for its streams to close.
+func NewDrainHandler(stream *StreamHandler, delay, timeout time.Duration) *DrainHandler {
+	return &DrainHandler{
+		stream:  stream,
+		delay:   delay,
+		timeout: timeout,
+		drained: make(chan struct{}),
+	}
+}
+
+// Drained returns a channel that is closed once the instance is draining,
+// the delay has passed and its streams have closed, or the timeout is up.
+// Streams still open then are closed by StreamHandler.Shutdown.
+func (h *DrainHandler) Drained() <-chan struct{} {
+	return h.drained
+}
+
+// Draining reports whether the instance is draining.
+func (h *DrainHandler) Draining() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.since != nil
+}
+
+// Check is a readiness check that fails while the instance is draining.
+func (h *DrainHandler) Check(ctx context.Context) error {
+	if h.Draining() {
+		return errDraining
+	}
+	return nil
+}
+
+// RefuseNew is middleware that rejects requests with 503 while the instance
+// is draining, for endpoints that start new work such as registrations.
+func (h *DrainHandler) RefuseNew(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Draining() {
+			http.Error(w, "server draining", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Drain handles POST /api/v1/admin/drain - Put this instance into draining
+// mode. Draining cannot be undone; the instance shuts down once drained.
+func (h *DrainHandler) Drain(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	if h.since == nil {
+		now := models.Now()
+		h.since = &now
+		go h.wait(h.stream.Drain())
+	}
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(h.status())
+}
+
+// wait closes drained once the delay has passed and streams is closed, or
+// when the drain times out.
+func (h *DrainHandler) wait(streams <-chan struct{}) {
+	var timeout <-chan time.Time
+	if h.timeout > 0 {
+		timeout = time.After(h.timeout)
+	}
+	time.Sleep(h.delay)
+
+	select {
+	case <-streams:
+	case <-timeout:
+		log.Printf("Warning: drain timed out after %v with %d streams open, closing them", h.timeout, h.stream.OpenStreams())
+	}
+	close(h.drained)
+}
+
+// Status handles GET /api/v1/admin/drain - Report whether this instance is
+// draining and how many streams it still has open.
+func (h *DrainHandler) Status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.status())
+}
+
+func (h *DrainHandler) status() models.DrainStatus {
+	h.mu.Lock()
+	since := h.since
+	h.mu.Unlock()
+
+	return models.DrainStatus{
+		Draining:    since != nil,
+		Since:       since,
+		OpenStreams: h.stream.OpenStreams(),
+	}
+}
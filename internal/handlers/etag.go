@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"agent-comm-hub/internal/models"
+	"agent-comm-hub/internal/services/registry"
+)
+
+// agentETag returns a weak ETag derived from an agent's stored state. It is
+// weak because responses also carry fields derived from the current time,
+// such as age_seconds, so two responses with the same tag are equivalent
+// rather than byte for byte identical.
+func agentETag(agent *models.Agent) string {
+	data, _ := json.Marshal(agent)
+	sum := sha256.Sum256(data)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// listETag returns a weak ETag for an agent listing, derived from the
+// registry version and the query string that shaped the response.
+func listETag(version int64, rawQuery string) string {
+	sum := sha256.Sum256([]byte(rawQuery))
+	return fmt.Sprintf(`W/"%d-%s"`, version, hex.EncodeToString(sum[:8]))
+}
+
+// etagMatches reports whether a conditional header such as If-None-Match
+// lists the ETag. Weak and strong forms of a tag are treated as equal.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch returns a precondition requiring the agent's ETag to match the
+// request's If-Match header, or nil if the request has none. Agent ETags are
+// weak, so the tags are compared by their opaque value: a tag identifies
+// the agent's stored state, which is what the update is conditional on.
+func ifMatch(r *http.Request) registry.Precondition {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	return func(agent *models.Agent) bool {
+		return etagMatches(header, agentETag(agent))
+	}
+}
+
+// notModified writes a 304 response if the request's If-None-Match matches
+// the ETag, and reports whether it did. The ETag header is set either way.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"agent-comm-hub/internal/models"
+)
+
+// agentFields holds the JSON field names of an agent as read, which are the
+// names accepted by ?fields=.
+var agentFields = jsonFieldNames(reflect.TypeOf(models.AgentView{}))
+
+// fieldSet is the set of agent fields a response is projected onto; nil
+// keeps every field.
+type fieldSet []string
+
+// queryFields parses the optional ?fields= query parameter, a comma-separated
+// list of agent JSON field names, writing a 400 and reporting false if it
+// names an unknown field.
+func queryFields(w http.ResponseWriter, r *http.Request) (fieldSet, bool) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, true
+	}
+
+	var fields fieldSet
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if !agentFields[field] {
+			http.Error(w, fmt.Sprintf("unknown field %q", field), http.StatusBadRequest)
+			return nil, false
+		}
+		fields = append(fields, field)
+	}
+	return fields, true
+}
+
+// view returns what to encode for an agent: the agent itself, or only the
+// selected fields. Selected fields that are empty and omitted from the full
+// agent are omitted here too.
+func (f fieldSet) view(agent *models.AgentView) (any, error) {
+	if f == nil {
+		return agent, nil
+	}
+
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	projected := make(map[string]json.RawMessage, len(f))
+	for _, field := range f {
+		if value, ok := all[field]; ok {
+			projected[field] = value
+		}
+	}
+	return projected, nil
+}
+
+// jsonFieldNames returns the JSON names of a struct type's fields, including
+// those of embedded structs, which encoding/json flattens.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embedded := range jsonFieldNames(field.Type) {
+				names[embedded] = true
+			}
+			continue
+		}
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
@@ -4,70 +4,198 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
+	"golang.org/x/sync/singleflight"
+
+	"agent-comm-hub/internal/config"
+	"agent-comm-hub/internal/models"
 	"agent-comm-hub/internal/services/redis"
+	"agent-comm-hub/internal/services/registry"
 )
 
-// HealthHandler handles health check requests.
+// HealthHandler handles health check requests. Check results are cached for a
+// short time and concurrent probes share a single in-flight check, so bursts
+// of probe traffic don't translate into Redis load.
 type HealthHandler struct {
 	redisManager *redis.Manager
+	cacheTTL     time.Duration
+	checks       []dependencyCheck
+
+	group    singleflight.Group
+	mu       sync.Mutex
+	cached   *healthResult
+	cachedAt time.Time
 }
 
 // NewHealthHandler creates a new health handler.
-func NewHealthHandler(redisManager *redis.Manager) *HealthHandler {
+func NewHealthHandler(redisManager *redis.Manager, cfg *config.HealthConfig) *HealthHandler {
 	return &HealthHandler{
 		redisManager: redisManager,
+		cacheTTL:     cfg.CacheTTL,
 	}
 }
 
-// HealthResponse represents a health check response.
+// Health statuses, from best to worst.
+const (
+	StatusHealthy   = "healthy"
+	StatusDegraded  = "degraded"
+	StatusUnhealthy = "unhealthy"
+)
+
+// healthRank orders health statuses by severity.
+var healthRank = map[string]int{StatusHealthy: 0, StatusDegraded: 1, StatusUnhealthy: 2}
+
+// HealthResponse represents a health check response. Status is the worst
+// status caused by a failing dependency.
 type HealthResponse struct {
 	Status    string            `json:"status"`
 	Timestamp time.Time         `json:"timestamp"`
 	Services  map[string]string `json:"services"`
 }
 
-// Handle handles health check requests.
-func (h *HealthHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
+// dependencyCheck is an additional dependency reported by the health check.
+type dependencyCheck struct {
+	name      string
+	onFailure string // Overall status when the check fails, empty for readiness checks
+	check     func(ctx context.Context) error
+}
 
-	response := HealthResponse{
-		Status:    "healthy",
-		Timestamp: time.Now(),
-		Services:  make(map[string]string),
-	}
+// AddCheck adds a dependency to the health report. When the check fails the
+// hub is at best onFailure: StatusDegraded for optional dependencies, or
+// StatusUnhealthy for critical ones, which also fails readiness. It must be
+// called before the handler serves requests.
+func (h *HealthHandler) AddCheck(name, onFailure string, check func(ctx context.Context) error) {
+	h.checks = append(h.checks, dependencyCheck{name: name, onFailure: onFailure, check: check})
+}
 
-	// Check Redis connections
-	if h.redisManager != nil {
-		if err := h.redisManager.Ping(ctx); err != nil {
-			response.Status = "degraded"
-			response.Services["redis"] = "unhealthy: " + err.Error()
-		} else {
-			response.Services["redis"] = "healthy"
+// AddReadinessCheck adds a condition the hub must meet to be ready. A
+// failing readiness check fails readiness and is reported by the health
+// check, but leaves the overall status alone, as the hub itself is healthy.
+// It must be called before the handler serves requests.
+func (h *HealthHandler) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	h.checks = append(h.checks, dependencyCheck{name: name, check: check})
+}
+
+// MinAgentsCheck returns a readiness check that passes once at least min
+// registered agents are online, judged by their heartbeats.
+func MinAgentsCheck(agents registry.Registry, required int) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		statuses, err := agents.Liveness(ctx, "")
+		if err != nil {
+			return err
+		}
+		online := 0
+		for _, status := range statuses {
+			if status == models.StatusOnline {
+				online++
+			}
+		}
+		if online < required {
+			return fmt.Errorf("%d of %d required agents online", online, required)
 		}
+		return nil
 	}
+}
+
+// healthResult is the outcome of one round of dependency checks.
+type healthResult struct {
+	response HealthResponse
+	ready    bool
+}
+
+// Handle handles health check requests.
+func (h *HealthHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	result := h.check()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(result.response)
 }
 
-// Ready checks if the service is ready to accept traffic.
+// Ready checks if the service is ready to accept traffic: it is unless a
+// critical dependency or a readiness check is failing.
 func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	if !h.check().ready {
+		http.Error(w, "service not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// check returns the cached result if it is fresh, otherwise runs the checks
+// once on behalf of all concurrent callers.
+func (h *HealthHandler) check() *healthResult {
+	h.mu.Lock()
+	if h.cached != nil && time.Since(h.cachedAt) < h.cacheTTL {
+		result := h.cached
+		h.mu.Unlock()
+		return result
+	}
+	h.mu.Unlock()
+
+	v, _, _ := h.group.Do("health", func() (interface{}, error) {
+		result := h.runChecks()
+
+		h.mu.Lock()
+		h.cached = result
+		h.cachedAt = time.Now()
+		h.mu.Unlock()
+
+		return result, nil
+	})
+	return v.(*healthResult)
+}
+
+func (h *HealthHandler) runChecks() *healthResult {
+	// Not tied to any one request, since the result is shared
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Check Redis
+	checks := h.checks
 	if h.redisManager != nil {
-		if err := h.redisManager.Ping(ctx); err != nil {
-			http.Error(w, "service not ready", http.StatusServiceUnavailable)
-			return
+		// The registry and history live in the standard Redis, so the hub
+		// can't work without it; pub/sub failures only affect live delivery
+		checks = append([]dependencyCheck{
+			{name: "redis", onFailure: StatusUnhealthy, check: h.redisManager.PingStandard},
+			{name: "pubsub", onFailure: StatusDegraded, check: h.checkPubSub},
+		}, checks...)
+	}
+
+	response := HealthResponse{
+		Status:    StatusHealthy,
+		Timestamp: models.Now(),
+		Services:  make(map[string]string),
+	}
+	ready := true
+	for _, c := range checks {
+		err := c.check(ctx)
+		switch {
+		case err == nil:
+			response.Services[c.name] = "healthy"
+		case c.onFailure == "":
+			response.Services[c.name] = "not ready: " + err.Error()
+			ready = false
+		default:
+			response.Services[c.name] = "unhealthy: " + err.Error()
+			if healthRank[c.onFailure] > healthRank[response.Status] {
+				response.Status = c.onFailure
+			}
 		}
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	return &healthResult{response: response, ready: ready && response.Status != StatusUnhealthy}
+}
+
+// checkPubSub pings the pub/sub Redis and verifies round-trip delivery.
+func (h *HealthHandler) checkPubSub(ctx context.Context) error {
+	if err := h.redisManager.PingPubSub(ctx); err != nil {
+		return err
+	}
+	return h.redisManager.CheckPubSub(ctx)
 }
@@ -17,18 +17,21 @@ import (
 // MemoryHandler handles memory-related HTTP requests.
 type MemoryHandler struct {
 	memoryMgr *memory.MemoryManager
-	registry  *registry.AgentRegistry
+	registry  registry.Registry
 }
 
 // NewMemoryHandler creates a new memory handler.
-func NewMemoryHandler(memoryMgr *memory.MemoryManager, registry *registry.AgentRegistry) *MemoryHandler {
+func NewMemoryHandler(memoryMgr *memory.MemoryManager, registry registry.Registry) *MemoryHandler {
 	return &MemoryHandler{
 		memoryMgr: memoryMgr,
 		registry:  registry,
 	}
 }
 
-// Store handles POST /api/v1/agents/:id/memory - Store memory.
+// Store handles POST /api/v1/agents/:id/memory - Store memory. Requests
+// with an invalid key, a missing value or a negative TTL are rejected with
+// 400. A long-term store with a version is only applied if the key is still
+// at that version, and is rejected with 409 otherwise.
 func (h *MemoryHandler) Store(w http.ResponseWriter, r *http.Request) {
 	agentID := chi.URLParam(r, "id")
 
@@ -44,32 +47,60 @@ func (h *MemoryHandler) Store(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req models.StoreMemoryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Validate required fields
-	if req.Key == "" {
-		http.Error(w, "key is required", http.StatusBadRequest)
+	if err := memory.ValidateKey(req.Key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Value == nil {
+		http.Error(w, "value is required", http.StatusBadRequest)
+		return
+	}
+	if err := memory.ValidateValue(req.Value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.ResolveTTL(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.TTL < 0 {
+		http.Error(w, "ttl must not be negative", http.StatusBadRequest)
 		return
 	}
 
+	var version int64
 	var storeErr error
 	switch req.MemoryType {
 	case models.MemoryTypeShortTerm:
+		if req.Version != nil {
+			http.Error(w, "version is only supported for long_term memory", http.StatusBadRequest)
+			return
+		}
 		ttl := time.Duration(req.TTL) * time.Second
 		if ttl == 0 {
 			ttl = 1 * time.Hour // Default TTL: 1 hour
 		}
 		storeErr = h.memoryMgr.StoreShortTerm(r.Context(), agentID, req.Key, req.Value, ttl)
 	case models.MemoryTypeLongTerm:
-		storeErr = h.memoryMgr.StoreLongTerm(r.Context(), agentID, req.Key, req.Value)
+		version, storeErr = h.memoryMgr.StoreLongTerm(r.Context(), agentID, req.Key, req.Value, req.Version)
 	default:
 		http.Error(w, "invalid memory_type (must be 'short_term' or 'long_term')", http.StatusBadRequest)
 		return
 	}
 
+	if errors.Is(storeErr, memory.ErrVersionConflict) {
+		http.Error(w, storeErr.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(storeErr, memory.ErrMemoryBusy) {
+		http.Error(w, storeErr.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if storeErr != nil {
 		http.Error(w, storeErr.Error(), http.StatusInternalServerError)
 		return
@@ -79,7 +110,65 @@ func (h *MemoryHandler) Store(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(models.StoreMemoryResponse{
 		Key:      req.Key,
-		StoredAt: time.Now(),
+		StoredAt: models.Now(),
+		Version:  version,
+	})
+}
+
+// Append handles POST /api/v1/agents/:id/memory/append - Append a chunk to a
+// list-style long-term memory value.
+func (h *MemoryHandler) Append(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var req models.AppendMemoryRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// Validate required fields
+	if err := memory.ValidateKey(req.Key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Value == nil {
+		http.Error(w, "value is required", http.StatusBadRequest)
+		return
+	}
+
+	chunks, version, err := h.memoryMgr.AppendLongTerm(r.Context(), agentID, req.Key, req.Value)
+	switch {
+	case errors.Is(err, memory.ErrMemoryBusy):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, memory.ErrNotAppendable), errors.Is(err, memory.ErrTooManyChunks):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, memory.ErrChunkTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AppendMemoryResponse{
+		Key:        req.Key,
+		Chunks:     chunks,
+		AppendedAt: models.Now(),
+		Version:    version,
 	})
 }
 
@@ -131,10 +220,7 @@ func (h *MemoryHandler) Get(w http.ResponseWriter, r *http.Request) {
 
 	// For now, list is not implemented - would require additional API support
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.MemoryListResponse{
-		Memories: []models.Memory{},
-		Count:    0,
-	})
+	json.NewEncoder(w).Encode(models.Page([]models.Memory{}, 0, 0))
 }
 
 // Delete handles DELETE /api/v1/agents/:id/memory - Delete memory.
@@ -170,6 +256,10 @@ func (h *MemoryHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if errors.Is(deleteErr, memory.ErrMemoryBusy) {
+		http.Error(w, deleteErr.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if deleteErr != nil {
 		http.Error(w, deleteErr.Error(), http.StatusInternalServerError)
 		return
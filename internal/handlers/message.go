@@ -4,31 +4,68 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"agent-comm-hub/internal/auth"
+	"agent-comm-hub/internal/config"
 	"agent-comm-hub/internal/models"
 	"agent-comm-hub/internal/services/messaging"
 	"agent-comm-hub/internal/services/registry"
 )
 
+// correlationIDHeader carries an upstream trace or correlation ID that becomes
+// the correlation ID of a sent message that does not set one.
+const correlationIDHeader = "X-Correlation-ID"
+
+const (
+	maxBatchSize    = 100
+	defaultPingWait = 5 * time.Second
+	maxPingWait     = 30 * time.Second
+)
+
 // MessageHandler handles message-related HTTP requests.
 type MessageHandler struct {
-	broker   *messaging.MessageBroker
-	registry *registry.AgentRegistry
+	broker   messaging.Broker
+	registry registry.Registry
+	cfg      *config.MessagingConfig
 }
 
 // NewMessageHandler creates a new message handler.
-func NewMessageHandler(broker *messaging.MessageBroker, registry *registry.AgentRegistry) *MessageHandler {
+func NewMessageHandler(broker messaging.Broker, registry registry.Registry, cfg *config.MessagingConfig) *MessageHandler {
 	return &MessageHandler{
 		broker:   broker,
 		registry: registry,
+		cfg:      cfg,
+	}
+}
+
+// historyLimit parses the ?limit= parameter of a history request, which
+// must be between 1 and the configured maximum.
+func (h *MessageHandler) historyLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return h.cfg.DefaultHistoryLimit, true
 	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > h.cfg.MaxHistoryLimit {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", h.cfg.MaxHistoryLimit), http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
 }
 
-// Send handles POST /api/v1/agents/:id/messages - Send a message.
+// Send handles POST /api/v1/agents/:id/messages - Send a message. With
+// ?dry_run=true the message is validated, including that a direct recipient
+// is registered, and the response reports what would happen without anything
+// being published or stored. A message without a correlation ID takes the
+// X-Correlation-ID request header, if set.
 func (h *MessageHandler) Send(w http.ResponseWriter, r *http.Request) {
 	fromAgentID := chi.URLParam(r, "id")
 
@@ -44,14 +81,13 @@ func (h *MessageHandler) Send(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req models.SendMessageRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Validate required fields
-	if req.ToAgent == "" {
-		http.Error(w, "to_agent is required", http.StatusBadRequest)
+	if err := req.ResolveTTL(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -59,19 +95,148 @@ func (h *MessageHandler) Send(w http.ResponseWriter, r *http.Request) {
 	if req.Type == "" {
 		req.Type = models.MessageTypeMessage
 	}
+	if req.CorrelationID == "" {
+		req.CorrelationID = r.Header.Get(correlationIDHeader)
+	}
+
+	send, status := h.broker.SendMessage, http.StatusAccepted
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	if dryRun {
+		if !h.recipientExists(w, r, req.ToAgent) {
+			return
+		}
+		send, status = h.broker.PreviewMessage, http.StatusOK
+	}
 
-	msg, err := h.broker.SendMessage(r.Context(), fromAgentID, &req)
+	msg, receipt, err := send(r.Context(), fromAgentID, &req)
+	if errors.Is(err, messaging.ErrInvalidMessageType) {
+		http.Error(w, "message type not allowed", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, messaging.ErrInvalidRecipient) {
+		http.Error(w, "invalid recipient", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, messaging.ErrSelfMessage) {
+		http.Error(w, "cannot send a message to the sending agent unless allow_self is set", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, messaging.ErrNoRecipient) {
+		http.Error(w, "to_agent is required: no route is configured for this message type", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, messaging.ErrInvalidCategory) {
+		http.Error(w, "category must be a name without spaces or commas, and is only allowed on broadcasts", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, messaging.ErrInvalidCompaction) {
+		http.Error(w, fmt.Sprintf("compaction_key must be at most %d printable characters, and is only allowed on events and topic messages", messaging.MaxCompactionKeyLength), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, messaging.ErrMessageRejected) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if msg.CorrelationID != "" {
+		w.Header().Set(correlationIDHeader, msg.CorrelationID)
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(models.SendMessageResponse{
-		MessageID: msg.ID,
-		Timestamp: msg.Timestamp,
-		Channel:   "agent:message:" + req.ToAgent,
+		MessageID:       msg.ID,
+		Timestamp:       msg.Timestamp,
+		Channel:         msg.Channel,
+		CorrelationID:   msg.CorrelationID,
+		DeliveredTo:     max(receipt.DeliveredTo, 0),
+		DeliveryUnknown: receipt.DeliveredTo == messaging.DeliveryUnknown,
+		Queued:          receipt.Queued,
+		Webhook:         receipt.Webhook,
+		DryRun:          dryRun,
+	})
+}
+
+// recipientExists checks that a direct message recipient is registered,
+// writing a 404 if it is not. Topic, group and broadcast recipients always
+// exist.
+func (h *MessageHandler) recipientExists(w http.ResponseWriter, r *http.Request, recipient string) bool {
+	channel, err := h.broker.Channels().For(recipient)
+	if err != nil || !h.broker.Channels().IsDirect(channel) {
+		// Invalid recipients are reported by the broker
+		return true
+	}
+
+	_, err = h.registry.Get(r.Context(), recipient)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "recipient agent not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// SendBatch handles POST /api/v1/agents/:id/messages/batch - Send several messages.
+func (h *MessageHandler) SendBatch(w http.ResponseWriter, r *http.Request) {
+	fromAgentID := chi.URLParam(r, "id")
+
+	// Verify sender exists
+	_, err := h.registry.Get(r.Context(), fromAgentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "sender agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var reqs []models.SendMessageRequest
+	if !decodeJSON(w, r, &reqs) {
+		return
+	}
+
+	// Validate batch
+	if len(reqs) == 0 || len(reqs) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("batch must contain 1 to %d messages", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+	for i := range reqs {
+		if err := reqs[i].ResolveTTL(); err != nil {
+			http.Error(w, fmt.Sprintf("message %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		// Set default message type
+		if reqs[i].Type == "" {
+			reqs[i].Type = models.MessageTypeMessage
+		}
+	}
+
+	results, err := h.broker.SendBatch(r.Context(), fromAgentID, reqs)
+	if errors.Is(err, messaging.ErrInvalidMessageType) || errors.Is(err, messaging.ErrInvalidRecipient) || errors.Is(err, messaging.ErrSelfMessage) || errors.Is(err, messaging.ErrNoRecipient) || errors.Is(err, messaging.ErrInvalidCategory) || errors.Is(err, messaging.ErrInvalidCompaction) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, messaging.ErrMessageRejected) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(models.BatchSendResponse{
+		Results: results,
+		Count:   len(results),
 	})
 }
 
@@ -90,23 +255,508 @@ func (h *MessageHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get limit from query param
-	limit := 50
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
+	limit, ok := h.historyLimit(w, r)
+	if !ok {
+		return
+	}
+	offset, ok := queryOffset(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.cfg.Persist {
+		writeHistoryDisabled(w, limit, offset)
+		return
+	}
+
+	messages, total, err := h.broker.GetMessageHistory(r.Context(), agentID, offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PagedResponse[models.Message]{
+		Items:  messages,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// MergedHistory handles GET /api/v1/messages/history?agents=id1,id2 - Get the
+// combined history of several agents as one timeline, each message once.
+// The key must be allowed to act as every listed agent.
+func (h *MessageHandler) MergedHistory(w http.ResponseWriter, r *http.Request) {
+	var agentIDs []string
+	for _, id := range strings.Split(r.URL.Query().Get("agents"), ",") {
+		if id = strings.TrimSpace(id); id != "" && !slices.Contains(agentIDs, id) {
+			agentIDs = append(agentIDs, id)
+		}
+	}
+	if len(agentIDs) == 0 {
+		http.Error(w, "agents is required", http.StatusBadRequest)
+		return
+	}
+	if len(agentIDs) > messaging.MaxMergedAgents {
+		http.Error(w, fmt.Sprintf("at most %d agents can be merged", messaging.MaxMergedAgents), http.StatusBadRequest)
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	for _, agentID := range agentIDs {
+		if !ok || !principal.CanActAs(agentID) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Verify agents exist
+	for _, agentID := range agentIDs {
+		_, err := h.registry.Get(r.Context(), agentID)
+		if errors.Is(err, registry.ErrAgentNotFound) {
+			http.Error(w, "agent not found: "+agentID, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	limit, ok := h.historyLimit(w, r)
+	if !ok {
+		return
+	}
+	offset, ok := queryOffset(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.cfg.Persist {
+		writeHistoryDisabled(w, limit, offset)
+		return
+	}
+
+	messages, total, err := h.broker.GetMergedHistory(r.Context(), agentIDs, offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PagedResponse[models.Message]{
+		Items:  messages,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// CompactedTopic handles GET /api/v1/topics/:name/compacted - Get the latest
+// message sent to a topic for each compaction key, oldest first.
+func (h *MessageHandler) CompactedTopic(w http.ResponseWriter, r *http.Request) {
+	topic := chi.URLParam(r, "name")
+
+	if !h.cfg.Persist {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.CompactedTopicResponse{
+			PagedResponse:   models.Page([]models.Message{}, 0, 0),
+			Topic:           topic,
+			HistoryDisabled: true,
+		})
+		return
+	}
+
+	messages, err := h.broker.CompactedTopic(r.Context(), topic)
+	if errors.Is(err, messaging.ErrInvalidRecipient) {
+		http.Error(w, "invalid topic name", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.CompactedTopicResponse{
+		PagedResponse: models.Page(messages, 0, 0),
+		Topic:         topic,
+	})
+}
+
+// writeHistoryDisabled writes the empty history page returned while message
+// persistence is disabled.
+func writeHistoryDisabled(w http.ResponseWriter, limit, offset int) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.MessageHistoryResponse{
+		PagedResponse: models.PagedResponse[models.Message]{
+			Items:  []models.Message{},
+			Limit:  limit,
+			Offset: offset,
+		},
+		HistoryDisabled: true,
+	})
+}
+
+// Inbox handles GET /api/v1/agents/:id/inbox - Pull the oldest messages
+// waiting in the agent's store-and-forward queue, removing them from it.
+// With ?visibility_timeout= (or MESSAGE_VISIBILITY_TIMEOUT) the messages stay
+// in flight and are redelivered unless acknowledged before it passes.
+func (h *MessageHandler) Inbox(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	if !h.cfg.StoreAndForward {
+		http.Error(w, "inbox requires store-and-forward to be enabled", http.StatusConflict)
+		return
+	}
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	limit, ok := h.historyLimit(w, r)
+	if !ok {
+		return
+	}
+
+	var visibility time.Duration
+	if s := r.URL.Query().Get("visibility_timeout"); s != "" {
+		visibility, err = time.ParseDuration(s)
+		if err != nil || visibility <= 0 {
+			http.Error(w, "visibility_timeout must be a positive duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	messages, remaining, err := h.broker.PullQueue(r.Context(), agentID, limit, visibility)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.InboxResponse{
+		PagedResponse: models.PagedResponse[models.Message]{
+			Items: messages,
+			Total: len(messages) + int(remaining),
+			Limit: limit,
+		},
+		Remaining: remaining,
+	})
+}
+
+// Ack handles POST /api/v1/agents/:id/inbox/ack - Acknowledge messages pulled
+// from the agent's inbox so they are not redelivered.
+func (h *MessageHandler) Ack(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var req models.AckRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.MessageIDs) == 0 {
+		http.Error(w, "message_ids is required", http.StatusBadRequest)
+		return
+	}
+
+	acked, err := h.broker.Ack(r.Context(), agentID, req.MessageIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AckResponse{Acked: acked})
+}
+
+// DeadLetters handles GET /api/v1/agents/:id/inbox/dead-letters - List the
+// inbox messages that reached MESSAGE_MAX_DELIVERIES without being
+// acknowledged, oldest first.
+func (h *MessageHandler) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	limit, ok := h.historyLimit(w, r)
+	if !ok {
+		return
+	}
+	offset, ok := queryOffset(w, r)
+	if !ok {
+		return
+	}
+
+	messages, total, err := h.broker.DeadLetters(r.Context(), agentID, offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PagedResponse[models.Message]{
+		Items:  messages,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// BroadcastCategories handles GET /api/v1/agents/:id/broadcast-categories -
+// List the broadcast categories the agent accepts; empty accepts all.
+func (h *MessageHandler) BroadcastCategories(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	categories, err := h.broker.BroadcastCategories(r.Context(), agentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.BroadcastCategories{Categories: categories})
+}
+
+// SetBroadcastCategories handles PUT /api/v1/agents/:id/broadcast-categories
+// - Replace the broadcast categories the agent accepts. Open streams keep
+// their categories until they reconnect.
+func (h *MessageHandler) SetBroadcastCategories(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var req models.BroadcastCategories
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Categories == nil {
+		req.Categories = []string{}
+	}
+
+	err = h.broker.SetBroadcastCategories(r.Context(), agentID, req.Categories)
+	if errors.Is(err, messaging.ErrInvalidCategory) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// QueueDepth handles GET /api/v1/agents/:id/messages/queue/depth - Report how
+// many messages are queued for the agent and retained in its history.
+func (h *MessageHandler) QueueDepth(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	depth, err := h.broker.QueueDepth(r.Context(), agentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(depth)
+}
+
+// Search handles GET /api/v1/agents/:id/messages/search?q= - Find messages in
+// the agent's history by payload substring or "field=value" match.
+func (h *MessageHandler) Search(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	limit, ok := h.historyLimit(w, r)
+	if !ok {
+		return
+	}
+	offset, ok := queryOffset(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.cfg.Persist {
+		writeHistoryDisabled(w, limit, offset)
+		return
+	}
+
+	messages, total, err := h.broker.SearchHistory(r.Context(), agentID, query, offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PagedResponse[models.Message]{
+		Items:  messages,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// Ping handles POST /api/v1/agents/:id/ping - Send a diagnostic ping and
+// wait (?wait=, default 5s, at most 30s) for the agent to acknowledge it.
+func (h *MessageHandler) Ping(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	wait := defaultPingWait
+	if waitStr := r.URL.Query().Get("wait"); waitStr != "" {
+		d, err := time.ParseDuration(waitStr)
+		if err != nil || d < 0 || d > maxPingWait {
+			http.Error(w, "wait must be a duration between 0s and 30s", http.StatusBadRequest)
+			return
 		}
+		wait = d
 	}
 
-	messages, err := h.broker.GetMessageHistory(r.Context(), agentID, limit)
+	result, err := h.broker.Ping(r.Context(), agentID, wait)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.MessageListResponse{
-		Messages: messages,
-		Count:    len(messages),
+	json.NewEncoder(w).Encode(result)
+}
+
+// Drain handles POST /api/v1/agents/:id/drain - Tell the agent's recent
+// peers that it is going offline, so they can reroute.
+func (h *MessageHandler) Drain(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	notified, err := h.broker.NotifyDraining(r.Context(), agentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.DrainResponse{
+		Notified: notified,
+		Count:    len(notified),
 	})
 }
+
+// Stats handles GET /api/v1/agents/:id/stats - Get message throughput stats.
+func (h *MessageHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stats, err := h.broker.GetStats(r.Context(), agentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,68 @@
+// Package handlers provides HTTP request handlers.
+package handlers
+
+import (
+	"time"
+
+	"agent-comm-hub/internal/metrics"
+)
+
+// Overflow policies for a full outbox.
+const (
+	OverflowDropOldest = "drop_oldest"
+	OverflowDisconnect = "disconnect"
+)
+
+// outbox is a bounded per-connection send buffer that decouples the Redis
+// receive loop from a possibly slow client writer, so one slow agent can
+// never stall delivery to others.
+type outbox struct {
+	messages chan outboxEntry
+	policy   string
+}
+
+// outboxEntry is an encoded message waiting to be written to the client.
+type outboxEntry struct {
+	payload   []byte
+	expiresAt time.Time // Zero if the message never expires
+	sentAt    time.Time // Set to record delivery latency for the recipient
+}
+
+// expired reports whether the entry's message is no longer worth delivering.
+func (e outboxEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+func newOutbox(size int, policy string) *outbox {
+	if size <= 0 {
+		size = 1
+	}
+	return &outbox{
+		messages: make(chan outboxEntry, size),
+		policy:   policy,
+	}
+}
+
+// push enqueues a message without blocking. It returns false when the buffer
+// overflowed and the policy requires the client to be disconnected.
+func (o *outbox) push(msg outboxEntry) bool {
+	for {
+		select {
+		case o.messages <- msg:
+			return true
+		default:
+		}
+
+		if o.policy == OverflowDisconnect {
+			metrics.MessageDropped(metrics.DropReasonBackpressure)
+			return false
+		}
+
+		// Drop the oldest buffered message to make room
+		select {
+		case <-o.messages:
+			metrics.MessageDropped(metrics.DropReasonBackpressure)
+		default:
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// queryOffset parses the ?offset= query parameter of a paged list, writing a
+// 400 and reporting false if it is not a non-negative integer.
+func queryOffset(w http.ResponseWriter, r *http.Request) (int, bool) {
+	return nonNegativeQuery(w, r, "offset")
+}
+
+// queryLimit parses an optional ?limit= query parameter where 0, the
+// default, means unlimited.
+func queryLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	return nonNegativeQuery(w, r, "limit")
+}
+
+func nonNegativeQuery(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		http.Error(w, name+" must be a non-negative integer", http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
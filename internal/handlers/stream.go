@@ -0,0 +1,507 @@
+// Package handlers provides HTTP request handlers.
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+
+	"agent-comm-hub/internal/auth"
+	"agent-comm-hub/internal/config"
+	"agent-comm-hub/internal/metrics"
+	"agent-comm-hub/internal/models"
+	"agent-comm-hub/internal/services/messaging"
+	"agent-comm-hub/internal/services/registry"
+)
+
+const (
+	streamWriteWait  = 10 * time.Second
+	streamPongWait   = 60 * time.Second
+	streamPingPeriod = (streamPongWait * 9) / 10
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// StreamHandler handles real-time message streaming over WebSocket.
+type StreamHandler struct {
+	broker   messaging.Broker
+	registry registry.Registry
+	cfg      *config.StreamConfig
+
+	mu       sync.Mutex
+	closing  bool
+	done     chan struct{} // Closed when the server shuts down
+	draining bool
+	drained  chan struct{}  // Closed once draining and no streams are open
+	active   sync.WaitGroup // Open stream connections
+	open     int            // Open stream connections, counted against MaxConnections
+	perAgent map[string]int // Open streams by agent, counted against MaxPerAgent
+	conns    *connections   // Open stream connections, for debugging
+}
+
+// NewStreamHandler creates a new stream handler.
+func NewStreamHandler(broker messaging.Broker, registry registry.Registry, cfg *config.StreamConfig) *StreamHandler {
+	return &StreamHandler{
+		broker:   broker,
+		registry: registry,
+		cfg:      cfg,
+		done:     make(chan struct{}),
+		drained:  make(chan struct{}),
+		perAgent: make(map[string]int),
+		conns:    newConnections(),
+	}
+}
+
+// Shutdown closes open stream connections and waits for them to finish, or
+// for ctx to expire. New streams are refused once it has been called.
+// http.Server.Shutdown does not track WebSocket connections, so this must be
+// called alongside it.
+func (h *StreamHandler) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	if !h.closing {
+		h.closing = true
+		close(h.done)
+	}
+	h.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		h.active.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain refuses new streams while leaving open ones alone, so the instance
+// can be shut down once they have closed. It returns a channel that is
+// closed when no streams are left open.
+func (h *StreamHandler) Drain() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.draining {
+		h.draining = true
+		if h.open == 0 {
+			close(h.drained)
+		}
+	}
+	return h.drained
+}
+
+// OpenStreams returns the number of streams open on this instance.
+func (h *StreamHandler) OpenStreams() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.open
+}
+
+// begin registers a new stream connection for agentID, or for a monitoring
+// stream if it is empty. It writes an error response and reports false once
+// the handler is draining or shutting down, or if the connection would exceed the
+// replica's or the agent's connection limit. Each successful call must be
+// paired with a call to end.
+func (h *StreamHandler) begin(w http.ResponseWriter, agentID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case h.closing:
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return false
+	case h.draining:
+		http.Error(w, "server draining", http.StatusServiceUnavailable)
+		return false
+	case h.cfg.MaxConnections > 0 && h.open >= h.cfg.MaxConnections:
+		http.Error(w, fmt.Sprintf("too many open streams on this server (limit %d)", h.cfg.MaxConnections), http.StatusServiceUnavailable)
+		return false
+	case agentID != "" && h.cfg.MaxPerAgent > 0 && h.perAgent[agentID] >= h.cfg.MaxPerAgent:
+		http.Error(w, fmt.Sprintf("agent has too many open streams (limit %d)", h.cfg.MaxPerAgent), http.StatusTooManyRequests)
+		return false
+	}
+
+	h.open++
+	if agentID != "" {
+		h.perAgent[agentID]++
+	}
+	h.active.Add(1)
+	return true
+}
+
+// end unregisters a stream connection registered by begin, and reports
+// whether it was the agent's last open stream on this server.
+func (h *StreamHandler) end(agentID string) bool {
+	h.mu.Lock()
+	h.open--
+	last := false
+	if agentID != "" {
+		if h.perAgent[agentID]--; h.perAgent[agentID] <= 0 {
+			delete(h.perAgent, agentID)
+			last = true
+		}
+	}
+	if h.draining && h.open == 0 {
+		close(h.drained)
+	}
+	h.mu.Unlock()
+
+	h.active.Done()
+	return last
+}
+
+// MonitoredMessage is a message observed on the system-wide stream, annotated
+// with the channel it was published on.
+type MonitoredMessage struct {
+	Channel string          `json:"channel"`
+	Message json.RawMessage `json:"message"`
+}
+
+// Stream handles GET /api/v1/agents/:id/messages/stream - Stream messages over WebSocket.
+// While the connection is open it acts as the agent's heartbeat. Repeated
+// topic and group query parameters add those channels to the same connection,
+// and the optional correlation_id restricts delivery to matching messages.
+// Messages queued while the agent was disconnected are delivered first,
+// except on filtered streams, which leave the queue for an unfiltered one.
+// Broadcasts are limited to the agent's broadcast categories, or to repeated
+// category query parameters, which take precedence.
+func (h *StreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	correlationID := r.URL.Query().Get("correlation_id")
+	topics := r.URL.Query()["topic"]
+	groups := r.URL.Query()["group"]
+
+	// Verify agent exists
+	_, err := h.registry.Get(r.Context(), agentID)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	categories := r.URL.Query()["category"]
+	if len(categories) == 0 {
+		if categories, err = h.broker.BroadcastCategories(r.Context(), agentID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	accepted := make(map[string]bool, len(categories))
+	for _, category := range categories {
+		accepted[category] = true
+	}
+
+	if !h.begin(w, agentID) {
+		return
+	}
+	connected := false
+	defer func() {
+		if h.end(agentID) && connected {
+			h.disconnected(agentID)
+		}
+	}()
+
+	sub, err := h.broker.SubscribeAgent(r.Context(), agentID, topics, groups)
+	if err != nil {
+		http.Error(w, "invalid topic or group", http.StatusBadRequest)
+		return
+	}
+	defer sub.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+	defer conn.Close()
+	defer h.conns.add(models.StreamConnection{
+		AgentID:     agentID,
+		Kind:        "agent",
+		Topics:      topics,
+		Groups:      groups,
+		RemoteAddr:  r.RemoteAddr,
+		ConnectedAt: models.Now(),
+	})()
+
+	h.connected(agentID)
+	connected = true
+
+	if correlationID == "" {
+		if err := h.flushQueue(r.Context(), conn, agentID); err != nil {
+			log.Printf("Warning: failed to deliver queued messages to agent %s: %v", agentID, err)
+			return
+		}
+	}
+
+	format := func(msg messaging.Envelope) (outboxEntry, bool) {
+		var m models.Message
+		if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+			return outboxEntry{}, false
+		}
+		if correlationID != "" && m.CorrelationID != correlationID {
+			return outboxEntry{}, false
+		}
+		if msg.Channel == h.broker.Channels().Broadcast() && !messaging.AcceptsBroadcast(accepted, &m) {
+			return outboxEntry{}, false
+		}
+		return outboxEntry{payload: []byte(msg.Payload), expiresAt: m.ExpiresAt(), sentAt: m.Timestamp}, true
+	}
+	heartbeat := func(ctx context.Context) error {
+		err := h.registry.Heartbeat(ctx, agentID)
+		if err != nil {
+			log.Printf("Warning: stream heartbeat for agent %s failed: %v", agentID, err)
+		}
+		if errors.Is(err, registry.ErrAgentNotFound) {
+			return err
+		}
+		return nil
+	}
+
+	h.pump(r.Context(), conn, sub, agentID, format, heartbeat)
+}
+
+// StreamAll handles GET /api/v1/messages/stream/all - Stream every message
+// flowing through the hub over WebSocket, annotated with its channel.
+func (h *StreamHandler) StreamAll(w http.ResponseWriter, r *http.Request) {
+	if !h.begin(w, "") {
+		return
+	}
+	defer h.end("")
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+	defer conn.Close()
+
+	sub, err := h.broker.SubscribeAll(r.Context())
+	if err != nil {
+		log.Printf("Warning: failed to subscribe to all messages: %v", err)
+		return
+	}
+	defer sub.Close()
+	defer h.conns.add(models.StreamConnection{
+		Kind:        "all",
+		RemoteAddr:  r.RemoteAddr,
+		ConnectedAt: models.Now(),
+	})()
+
+	h.pump(r.Context(), conn, sub, "monitor", formatMonitored, nil)
+}
+
+// StreamPattern handles GET /api/v1/messages/stream/pattern?pattern= - Stream
+// messages on every channel matching a pattern over WebSocket, annotated with
+// their concrete channel. Topic and group patterns are open to any caller;
+// direct-message patterns require a key that may act as every agent.
+func (h *StreamHandler) StreamPattern(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	direct, err := h.broker.Channels().ValidatePattern(pattern)
+	if err != nil {
+		http.Error(w, "pattern must be a direct, topic or group channel prefix ending in *", http.StatusBadRequest)
+		return
+	}
+	if principal, ok := auth.FromContext(r.Context()); direct && (!ok || !principal.CanActAsAny()) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if !h.begin(w, "") {
+		return
+	}
+	defer h.end("")
+
+	sub, err := h.broker.PSubscribe(r.Context(), pattern)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer sub.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+	defer conn.Close()
+	defer h.conns.add(models.StreamConnection{
+		Kind:        "pattern",
+		Pattern:     pattern,
+		RemoteAddr:  r.RemoteAddr,
+		ConnectedAt: models.Now(),
+	})()
+
+	h.pump(r.Context(), conn, sub, "pattern "+pattern, formatMonitored, nil)
+}
+
+// formatMonitored wraps a message with the channel it was published on.
+func formatMonitored(msg messaging.Envelope) (outboxEntry, bool) {
+	data, err := json.Marshal(MonitoredMessage{
+		Channel: msg.Channel,
+		Message: json.RawMessage(msg.Payload),
+	})
+	return outboxEntry{payload: data}, err == nil
+}
+
+// pump forwards messages from a broker subscription to a WebSocket connection
+// through a bounded outbox until either side goes away. format selects and
+// encodes each message; messages whose TTL elapses while buffered are not
+// delivered. heartbeat, if set, runs every heartbeat interval and
+// closes the stream when it returns an error. On server shutdown the client
+// is sent a going-away close frame, and if the stream's API key is revoked a
+// policy-violation close frame.
+func (h *StreamHandler) pump(ctx context.Context, conn *websocket.Conn, sub messaging.Subscription, subscriber string, format func(messaging.Envelope) (outboxEntry, bool), heartbeat func(context.Context) error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Read pump: handles pongs and detects client disconnect
+	conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Receive pump: moves messages from the broker into the bounded outbox
+	out := newOutbox(h.cfg.BufferSize, h.cfg.OverflowPolicy)
+	go func() {
+		defer cancel()
+		messages := sub.Messages()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				entry, ok := format(msg)
+				if !ok {
+					continue
+				}
+				if !out.push(entry) {
+					log.Printf("Disconnecting slow stream subscriber %s", subscriber)
+					return
+				}
+			}
+		}
+	}()
+
+	var heartbeatC <-chan time.Time
+	if heartbeat != nil {
+		ticker := time.NewTicker(h.cfg.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeatC = ticker.C
+	}
+	ping := time.NewTicker(streamPingPeriod)
+	defer ping.Stop()
+
+	// Close the stream as soon as its API key is revoked
+	var revoked <-chan struct{}
+	if principal, ok := auth.FromContext(ctx); ok {
+		revoked = principal.Revoked()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.done:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+				time.Now().Add(streamWriteWait))
+			return
+		case <-revoked:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "API key revoked"),
+				time.Now().Add(streamWriteWait))
+			return
+		case entry := <-out.messages:
+			if entry.expired(time.Now()) {
+				metrics.MessageExpired(metrics.ExpiredInStream)
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteMessage(websocket.TextMessage, entry.payload); err != nil {
+				return
+			}
+			if !entry.sentAt.IsZero() {
+				h.broker.RecordDelivery(ctx, subscriber, time.Since(entry.sentAt))
+			}
+		case <-heartbeatC:
+			if err := heartbeat(ctx); err != nil {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// flushQueue writes the messages queued for an agent to its connection. A
+// message is only removed from the queue once it has been written.
+func (h *StreamHandler) flushQueue(ctx context.Context, conn *websocket.Conn, agentID string) error {
+	return h.broker.DrainQueue(ctx, agentID, func(msg models.Message) error {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return err
+		}
+		h.broker.RecordDelivery(ctx, agentID, time.Since(msg.Timestamp))
+		return nil
+	})
+}
+
+// connected records a stream opening as a heartbeat, which marks an offline
+// agent online but leaves any status the agent reported, such as busy, alone.
+func (h *StreamHandler) connected(agentID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.registry.Heartbeat(ctx, agentID); err != nil && !errors.Is(err, registry.ErrAgentNotFound) {
+		log.Printf("Warning: stream heartbeat for agent %s failed: %v", agentID, err)
+	}
+}
+
+// disconnected marks the agent offline once its last stream on this server
+// closes. It uses its own context so the transition still happens after the
+// request context is cancelled.
+func (h *StreamHandler) disconnected(agentID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.registry.SetStatus(ctx, agentID, models.StatusOffline); err != nil && !errors.Is(err, registry.ErrAgentNotFound) {
+		log.Printf("Warning: failed to mark agent %s offline: %v", agentID, err)
+	}
+}
@@ -0,0 +1,39 @@
+// Package handlers provides HTTP request handlers.
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// BuildInfo describes the running build of the hub.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// VersionHandler handles build/version information requests.
+type VersionHandler struct {
+	info BuildInfo
+}
+
+// NewVersionHandler creates a new version handler.
+func NewVersionHandler(version, commit, buildTime string) *VersionHandler {
+	return &VersionHandler{
+		info: BuildInfo{
+			Version:   version,
+			Commit:    commit,
+			BuildTime: buildTime,
+			GoVersion: runtime.Version(),
+		},
+	}
+}
+
+// Get handles GET /api/v1/version - Get build information.
+func (h *VersionHandler) Get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.info)
+}
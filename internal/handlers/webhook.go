@@ -0,0 +1,47 @@
+// Package handlers provides HTTP request handlers.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"agent-comm-hub/internal/services/webhook"
+)
+
+// WebhookHandler reports on messages delivered to agent webhooks.
+type WebhookHandler struct {
+	dispatcher *webhook.Dispatcher
+}
+
+// NewWebhookHandler creates a new webhook handler.
+func NewWebhookHandler(dispatcher *webhook.Dispatcher) *WebhookHandler {
+	return &WebhookHandler{dispatcher: dispatcher}
+}
+
+// Delivery handles GET /api/v1/agents/:id/messages/:messageID/delivery - Get
+// the status of a message's webhook delivery. Only the message's sender and
+// recipient can see it; to anyone else it doesn't exist.
+func (h *WebhookHandler) Delivery(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	messageID := chi.URLParam(r, "messageID")
+
+	status, err := h.dispatcher.Status(r.Context(), messageID)
+	if errors.Is(err, webhook.ErrDeliveryNotFound) {
+		http.Error(w, "webhook delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status.FromAgent != agentID && status.ToAgent != agentID {
+		http.Error(w, "webhook delivery not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,64 @@
+// Package idgen provides pluggable ID generation strategies.
+package idgen
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+)
+
+// Supported ID strategies.
+const (
+	StrategyUUID = "uuid"
+	StrategyULID = "ulid"
+)
+
+// IDGenerator generates unique identifiers for agents and messages.
+type IDGenerator interface {
+	NewID() string
+}
+
+// New returns the ID generator for the given strategy.
+func New(strategy string) (IDGenerator, error) {
+	switch strategy {
+	case StrategyUUID, "":
+		return UUIDGenerator{}, nil
+	case StrategyULID:
+		return NewULIDGenerator(), nil
+	default:
+		return nil, fmt.Errorf("unknown ID strategy %q", strategy)
+	}
+}
+
+// UUIDGenerator generates random UUIDv4 identifiers.
+type UUIDGenerator struct{}
+
+// NewID returns a new UUIDv4.
+func (UUIDGenerator) NewID() string {
+	return uuid.New().String()
+}
+
+// ULIDGenerator generates lexicographically sortable ULID identifiers.
+// IDs generated within the same millisecond are monotonically increasing.
+type ULIDGenerator struct {
+	mu      sync.Mutex
+	entropy *ulid.MonotonicEntropy
+}
+
+// NewULIDGenerator creates a new ULID generator.
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{
+		entropy: ulid.Monotonic(rand.Reader, 0),
+	}
+}
+
+// NewID returns a new ULID.
+func (g *ULIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return ulid.MustNew(ulid.Timestamp(time.Now()), g.entropy).String()
+}
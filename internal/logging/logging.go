@@ -0,0 +1,26 @@
+// Package logging configures where the hub writes its logs.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Open returns the writer for a log destination: "stdout", "stderr", or a
+// file path, which is created if needed and appended to. The returned close
+// function releases the file, if any.
+func Open(destination string) (io.Writer, func() error, error) {
+	switch destination {
+	case "stdout":
+		return os.Stdout, func() error { return nil }, nil
+	case "stderr", "":
+		return os.Stderr, func() error { return nil }, nil
+	default:
+		f, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		return f, f.Close, nil
+	}
+}
@@ -0,0 +1,123 @@
+// Package metrics provides Prometheus instrumentation for the hub.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "agent_comm_hub"
+
+// Outcomes for instrumented operations.
+const (
+	OutcomeOK       = "ok"
+	OutcomeNotFound = "not_found"
+	OutcomeError    = "error"
+)
+
+// Reasons a message was dropped before delivery.
+const (
+	DropReasonBackpressure = "backpressure"
+	DropReasonQueueFull    = "queue_full" // Oldest message evicted from a full store-and-forward queue
+)
+
+// Stages at which a message's TTL elapsed before delivery.
+const (
+	ExpiredInQueue  = "queue"  // Waiting in, or in flight from, a store-and-forward queue
+	ExpiredInStream = "stream" // Buffered for a stream connection
+)
+
+var (
+	memoryServerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "memory_server_request_duration_seconds",
+		Help:      "Latency of requests to the agent memory server by operation and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "outcome"})
+
+	deliveryLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "message_delivery_latency_seconds",
+		Help:      "Time from a message being sent to its delivery to a recipient's stream.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 5, 30, 60, 300},
+	})
+
+	pubsubReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pubsub_reconnects_total",
+		Help:      "Subscription connections re-established after a failed keepalive or read.",
+	})
+
+	messagesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_dropped_total",
+		Help:      "Messages dropped before delivery to a subscriber, by reason.",
+	}, []string{"reason"})
+
+	messagesExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_expired_total",
+		Help:      "Messages discarded because their TTL elapsed before delivery, by stage.",
+	}, []string{"stage"})
+
+	messagesRedelivered = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_redelivered_total",
+		Help:      "Inbox messages requeued because they were not acknowledged within their visibility timeout.",
+	})
+
+	messagesDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_dlq_total",
+		Help:      "Inbox messages moved to a dead-letter queue after reaching the maximum number of deliveries.",
+	})
+)
+
+// ObserveMemoryServer records the latency of a memory server operation.
+func ObserveMemoryServer(operation, outcome string, start time.Time) {
+	memoryServerDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDeliveryLatency records the send-to-delivery latency of a message.
+func ObserveDeliveryLatency(latency time.Duration) {
+	deliveryLatency.Observe(latency.Seconds())
+}
+
+// MessageDropped counts a message dropped before delivery.
+func MessageDropped(reason string) {
+	messagesDropped.WithLabelValues(reason).Inc()
+}
+
+// MessagesDropped counts n messages dropped before delivery.
+func MessagesDropped(reason string, n int) {
+	messagesDropped.WithLabelValues(reason).Add(float64(n))
+}
+
+// MessageExpired counts a message whose TTL elapsed before delivery.
+func MessageExpired(stage string) {
+	messagesExpired.WithLabelValues(stage).Inc()
+}
+
+// MessagesRedelivered counts n unacknowledged messages requeued.
+func MessagesRedelivered(n int) {
+	messagesRedelivered.Add(float64(n))
+}
+
+// MessagesDeadLettered counts n messages moved to a dead-letter queue.
+func MessagesDeadLettered(n int) {
+	messagesDeadLettered.Add(float64(n))
+}
+
+// PubSubReconnected counts a subscription connection being re-established.
+func PubSubReconnected() {
+	pubsubReconnects.Inc()
+}
+
+// Handler returns the HTTP handler that exposes metrics for scraping.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
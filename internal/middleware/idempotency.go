@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	idempotencyKeyPrefix = "idempotency:"
+	// How long a request holds its key while it runs; a retry arriving in
+	// that window gets 409 instead of running twice.
+	idempotencyPendingTTL = time.Minute
+	// Requests and responses larger than this are not cached.
+	idempotencyMaxBody = 1 << 20
+	// The pending marker is followed by the request body digest.
+	idempotencyPending = "pending:"
+)
+
+// cachedResponse is a response stored for replay under an idempotency key.
+type cachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Digest string      `json:"digest"` // Of the request body that produced it
+}
+
+// Idempotency makes POST, PUT and PATCH requests carrying an Idempotency-Key
+// header safe to retry: the first response is stored in Redis for ttl and
+// replayed, with an Idempotent-Replayed header, for repeats of the same key
+// on the same route by the same API key. A repeat that arrives while the
+// first request is still running gets 409, and one with a different body
+// gets 422. Server errors are not stored, so those requests can be retried
+// for real. If Redis is unavailable, or the body is larger than
+// idempotencyMaxBody, requests run without idempotency.
+func Idempotency(client *redis.Client, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			switch {
+			case key == "":
+				next.ServeHTTP(w, r)
+				return
+			case r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			digest, ok := bodyDigest(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			redisKey := idempotencyKeyPrefix + idempotencyHash(r, key)
+
+			claimed, err := client.SetNX(ctx, redisKey, idempotencyPending+digest, idempotencyPendingTTL).Result()
+			if err != nil {
+				log.Printf("Warning: idempotency check failed: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !claimed {
+				replayResponse(w, r, client, redisKey, digest)
+				return
+			}
+
+			// Record the outcome even if the client has gone away, since
+			// that is exactly when it will retry
+			ctx = context.WithoutCancel(ctx)
+
+			// Release the key if the handler panics, so a retry can run
+			defer func() {
+				if p := recover(); p != nil {
+					client.Del(ctx, redisKey)
+					panic(p)
+				}
+			}()
+
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			if rec.status >= http.StatusInternalServerError || rec.overflow {
+				client.Del(ctx, redisKey)
+				return
+			}
+			data, err := json.Marshal(cachedResponse{Status: rec.status, Header: w.Header(), Body: rec.body.Bytes(), Digest: digest})
+			if err == nil {
+				err = client.Set(ctx, redisKey, data, ttl).Err()
+			}
+			if err != nil {
+				log.Printf("Warning: failed to store idempotent response: %v", err)
+				client.Del(ctx, redisKey)
+			}
+		})
+	}
+}
+
+// replayResponse writes the response stored under redisKey, if it was
+// produced by a request with the same body digest.
+func replayResponse(w http.ResponseWriter, r *http.Request, client *redis.Client, redisKey, digest string) {
+	data, err := client.Get(r.Context(), redisKey).Bytes()
+	pending, isPending := strings.CutPrefix(string(data), idempotencyPending)
+	switch {
+	case err == nil && isPending && pending != digest:
+		http.Error(w, idempotencyMismatch, http.StatusUnprocessableEntity)
+		return
+	case err == redis.Nil, err == nil && isPending:
+		http.Error(w, "a request with this Idempotency-Key is in progress", http.StatusConflict)
+		return
+	}
+	var cached cachedResponse
+	if err == nil {
+		err = json.Unmarshal(data, &cached)
+	}
+	if err != nil {
+		http.Error(w, "failed to load idempotent response", http.StatusInternalServerError)
+		return
+	}
+	// Responses stored before digests were recorded replay for any body
+	if cached.Digest != "" && cached.Digest != digest {
+		http.Error(w, idempotencyMismatch, http.StatusUnprocessableEntity)
+		return
+	}
+
+	for name, values := range cached.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(cached.Status)
+	w.Write(cached.Body)
+}
+
+const idempotencyMismatch = "Idempotency-Key was already used with a different request body"
+
+// bodyDigest returns a digest of the request body, leaving the body to be
+// read again by the handler. It reports false for bodies larger than
+// idempotencyMaxBody, which are left unread beyond the limit.
+func bodyDigest(r *http.Request) (string, bool) {
+	if r.Body == nil {
+		return hex.EncodeToString(sha256.New().Sum(nil)), true
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, idempotencyMaxBody+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || len(data) > idempotencyMaxBody {
+		return "", false
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// readCloser reads from a replacement reader while closing the original body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// idempotencyHash scopes an idempotency key to the route and the caller's
+// credentials, so keys chosen by different clients cannot collide.
+func idempotencyHash(r *http.Request, key string) string {
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("X-API-Key"), key} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy of its
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	overflow    bool // Body exceeded idempotencyMaxBody and was not kept
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	if !rec.overflow {
+		if rec.body.Len()+len(p) > idempotencyMaxBody {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(p)
+		}
+	}
+	return rec.ResponseWriter.Write(p)
+}
@@ -2,21 +2,227 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"log"
 	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+
+	"agent-comm-hub/internal/auth"
 )
 
-// CORS middleware adds CORS headers to responses.
-func CORS(next http.Handler) http.Handler {
+// CORS middleware adds CORS headers to responses for the allowed origins.
+// An origin of "*" allows any origin.
+func CORS(allowedOrigins []string) func(http.Handler) http.Handler {
+	allowAll := false
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		if origin == "*" {
+			allowAll = true
+		}
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			switch {
+			case allowAll:
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			case allowed[origin]:
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Correlation-ID")
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BearerToken middleware rejects requests that don't carry the given token
+// in an "Authorization: Bearer" header.
+func BearerToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AccessLog logs requests in chi's standard format to logger. Only 1 in
+// sampleRate successful requests is logged; client and server errors and
+// requests taking at least slow are always logged.
+func AccessLog(logger *log.Logger, sampleRate int, slow time.Duration) func(http.Handler) http.Handler {
+	return chimiddleware.RequestLogger(&sampledLogFormatter{
+		inner:      &chimiddleware.DefaultLogFormatter{Logger: logger, NoColor: true},
+		sampleRate: uint64(max(sampleRate, 1)),
+		slow:       slow,
+	})
+}
+
+type sampledLogFormatter struct {
+	inner      chimiddleware.LogFormatter
+	sampleRate uint64
+	slow       time.Duration
+	count      atomic.Uint64
+}
+
+func (f *sampledLogFormatter) NewLogEntry(r *http.Request) chimiddleware.LogEntry {
+	return &sampledLogEntry{formatter: f, inner: f.inner.NewLogEntry(r)}
+}
+
+type sampledLogEntry struct {
+	formatter *sampledLogFormatter
+	inner     chimiddleware.LogEntry
+}
+
+func (e *sampledLogEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
+	f := e.formatter
+	important := status >= http.StatusBadRequest || (f.slow > 0 && elapsed >= f.slow)
+	if !important && f.count.Add(1)%f.sampleRate != 0 {
+		return
+	}
+	e.inner.Write(status, bytes, header, elapsed, extra)
+}
+
+func (e *sampledLogEntry) Panic(v interface{}, stack []byte) {
+	e.inner.Panic(v, stack)
+}
+
+// MaxBodySize limits request bodies to limit bytes. Requests declaring a
+// larger Content-Length are rejected with 413 up front; others fail when
+// reading past the limit, which handlers report as 413.
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Timeout cancels the request context after the given duration, like chi's
+// Timeout middleware, but leaves long-lived streaming requests untouched.
+func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		timed := chimiddleware.Timeout(timeout)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsStreamRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			timed.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsStreamRequest reports whether the request opens a long-lived stream.
+func IsStreamRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// Authenticate resolves the API key on the request, from either an
+// "Authorization: Bearer" or an "X-API-Key" header, and stores the resulting
+// principal in the request context.
+func Authenticate(keys *auth.KeyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("X-API-Key")
+			if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+				key = strings.TrimPrefix(bearer, "Bearer ")
+			}
+
+			principal, ok := keys.Lookup(key)
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// Authorize enforces role-wide rules: read-only principals may not use
+// mutating methods.
+func Authorize(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		principal, ok := auth.FromContext(r.Context())
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !principal.CanMutate() {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireMutate restricts a route that changes state despite using a safe
+// method, such as HEAD, to principals that may mutate.
+func RequireMutate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := auth.FromContext(r.Context())
+		if !ok || !principal.CanMutate() {
+			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
+// RequireAdmin restricts a route to admin principals.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := auth.FromContext(r.Context())
+		if !ok || !principal.IsAdmin() {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ScopeAgent rejects requests on an agent's routes unless the principal may
+// act as the agent identified by the {id} URL parameter.
+func ScopeAgent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := auth.FromContext(r.Context())
+		if !ok || !principal.CanActAs(chi.URLParam(r, "id")) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
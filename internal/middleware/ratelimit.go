@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const rateLimitKeyPrefix = "ratelimit:"
+
+// tokenBucket takes a token from the bucket at KEYS[1], refilled at ARGV[1]
+// tokens per millisecond up to ARGV[2] tokens, using the Redis clock so
+// replicas agree. It returns 1 if a token was taken, or 0 and the
+// milliseconds until one is available.
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+return {allowed, wait}
+`)
+
+// RateLimitByIP limits each client IP to perMinute requests, with bursts of
+// up to burst, using a token bucket in Redis shared by every hub instance.
+// Requests over the limit get 429 with a Retry-After header. The client IP is
+// the request's RemoteAddr, so RealIP with the hub's trusted proxies should
+// run first when the hub is behind a proxy. name separates the buckets of different limits. If Redis is
+// unavailable requests are let through.
+func RateLimitByIP(client *redis.Client, name string, perMinute, burst int) func(http.Handler) http.Handler {
+	rate := float64(perMinute) / float64(time.Minute.Milliseconds())
+	if burst < 1 {
+		burst = 1
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr // RealIP sets a bare address
+			}
+
+			key := rateLimitKeyPrefix + name + ":" + ip
+			result, err := tokenBucket.Run(r.Context(), client, []string{key}, rate, burst).Int64Slice()
+			if err != nil {
+				log.Printf("Warning: rate limit check failed, allowing request: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if result[0] == 0 {
+				retryAfter := (time.Duration(result[1])*time.Millisecond + time.Second - 1) / time.Second
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
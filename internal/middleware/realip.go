@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RealIP replaces a request's RemoteAddr with the client address reported by
+// X-Forwarded-For or X-Real-IP, but only when the connection comes from one
+// of the trusted proxies, given as IP addresses or CIDR prefixes. Headers on
+// other requests are ignored, since any client can set them. X-Forwarded-For
+// is read from the right, skipping trusted proxies, so a client can't choose
+// its address by prepending entries. With no trusted proxies RemoteAddr is
+// always the peer address.
+func RealIP(trustedProxies []string) (func(http.Handler) http.Handler, error) {
+	var trusted []netip.Prefix
+	for _, entry := range trustedProxies {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy prefix %q: %w", entry, err)
+			}
+			trusted = append(trusted, prefix.Masked())
+		default:
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q: %w", entry, err)
+			}
+			trusted = append(trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		}
+	}
+	isTrusted := func(addr netip.Addr) bool {
+		addr = addr.Unmap()
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if client, ok := forwardedClient(r, isTrusted); ok {
+				r.RemoteAddr = client
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// forwardedClient returns the client address a trusted proxy forwarded the
+// request for, if the peer is trusted and reported one.
+func forwardedClient(r *http.Request, isTrusted func(netip.Addr) bool) (string, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", false
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !isTrusted(peer) {
+		return "", false
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				return "", false
+			}
+			if !isTrusted(addr) || i == 0 {
+				return addr.Unmap().String(), true
+			}
+		}
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String(), true
+	}
+	return "", false
+}
@@ -2,6 +2,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -14,6 +15,15 @@ const (
 	StatusBusy    AgentStatus = "busy"
 )
 
+// Valid reports whether s is one of the known agent statuses.
+func (s AgentStatus) Valid() bool {
+	switch s {
+	case StatusOnline, StatusOffline, StatusBusy:
+		return true
+	}
+	return false
+}
+
 // Agent represents a registered agent.
 type Agent struct {
 	ID           string            `json:"id"`
@@ -25,6 +35,99 @@ type Agent struct {
 	Metadata     map[string]string `json:"metadata"`
 	CreatedAt    time.Time         `json:"created_at"`
 	LastSeen     time.Time         `json:"last_seen"`
+	LastError    string            `json:"last_error,omitempty"`    // Most recent error reported by the agent
+	LastErrorAt  *time.Time        `json:"last_error_at,omitempty"` // When LastError was reported
+	OnlineSince  *time.Time        `json:"online_since,omitempty"`  // Last offline to online transition, nil while offline
+	Delivery     DeliveryMode      `json:"delivery,omitempty"`      // How direct messages reach the agent, empty = pubsub
+	Retention    *MessageRetention `json:"retention,omitempty"`     // Overrides the hub's history retention
+}
+
+// AgentView is an agent as returned by reads, with fields derived from the
+// time it was read, which are not stored.
+type AgentView struct {
+	Agent
+	AgeSeconds    int64  `json:"age_seconds"`              // Time since registration
+	UptimeSeconds *int64 `json:"uptime_seconds,omitempty"` // Time since coming online, nil while offline
+}
+
+// View returns the agent with its registration age and, while it is online,
+// how long it has been continuously online, as of now.
+func (a *Agent) View(now time.Time) *AgentView {
+	view := &AgentView{Agent: *a, AgeSeconds: int64(now.Sub(a.CreatedAt).Seconds())}
+	if a.OnlineSince != nil && a.Status != StatusOffline {
+		uptime := int64(now.Sub(*a.OnlineSince).Seconds())
+		view.UptimeSeconds = &uptime
+	}
+	return view
+}
+
+// AgentProfile is the public view of an agent used for discovery by other
+// agents, leaving out metadata and operational details.
+type AgentProfile struct {
+	ID           string      `json:"id"`
+	Name         string      `json:"name"`
+	Type         string      `json:"type"`
+	Capabilities []string    `json:"capabilities"`
+	Endpoint     string      `json:"endpoint"`
+	Status       AgentStatus `json:"status"`
+}
+
+// Profile returns the agent's public profile.
+func (a *Agent) Profile() AgentProfile {
+	return AgentProfile{
+		ID:           a.ID,
+		Name:         a.Name,
+		Type:         a.Type,
+		Capabilities: a.Capabilities,
+		Endpoint:     a.Endpoint,
+		Status:       a.Status,
+	}
+}
+
+// DeliveryMode selects how direct messages reach an agent.
+type DeliveryMode string
+
+const (
+	DeliveryPubSub  DeliveryMode = "pubsub"  // Streams and the store-and-forward queue
+	DeliveryWebhook DeliveryMode = "webhook" // POSTed to the agent's endpoint
+	DeliveryBoth    DeliveryMode = "both"
+)
+
+// MessageRetention overrides how much of an agent's message history is kept.
+// Zero fields keep the hub's defaults.
+type MessageRetention struct {
+	Messages int `json:"messages,omitempty"` // Messages kept, default MAX_HISTORY_LIMIT
+	TTL      int `json:"ttl,omitempty"`      // Seconds kept after the latest message, default a day
+}
+
+// Webhook reports whether direct messages are POSTed to the agent's endpoint.
+func (a *Agent) Webhook() bool {
+	return a.Delivery == DeliveryWebhook || a.Delivery == DeliveryBoth
+}
+
+// BulkHeartbeatRequest lists agents whose heartbeats are refreshed in one
+// call.
+type BulkHeartbeatRequest struct {
+	AgentIDs []string `json:"agent_ids"`
+}
+
+// Outcomes of a bulk heartbeat for one agent.
+const (
+	HeartbeatOK        = "ok"
+	HeartbeatNotFound  = "not_found"
+	HeartbeatForbidden = "forbidden" // The API key may not act as the agent
+)
+
+// HeartbeatResult is the outcome of a bulk heartbeat for one agent.
+type HeartbeatResult struct {
+	AgentID string `json:"agent_id"`
+	Status  string `json:"status"`
+}
+
+// BulkHeartbeatResponse reports a bulk heartbeat per agent, in request order.
+type BulkHeartbeatResponse struct {
+	Results   []HeartbeatResult `json:"results"`
+	Refreshed int               `json:"refreshed"`
 }
 
 // RegisterAgentRequest represents a request to register an agent.
@@ -34,15 +137,11 @@ type RegisterAgentRequest struct {
 	Capabilities []string          `json:"capabilities"`
 	Endpoint     string            `json:"endpoint"`
 	Metadata     map[string]string `json:"metadata"`
+	Delivery     DeliveryMode      `json:"delivery,omitempty"`
+	Retention    *MessageRetention `json:"retention,omitempty"`
 }
 
-// RegisterAgentResponse represents the response after agent registration.
-type RegisterAgentResponse struct {
-	ID     string      `json:"id"`
-	Status AgentStatus `json:"status"`
-}
-
-// UpdateAgentRequest represents a request to update an agent.
+// UpdateAgentRequest represents a request to replace an agent's mutable fields.
 type UpdateAgentRequest struct {
 	Name         string            `json:"name"`
 	Type         string            `json:"type"`
@@ -50,10 +149,97 @@ type UpdateAgentRequest struct {
 	Endpoint     string            `json:"endpoint"`
 	Status       AgentStatus       `json:"status"`
 	Metadata     map[string]string `json:"metadata"`
+	Delivery     DeliveryMode      `json:"delivery,omitempty"`
+	Retention    *MessageRetention `json:"retention,omitempty"`
+}
+
+// Optional is a JSON field that distinguishes an absent value from an
+// explicit null.
+type Optional[T any] struct {
+	Set   bool // Field was present in the JSON
+	Null  bool // Field was explicitly null
+	Value T
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// AddCapabilitiesRequest represents a request to add capabilities to an agent.
+type AddCapabilitiesRequest struct {
+	Capabilities []string `json:"capabilities"`
+}
+
+// PatchAgentRequest represents a partial update to an agent. Absent fields
+// are left unchanged and fields set to null are cleared. Metadata is merged
+// key by key as a JSON Merge Patch (RFC 7386): keys set to null are deleted
+// and absent keys are kept.
+type PatchAgentRequest struct {
+	Name         Optional[string]             `json:"name"`
+	Type         Optional[string]             `json:"type"`
+	Capabilities Optional[[]string]           `json:"capabilities"`
+	Endpoint     Optional[string]             `json:"endpoint"`
+	Status       Optional[AgentStatus]        `json:"status"`
+	Metadata     Optional[map[string]*string] `json:"metadata"`
+	Delivery     Optional[DeliveryMode]       `json:"delivery"`
+	Retention    Optional[*MessageRetention]  `json:"retention"`
+}
+
+// CapabilityMatch selects how an agent query combines capabilities.
+type CapabilityMatch string
+
+const (
+	MatchAll CapabilityMatch = "all" // Agents with every capability
+	MatchAny CapabilityMatch = "any" // Agents with at least one capability
+)
+
+// AgentSort selects the field an agent listing is ordered by.
+type AgentSort string
+
+const (
+	SortByName      AgentSort = "name"
+	SortByCreatedAt AgentSort = "created_at"
+	SortByLastSeen  AgentSort = "last_seen"
+)
+
+// AgentQuery filters and orders the agents returned by a listing.
+type AgentQuery struct {
+	Capabilities []string
+	Match        CapabilityMatch
+	Sort         AgentSort // Empty leaves the order unspecified
+	Descending   bool
+	Strict       bool // Fail instead of skipping agent records that can't be loaded
+}
+
+// StatusReport is an agent's self-reported status. A non-empty error
+// replaces the agent's last error; an empty string or null clears it.
+type StatusReport struct {
+	Status AgentStatus      `json:"status"`
+	Error  Optional[string] `json:"error"`
+}
+
+// AgentLivenessResponse maps agent IDs to whether they are online, judged by
+// heartbeat presence.
+type AgentLivenessResponse struct {
+	Type   string                 `json:"type,omitempty"`
+	Agents map[string]AgentStatus `json:"agents"`
+	Count  int                    `json:"count"`
+}
+
+// FlushTypeResponse reports the agents removed by flushing an agent type.
+type FlushTypeResponse struct {
+	Type    string `json:"type"`
+	Removed int    `json:"removed"`
 }
 
-// AgentListResponse represents a list of agents response.
-type AgentListResponse struct {
-	Agents []Agent `json:"agents"`
-	Count  int     `json:"count"`
+// AgentTypeCount represents the number of agents of a type.
+type AgentTypeCount struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
 }
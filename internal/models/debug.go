@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// RedisKey describes a Redis key found while debugging.
+type RedisKey struct {
+	Key   string `json:"key"`
+	Type  string `json:"type"`   // Redis type, e.g. "string", "list" or "hash"
+	TTLMS int64  `json:"ttl_ms"` // Time to live in milliseconds, -1 = no expiry
+}
+
+// AgentKeysResponse lists the Redis keys associated with an agent.
+type AgentKeysResponse struct {
+	AgentID   string     `json:"agent_id"`
+	Keys      []RedisKey `json:"keys"`      // Keys whose name contains the agent ID
+	Indexes   []string   `json:"indexes"`   // Shared index keys the agent is a member of
+	Truncated bool       `json:"truncated"` // More keys matched than were returned
+}
+
+// StreamConnection describes a WebSocket stream open on this hub instance.
+type StreamConnection struct {
+	AgentID     string    `json:"agent_id,omitempty"` // Empty for monitoring streams
+	Kind        string    `json:"kind"`               // "agent", "all" or "pattern"
+	Topics      []string  `json:"topics,omitempty"`
+	Groups      []string  `json:"groups,omitempty"`
+	Pattern     string    `json:"pattern,omitempty"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// ConnectionsResponse lists the streams open on this hub instance.
+type ConnectionsResponse struct {
+	Connections []StreamConnection `json:"connections"` // Oldest first
+	Count       int                `json:"count"`
+	Agents      []string           `json:"agents"` // Distinct agents with an open stream
+}
+
+// DrainStatus reports whether this hub instance is draining for maintenance.
+type DrainStatus struct {
+	Draining    bool       `json:"draining"`
+	Since       *time.Time `json:"since,omitempty"`
+	OpenStreams int        `json:"open_streams"` // Streams still open on this instance
+}
@@ -0,0 +1,36 @@
+// Package models provides data models for the application.
+package models
+
+import (
+	"time"
+)
+
+// RegistryEventType represents the kind of registry change.
+type RegistryEventType string
+
+const (
+	EventRegister   RegistryEventType = "register"
+	EventUpdate     RegistryEventType = "update"
+	EventUnregister RegistryEventType = "unregister"
+	EventAgentDown  RegistryEventType = "agent_down" // Heartbeat expired
+)
+
+// RegistryEvent represents a change to the agent registry.
+type RegistryEvent struct {
+	ID        string            `json:"id"`
+	Type      RegistryEventType `json:"event_type"`
+	AgentID   string            `json:"agent_id"`
+	AgentName string            `json:"agent_name"`
+	AgentType string            `json:"agent_type"`
+	// Capabilities the agent held, set on agent_down events
+	Capabilities []string  `json:"capabilities,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// RegistryEventQuery filters registry event history.
+type RegistryEventQuery struct {
+	Type   RegistryEventType // Empty matches all event types
+	Since  time.Time         // Zero matches from the oldest retained event
+	Cursor string            // Resume after this event ID
+	Limit  int
+}
@@ -19,25 +19,38 @@ type Memory struct {
 	Value      interface{} `json:"value"`
 	MemoryType MemoryType  `json:"memory_type"`
 	StoredAt   time.Time   `json:"stored_at"`
-	TTL        int         `json:"ttl,omitempty"` // TTL in seconds for short-term memory
+	TTL        int         `json:"ttl,omitempty"`     // TTL in seconds for short-term memory
+	Version    int64       `json:"version,omitempty"` // Writes to a long-term key, for versioned stores
 }
 
 // StoreMemoryRequest represents a request to store memory.
 type StoreMemoryRequest struct {
-	MemoryType MemoryType  `json:"memory_type" validate:"required"`
-	Key        string      `json:"key" validate:"required"`
-	Value      interface{} `json:"value" validate:"required"`
-	TTL        int         `json:"ttl"` // TTL in seconds for short-term memory
+	MemoryType  MemoryType  `json:"memory_type" validate:"required"`
+	Key         string      `json:"key" validate:"required"`
+	Value       interface{} `json:"value" validate:"required"`
+	TTL         int         `json:"ttl"`                    // TTL in seconds for short-term memory
+	TTLDuration string      `json:"ttl_duration,omitempty"` // e.g. "24h" or "P1D", overrides TTL
+	Version     *int64      `json:"version,omitempty"`      // Store only if the long-term key is at this version
+}
+
+// AppendMemoryRequest represents a request to append a chunk to a list-style
+// long-term memory value.
+type AppendMemoryRequest struct {
+	Key   string      `json:"key" validate:"required"`
+	Value interface{} `json:"value" validate:"required"`
+}
+
+// AppendMemoryResponse represents the response after appending to memory.
+type AppendMemoryResponse struct {
+	Key        string    `json:"key"`
+	Chunks     int64     `json:"chunks"` // Chunks the value holds after the append
+	AppendedAt time.Time `json:"appended_at"`
+	Version    int64     `json:"version"`
 }
 
 // StoreMemoryResponse represents the response after storing memory.
 type StoreMemoryResponse struct {
 	Key      string    `json:"key"`
 	StoredAt time.Time `json:"stored_at"`
-}
-
-// MemoryListResponse represents a list of memories.
-type MemoryListResponse struct {
-	Memories []Memory `json:"memories"`
-	Count    int      `json:"count"`
+	Version  int64     `json:"version,omitempty"` // New version of a long-term key
 }
@@ -13,6 +13,9 @@ const (
 	MessageTypeResponse MessageType = "response"
 	MessageTypeEvent    MessageType = "event"
 	MessageTypeMessage  MessageType = "message"
+	MessageTypePing     MessageType = "ping"           // Diagnostic ping sent by the hub
+	MessageTypeDraining MessageType = "agent_draining" // Sender is going offline
+	MessageTypeExpired  MessageType = "expired"        // A message sent with notify_expiry expired undelivered
 )
 
 // Message represents a message between agents.
@@ -20,31 +23,201 @@ type Message struct {
 	ID            string      `json:"id"`
 	FromAgent     string      `json:"from_agent"`
 	ToAgent       string      `json:"to_agent"`
+	Channel       string      `json:"channel,omitempty"` // Channel the message was published on
 	Type          MessageType `json:"type"`
 	Payload       interface{} `json:"payload"`
 	CorrelationID string      `json:"correlation_id,omitempty"`
 	Timestamp     time.Time   `json:"timestamp"`
-	TTL           int         `json:"ttl,omitempty"` // TTL in seconds, 0 = no expiration
+	TTL           int         `json:"ttl,omitempty"`            // TTL in seconds, 0 = no expiration
+	Category      string      `json:"category,omitempty"`       // Broadcast category subscribers can opt into
+	CompactionKey string      `json:"compaction_key,omitempty"` // Newer messages with the same key supersede this one
+	NotifyExpiry  bool        `json:"notify_expiry,omitempty"`  // Tell the sender if the message expires undelivered
+
+	// Seconds a message pulled from the inbox stays in flight before it is
+	// redelivered unless acknowledged, overriding the puller's timeout
+	VisibilityTimeout int `json:"visibility_timeout,omitempty"`
+	// Times the message has been pulled from the inbox, set on in-flight pulls
+	DeliveryCount int `json:"delivery_count,omitempty"`
 }
 
 // SendMessageRequest represents a request to send a message.
 type SendMessageRequest struct {
-	ToAgent       string      `json:"to_agent" validate:"required"`
+	ToAgent       string      `json:"to_agent"` // Empty routes the message by type
 	Type          MessageType `json:"type"`
 	Payload       interface{} `json:"payload"`
 	CorrelationID string      `json:"correlation_id"`
 	TTL           int         `json:"ttl"`
+	TTLDuration   string      `json:"ttl_duration,omitempty"`   // e.g. "30m" or "PT30M", overrides TTL
+	Persist       *bool       `json:"persist,omitempty"`        // false keeps the message out of history
+	AllowSelf     bool        `json:"allow_self,omitempty"`     // Permit sending to the sender's own ID
+	Category      string      `json:"category,omitempty"`       // Broadcast category, broadcasts only
+	CompactionKey string      `json:"compaction_key,omitempty"` // Events and topic messages only
+	NotifyExpiry  bool        `json:"notify_expiry,omitempty"`  // Send an expired notice if the TTL elapses before delivery
+	// Seconds the message stays in flight when pulled from the inbox before it
+	// is redelivered unless acknowledged (0 uses the puller's timeout)
+	VisibilityTimeout int `json:"visibility_timeout,omitempty"`
+}
+
+// MessageHistoryResponse is a page of message history. HistoryDisabled is
+// set when the hub does not record history, so the page is always empty.
+type MessageHistoryResponse struct {
+	PagedResponse[Message]
+	HistoryDisabled bool `json:"history_disabled,omitempty"`
+}
+
+// ExpiryNotice is the payload of an expired notice, sent to the sender of a
+// message that expired before it was delivered.
+type ExpiryNotice struct {
+	MessageID     string    `json:"message_id"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	ToAgent       string    `json:"to_agent"`
+	ExpiredAt     time.Time `json:"expired_at"`
+}
+
+// CompactedTopicResponse lists the latest message sent to a topic for each
+// compaction key, all on one page.
+type CompactedTopicResponse struct {
+	PagedResponse[Message]
+	Topic           string `json:"topic"`
+	HistoryDisabled bool   `json:"history_disabled,omitempty"`
 }
 
 // SendMessageResponse represents the response after sending a message.
 type SendMessageResponse struct {
-	MessageID string    `json:"message_id"`
-	Timestamp time.Time `json:"timestamp"`
-	Channel   string    `json:"channel"`
+	MessageID       string    `json:"message_id"`
+	Timestamp       time.Time `json:"timestamp"`
+	Channel         string    `json:"channel"`
+	CorrelationID   string    `json:"correlation_id,omitempty"`
+	DeliveredTo     int64     `json:"delivered_to"`               // Subscribers connected when the message was published
+	DeliveryUnknown bool      `json:"delivery_unknown,omitempty"` // The transport can't count subscribers, so DeliveredTo is 0
+	Queued          bool      `json:"queued,omitempty"`           // Held for delivery when the recipient connects
+	Webhook         bool      `json:"webhook,omitempty"`          // Queued for delivery to the recipient's webhook
+	DryRun          bool      `json:"dry_run,omitempty"`          // Validated only; nothing was published or stored
+}
+
+// BatchSendResult represents the outcome of one message in a batch send.
+type BatchSendResult struct {
+	Index           int       `json:"index"` // Position of the message in the request
+	MessageID       string    `json:"message_id"`
+	Timestamp       time.Time `json:"timestamp"`
+	Channel         string    `json:"channel"`
+	DeliveredTo     int64     `json:"delivered_to"`
+	DeliveryUnknown bool      `json:"delivery_unknown,omitempty"` // The transport can't count subscribers, so DeliveredTo is 0
+	Queued          bool      `json:"queued,omitempty"`
+	Webhook         bool      `json:"webhook,omitempty"`
+	Error           string    `json:"error,omitempty"` // Set when the message could not be published
+}
+
+// BatchSendResponse represents the response after sending a batch of messages.
+type BatchSendResponse struct {
+	Results []BatchSendResult `json:"results"`
+	Count   int               `json:"count"`
+}
+
+// InboxResponse represents messages pulled from an agent's inbox. Pulled
+// messages leave the inbox, so the next page is always at offset 0, and the
+// total counts the pulled messages along with those remaining.
+type InboxResponse struct {
+	PagedResponse[Message]
+	Remaining int64 `json:"remaining"` // Messages still waiting in the inbox
+}
+
+// AckRequest acknowledges messages pulled from an agent's inbox.
+type AckRequest struct {
+	MessageIDs []string `json:"message_ids"`
+}
+
+// AckResponse reports how many acknowledged messages were in flight.
+type AckResponse struct {
+	Acked int `json:"acked"`
+}
+
+// BroadcastCategories lists the broadcast categories an agent accepts; empty
+// accepts every broadcast.
+type BroadcastCategories struct {
+	Categories []string `json:"categories"`
 }
 
-// MessageListResponse represents a list of messages.
-type MessageListResponse struct {
-	Messages []Message `json:"messages"`
-	Count    int       `json:"count"`
+// QueueDepth reports how many messages are held for an agent.
+type QueueDepth struct {
+	AgentID string `json:"agent_id"`
+	Queued  int64  `json:"queued"`  // Undelivered messages waiting in the store-and-forward queue
+	History int64  `json:"history"` // Messages retained in history
+	// Messages pulled from the inbox and awaiting acknowledgement
+	InFlight int64 `json:"in_flight"`
+}
+
+// DrainResponse lists the peers told that an agent is draining.
+type DrainResponse struct {
+	Notified []string `json:"notified"`
+	Count    int      `json:"count"`
+}
+
+// PingResult represents the outcome of a diagnostic ping.
+type PingResult struct {
+	MessageID       string  `json:"message_id"`
+	DeliveredTo     int64   `json:"delivered_to"`               // Subscribers connected when the ping was published
+	DeliveryUnknown bool    `json:"delivery_unknown,omitempty"` // The transport can't count subscribers, so DeliveredTo is 0
+	Acked           bool    `json:"acked"`                      // The agent acknowledged the ping in time
+	LatencyMS       float64 `json:"latency_ms,omitempty"`       // Round-trip time to the acknowledgement
+}
+
+// AgentStats represents message throughput for an agent.
+type AgentStats struct {
+	AgentID       string  `json:"agent_id"`
+	Sent          int64   `json:"sent"`
+	Received      int64   `json:"received"`
+	SentRate      float64 `json:"sent_per_second"`
+	ReceivedRate  float64 `json:"received_per_second"`
+	WindowSeconds int     `json:"window_seconds"` // Window the rates are averaged over
+	Queued        int64   `json:"queued"`         // Undelivered messages waiting in the queue
+	// Send-to-delivery latency of recent messages streamed to the agent
+	DeliveryLatency *LatencyStats `json:"delivery_latency,omitempty"`
+}
+
+// LatencyStats summarizes recent latency samples in milliseconds.
+type LatencyStats struct {
+	Samples int     `json:"samples"`
+	P50MS   float64 `json:"p50_ms"`
+	P90MS   float64 `json:"p90_ms"`
+	P99MS   float64 `json:"p99_ms"`
+	MaxMS   float64 `json:"max_ms"`
+}
+
+// ExpiresAt returns when the message stops being relevant, or the zero time
+// if it never expires.
+func (m *Message) ExpiresAt() time.Time {
+	if m.TTL <= 0 {
+		return time.Time{}
+	}
+	return m.Timestamp.Add(time.Duration(m.TTL) * time.Second)
+}
+
+// Expired reports whether the message's TTL has elapsed at the given time.
+func (m *Message) Expired(now time.Time) bool {
+	expiresAt := m.ExpiresAt()
+	return !expiresAt.IsZero() && now.After(expiresAt)
+}
+
+// WebhookState is the progress of a webhook delivery.
+type WebhookState string
+
+const (
+	WebhookPending   WebhookState = "pending" // Waiting for an attempt or a retry
+	WebhookDelivered WebhookState = "delivered"
+	WebhookFailed    WebhookState = "failed" // Attempts exhausted or the delivery was dropped
+)
+
+// WebhookDelivery tracks the delivery of a message to its recipient's
+// webhook endpoint.
+type WebhookDelivery struct {
+	MessageID  string       `json:"message_id"`
+	FromAgent  string       `json:"from_agent"`
+	ToAgent    string       `json:"to_agent"`
+	State      WebhookState `json:"state"`
+	Attempts   int          `json:"attempts"`
+	LastStatus int          `json:"last_status,omitempty"` // HTTP status of the last attempt
+	LastError  string       `json:"last_error,omitempty"`
+	Queued     bool         `json:"queued,omitempty"` // Held for store-and-forward after the delivery failed
+	UpdatedAt  time.Time    `json:"updated_at"`
 }
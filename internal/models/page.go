@@ -0,0 +1,33 @@
+package models
+
+// TotalUnknown is reported as the total of cursor-paged lists, whose size
+// isn't known without reading every page.
+const TotalUnknown = -1
+
+// PagedResponse is the envelope returned by every list endpoint. Lists paged
+// by offset report the offset of the first item; cursor-paged lists report
+// a NextCursor to pass back for the following page instead.
+type PagedResponse[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int    `json:"total"`                 // Items across all pages, or TotalUnknown
+	Limit      int    `json:"limit"`                 // Page size requested, 0 = unlimited
+	Offset     int    `json:"offset"`                // Items skipped before this page
+	NextCursor string `json:"next_cursor,omitempty"` // Resumes after this page, empty on the last
+	Skipped    int    `json:"skipped,omitempty"`     // Items left out because they could not be loaded
+}
+
+// Page returns items[offset:offset+limit] as a PagedResponse, where items is
+// the complete list; a limit of 0 returns every item from offset.
+func Page[T any](items []T, offset, limit int) PagedResponse[T] {
+	page := PagedResponse[T]{Total: len(items), Limit: limit, Offset: offset}
+	end := len(items)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	if offset < end {
+		page.Items = items[offset:end]
+	} else {
+		page.Items = []T{}
+	}
+	return page
+}
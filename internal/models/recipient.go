@@ -0,0 +1,30 @@
+package models
+
+import "strings"
+
+// Recipients that address channels rather than agents. They are reserved:
+// no agent may be named after them.
+const (
+	RecipientBroadcast        = "broadcast"
+	RecipientTopicPrefix      = "topic:"
+	RecipientGroupPrefix      = "group:"
+	RecipientCapabilityPrefix = "capability:" // Reserved for capability-addressed messages
+)
+
+// reservedPrefixes are the recipient prefixes that name a channel.
+var reservedPrefixes = []string{RecipientTopicPrefix, RecipientGroupPrefix, RecipientCapabilityPrefix}
+
+// IsReserved reports whether name is a reserved recipient, or starts with a
+// reserved recipient prefix, ignoring case.
+func IsReserved(name string) bool {
+	lower := strings.ToLower(name)
+	if lower == RecipientBroadcast {
+		return true
+	}
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
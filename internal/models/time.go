@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Now returns the current time in UTC. Every timestamp the hub stores or
+// returns is taken from Now, so it serializes as RFC3339 with a "Z" offset
+// whatever time zone the server runs in.
+func Now() time.Time {
+	return time.Now().UTC()
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNowIsUTC(t *testing.T) {
+	// Run as if the server were in a zone well away from UTC
+	local := time.Local
+	time.Local = time.FixedZone("UTC+9", 9*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	now := Now()
+	if now.Location() != time.UTC {
+		t.Fatalf("Now() is in %v, want UTC", now.Location())
+	}
+
+	data, err := json.Marshal(now)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	s := strings.Trim(string(data), `"`)
+	if !strings.HasSuffix(s, "Z") {
+		t.Errorf("Now() serialized as %s, want a Z offset", s)
+	}
+	if _, err := time.Parse(time.RFC3339, s); err != nil {
+		t.Errorf("Now() serialized as %s, which is not RFC3339: %v", s, err)
+	}
+}
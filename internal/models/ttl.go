@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTTL is returned for a ttl_duration that cannot be parsed.
+var ErrInvalidTTL = errors.New("invalid ttl_duration")
+
+// isoDuration matches the day and time parts of an ISO 8601 duration, e.g.
+// "P1DT12H" or "PT30M".
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// ResolveTTL sets TTL from TTLDuration, when present, which takes precedence
+// over the integer form.
+func (r *SendMessageRequest) ResolveTTL() error {
+	return resolveTTL(&r.TTL, r.TTLDuration)
+}
+
+// ResolveTTL sets TTL from TTLDuration, when present, which takes precedence
+// over the integer form.
+func (r *StoreMemoryRequest) ResolveTTL() error {
+	return resolveTTL(&r.TTL, r.TTLDuration)
+}
+
+// resolveTTL parses a Go ("24h", "1h30m") or ISO 8601 ("PT30M", "P1D")
+// duration into whole seconds, rounding up.
+func resolveTTL(ttl *int, duration string) error {
+	if duration == "" {
+		return nil
+	}
+
+	d, err := parseDuration(duration)
+	if err != nil || d < 0 {
+		return ErrInvalidTTL
+	}
+	*ttl = int(math.Ceil(d.Seconds()))
+	return nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if !strings.HasPrefix(s, "P") {
+		return time.ParseDuration(s)
+	}
+
+	parts := isoDuration.FindStringSubmatch(s)
+	if parts == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, ErrInvalidTTL
+	}
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var total time.Duration
+	for i, unit := range units {
+		if parts[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(parts[i+1], 64)
+		if err != nil {
+			return 0, ErrInvalidTTL
+		}
+		total += time.Duration(n * float64(unit))
+	}
+	return total, nil
+}
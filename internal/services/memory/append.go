@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/models"
+)
+
+const (
+	// appendLogPrefix keys the Redis list of chunks appended to a long-term
+	// memory value, oldest first.
+	appendLogPrefix = "memory:log:"
+	// appendLogIndexPrefix keys the set of an agent's appended keys, so they
+	// can be removed with the agent.
+	appendLogIndexPrefix = "memory:logs:"
+)
+
+// Errors for appending to memory.
+var (
+	ErrNotAppendable = errors.New("memory key holds a stored value, not appended chunks")
+	ErrTooManyChunks = errors.New("memory value holds the maximum number of chunks")
+	ErrChunkTooLarge = errors.New("memory chunk is too large")
+)
+
+// appendedChunk is one chunk of an appended memory value.
+type appendedChunk struct {
+	Value      json.RawMessage `json:"value"`
+	AppendedAt time.Time       `json:"appended_at"`
+}
+
+// AppendLongTerm appends a chunk to a list-style long-term memory value and
+// returns the number of chunks the value now holds and its new version.
+// Chunks are kept in Redis rather than the memory server, so growing a value
+// costs only the chunk; GetLongTerm returns them assembled into a list.
+// Chunks are never offloaded, so each may be at most the offload threshold,
+// and a value holds at most the configured number of chunks. Appending to a
+// key that holds a stored value returns ErrNotAppendable.
+func (m *MemoryManager) AppendLongTerm(ctx context.Context, agentID, key string, chunk interface{}) (chunks, version int64, err error) {
+	value, err := json.Marshal(chunk)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to marshal memory chunk: %w", err)
+	}
+	if len(value) > m.offloadThreshold {
+		return 0, 0, fmt.Errorf("%w: chunks must be at most %d bytes", ErrChunkTooLarge, m.offloadThreshold)
+	}
+	data, err := json.Marshal(appendedChunk{Value: value, AppendedAt: models.Now()})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to marshal memory chunk: %w", err)
+	}
+
+	logKey := appendLogPrefix + agentID + ":" + key
+	version, err = m.withVersion(ctx, agentID, key, nil, func() error {
+		// The key's lock is held, so the length can't change underneath
+		current, err := m.redis.LLen(ctx, logKey).Result()
+		if err != nil {
+			return fmt.Errorf("failed to append memory: %w", err)
+		}
+		if current >= int64(m.appendMaxChunks) {
+			return fmt.Errorf("%w (%d)", ErrTooManyChunks, m.appendMaxChunks)
+		}
+		if current == 0 {
+			_, err := m.get(ctx, m.longTermURL, longTermMemoryPrefix+agentID+":"+key)
+			if err == nil {
+				return ErrNotAppendable
+			}
+			if !errors.Is(err, ErrMemoryNotFound) {
+				return err
+			}
+		}
+
+		var length *redis.IntCmd
+		_, err = m.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			length = pipe.RPush(ctx, logKey, data)
+			if m.appendTTL > 0 {
+				pipe.Expire(ctx, logKey, m.appendTTL)
+			}
+			pipe.SAdd(ctx, appendLogIndexPrefix+agentID, key)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to append memory: %w", err)
+		}
+		chunks = length.Val()
+		return nil
+	})
+	return chunks, version, err
+}
+
+// getAppended assembles an appended long-term memory value, returning nil if
+// nothing was appended to the key.
+func (m *MemoryManager) getAppended(ctx context.Context, agentID, key string) (*models.Memory, error) {
+	entries, err := m.redis.LRange(ctx, appendLogPrefix+agentID+":"+key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appended memory: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	values := make([]json.RawMessage, 0, len(entries))
+	var last time.Time
+	for _, entry := range entries {
+		var chunk appendedChunk
+		if err := json.Unmarshal([]byte(entry), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to decode appended memory: %w", err)
+		}
+		values = append(values, chunk.Value)
+		last = chunk.AppendedAt
+	}
+
+	return &models.Memory{
+		Key:        key,
+		Value:      values,
+		MemoryType: models.MemoryTypeLongTerm,
+		StoredAt:   last,
+	}, nil
+}
+
+// deleteAppended removes the chunks appended to a long-term memory key.
+func (m *MemoryManager) deleteAppended(ctx context.Context, agentID, key string) error {
+	_, err := m.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, appendLogPrefix+agentID+":"+key)
+		pipe.SRem(ctx, appendLogIndexPrefix+agentID, key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete appended memory: %w", err)
+	}
+	return nil
+}
+
+// deleteAgentAppended removes every appended memory value held for an agent.
+func (m *MemoryManager) deleteAgentAppended(ctx context.Context, agentID string) error {
+	keys, err := m.redis.SMembers(ctx, appendLogIndexPrefix+agentID).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list appended memory: %w", err)
+	}
+
+	toDelete := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		toDelete = append(toDelete, appendLogPrefix+agentID+":"+key)
+	}
+	toDelete = append(toDelete, appendLogIndexPrefix+agentID)
+	if err := m.redis.Del(ctx, toDelete...).Err(); err != nil {
+		return fmt.Errorf("failed to delete appended memory: %w", err)
+	}
+	return nil
+}
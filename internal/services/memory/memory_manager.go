@@ -9,15 +9,25 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+
 	"agent-comm-hub/internal/config"
+	"agent-comm-hub/internal/metrics"
 	"agent-comm-hub/internal/models"
 )
 
 const (
 	shortTermMemoryPrefix = "memory:short:"
 	longTermMemoryPrefix  = "memory:long:"
+	offloadObjectPrefix   = "memory/"
+
+	// requestTimeoutHeader tells the memory server how many milliseconds
+	// remain before the hub abandons a request.
+	requestTimeoutHeader = "X-Request-Timeout"
 )
 
 // Errors for memory service.
@@ -28,17 +38,35 @@ var (
 
 // MemoryManager handles agent memory operations.
 type MemoryManager struct {
-	httpClient *http.Client
-	memoryURL  string
+	httpClient   *http.Client
+	timeout      time.Duration // Upper bound on each request, 0 = the caller's deadline only
+	shortTermURL string
+	longTermURL  string
+	redis        *redis.Client // Holds appended long-term values, versions and the offload index
+
+	objects          ObjectStore // nil keeps every value inline
+	offloadThreshold int
+
+	appendMaxChunks int
+	appendTTL       time.Duration
 }
 
-// NewMemoryManager creates a new memory manager.
-func NewMemoryManager(cfg *config.MemoryConfig) *MemoryManager {
+// NewMemoryManager creates a new memory manager. Short-term and long-term
+// memory may be kept on separate memory servers. Values larger than the
+// configured threshold are offloaded to objects, if it is not nil, leaving
+// only a reference in the memory server. Chunks appended to long-term values
+// are kept in redisStd.
+func NewMemoryManager(cfg *config.MemoryConfig, objects ObjectStore, redisStd *redis.Client) *MemoryManager {
 	return &MemoryManager{
-		httpClient: &http.Client{
-			Timeout: cfg.Timeout,
-		},
-		memoryURL: cfg.URL,
+		httpClient:       &http.Client{},
+		timeout:          cfg.Timeout,
+		shortTermURL:     cfg.ShortTermURL,
+		longTermURL:      cfg.LongTermURL,
+		redis:            redisStd,
+		objects:          objects,
+		offloadThreshold: cfg.OffloadThreshold,
+		appendMaxChunks:  cfg.AppendMaxChunks,
+		appendTTL:        cfg.AppendTTL,
 	}
 }
 
@@ -52,38 +80,80 @@ func (m *MemoryManager) StoreShortTerm(ctx context.Context, agentID, key string,
 		TTL:        int(ttl.Seconds()),
 	}
 
-	return m.store(ctx, reqBody)
+	return m.store(ctx, m.shortTermURL, agentID, reqBody)
 }
 
 // GetShortTerm retrieves short-term memory.
 func (m *MemoryManager) GetShortTerm(ctx context.Context, agentID, key string) (*models.Memory, error) {
-	return m.get(ctx, shortTermMemoryPrefix+agentID+":"+key)
+	return m.get(ctx, m.shortTermURL, shortTermMemoryPrefix+agentID+":"+key)
 }
 
 // DeleteShortTerm deletes short-term memory.
 func (m *MemoryManager) DeleteShortTerm(ctx context.Context, agentID, key string) error {
-	return m.delete(ctx, shortTermMemoryPrefix+agentID+":"+key)
+	return m.delete(ctx, m.shortTermURL, agentID, shortTermMemoryPrefix+agentID+":"+key)
 }
 
-// StoreLongTerm stores long-term memory.
-func (m *MemoryManager) StoreLongTerm(ctx context.Context, agentID, key string, value interface{}) error {
-	reqBody := models.StoreMemoryRequest{
-		MemoryType: models.MemoryTypeLongTerm,
-		Key:        longTermMemoryPrefix + agentID + ":" + key,
-		Value:      value,
-	}
+// StoreLongTerm stores long-term memory, replacing any chunks appended to the
+// key, and returns the key's new version. With a non-nil expected version the
+// value is only stored if the key is still at that version, and
+// ErrVersionConflict is returned otherwise.
+func (m *MemoryManager) StoreLongTerm(ctx context.Context, agentID, key string, value interface{}, expected *int64) (int64, error) {
+	return m.withVersion(ctx, agentID, key, expected, func() error {
+		if err := m.deleteAppended(ctx, agentID, key); err != nil {
+			return err
+		}
+
+		reqBody := models.StoreMemoryRequest{
+			MemoryType: models.MemoryTypeLongTerm,
+			Key:        longTermMemoryPrefix + agentID + ":" + key,
+			Value:      value,
+		}
 
-	return m.store(ctx, reqBody)
+		return m.store(ctx, m.longTermURL, agentID, reqBody)
+	})
 }
 
-// GetLongTerm retrieves long-term memory.
+// GetLongTerm retrieves long-term memory, with its version. A value built
+// with AppendLongTerm is returned as the list of its chunks.
 func (m *MemoryManager) GetLongTerm(ctx context.Context, agentID, key string) (*models.Memory, error) {
-	return m.get(ctx, longTermMemoryPrefix+agentID+":"+key)
+	// Read the version first: a write landing in between then makes the
+	// version stale rather than the value, failing a later versioned store
+	version, err := m.version(ctx, agentID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	mem, err := m.getAppended(ctx, agentID, key)
+	if err != nil {
+		return nil, err
+	}
+	if mem == nil {
+		mem, err = m.get(ctx, m.longTermURL, longTermMemoryPrefix+agentID+":"+key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mem.Version = version
+	return mem, nil
 }
 
-// DeleteLongTerm deletes long-term memory.
+// DeleteLongTerm deletes long-term memory, including appended chunks. Its
+// version is kept as a tombstone, so versions seen before the delete never
+// match a value written after it.
 func (m *MemoryManager) DeleteLongTerm(ctx context.Context, agentID, key string) error {
-	return m.delete(ctx, longTermMemoryPrefix+agentID+":"+key)
+	unlock, err := m.lock(ctx, agentID, key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := m.deleteAppended(ctx, agentID, key); err != nil {
+		return err
+	}
+	if err := m.delete(ctx, m.longTermURL, agentID, longTermMemoryPrefix+agentID+":"+key); err != nil {
+		return err
+	}
+	return m.tombstoneVersion(ctx, agentID, key)
 }
 
 // SearchLongTerm searches long-term memory.
@@ -92,13 +162,69 @@ func (m *MemoryManager) SearchLongTerm(ctx context.Context, agentID, query strin
 	return []models.Memory{}, nil
 }
 
-func (m *MemoryManager) store(ctx context.Context, req models.StoreMemoryRequest) error {
+// Check verifies the memory servers are reachable and report themselves
+// healthy.
+func (m *MemoryManager) Check(ctx context.Context) error {
+	if err := m.check(ctx, m.shortTermURL); err != nil {
+		return err
+	}
+	if m.longTermURL == m.shortTermURL {
+		return nil
+	}
+	return m.check(ctx, m.longTermURL)
+}
+
+func (m *MemoryManager) check(ctx context.Context, baseURL string) error {
+	ctx, cancel := m.requestContext(ctx)
+	defer cancel()
+
+	req, err := newRequest(ctx, "GET", baseURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("memory server %s unreachable: %w", baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("memory server %s returned status %d", baseURL, resp.StatusCode)
+	}
+	return nil
+}
+
+// DeleteAgent deletes all short-term and long-term memory held for an agent.
+func (m *MemoryManager) DeleteAgent(ctx context.Context, agentID string) error {
+	if err := m.deletePrefix(ctx, m.shortTermURL, shortTermMemoryPrefix+agentID+":"); err != nil {
+		return err
+	}
+	if err := m.deleteAgentAppended(ctx, agentID); err != nil {
+		return err
+	}
+	if err := m.redis.Del(ctx, memoryVersionsPrefix+agentID, offloadIndexPrefix+agentID).Err(); err != nil {
+		return fmt.Errorf("failed to delete memory versions and offload index: %w", err)
+	}
+	return m.deletePrefix(ctx, m.longTermURL, longTermMemoryPrefix+agentID+":")
+}
+
+func (m *MemoryManager) store(ctx context.Context, baseURL, agentID string, req models.StoreMemoryRequest) (err error) {
+	if err := m.offload(ctx, agentID, &req); err != nil {
+		return err
+	}
+
+	defer observe("store", time.Now(), &err)
+
+	ctx, cancel := m.requestContext(ctx)
+	defer cancel()
+
 	data, err := json.Marshal(req)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", m.memoryURL+"/memory", bytes.NewReader(data))
+	httpReq, err := newRequest(ctx, "POST", baseURL+"/memory", bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -119,8 +245,13 @@ func (m *MemoryManager) store(ctx context.Context, req models.StoreMemoryRequest
 	return nil
 }
 
-func (m *MemoryManager) get(ctx context.Context, key string) (*models.Memory, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", m.memoryURL+"/memory?key="+key, nil)
+func (m *MemoryManager) get(ctx context.Context, baseURL, key string) (_ *models.Memory, err error) {
+	defer observe("get", time.Now(), &err)
+
+	ctx, cancel := m.requestContext(ctx)
+	defer cancel()
+
+	req, err := newRequest(ctx, "GET", baseURL+"/memory?key="+url.QueryEscape(key), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -145,11 +276,23 @@ func (m *MemoryManager) get(ctx context.Context, key string) (*models.Memory, er
 		return nil, fmt.Errorf("failed to decode memory: %w", err)
 	}
 
+	if err := m.resolve(ctx, key, &memory); err != nil {
+		return nil, err
+	}
 	return &memory, nil
 }
 
-func (m *MemoryManager) delete(ctx context.Context, key string) error {
-	req, err := http.NewRequestWithContext(ctx, "DELETE", m.memoryURL+"/memory?key="+key, nil)
+func (m *MemoryManager) delete(ctx context.Context, baseURL, agentID, key string) (err error) {
+	if err := m.dropObject(ctx, agentID, key); err != nil {
+		return err
+	}
+
+	defer observe("delete", time.Now(), &err)
+
+	ctx, cancel := m.requestContext(ctx)
+	defer cancel()
+
+	req, err := newRequest(ctx, "DELETE", baseURL+"/memory?key="+url.QueryEscape(key), nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -167,3 +310,70 @@ func (m *MemoryManager) delete(ctx context.Context, key string) error {
 
 	return nil
 }
+
+func (m *MemoryManager) deletePrefix(ctx context.Context, baseURL, prefix string) (err error) {
+	if m.objects != nil {
+		if err := m.objects.DeletePrefix(ctx, offloadObjectPrefix+prefix); err != nil {
+			return fmt.Errorf("failed to delete offloaded memory: %w", err)
+		}
+	}
+
+	defer observe("delete_prefix", time.Now(), &err)
+
+	ctx, cancel := m.requestContext(ctx)
+	defer cancel()
+
+	req, err := newRequest(ctx, "DELETE", baseURL+"/memory?prefix="+url.QueryEscape(prefix), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete memory: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("memory server returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// requestContext bounds a memory server request by the configured timeout,
+// or by ctx's own deadline if that is sooner, so memory calls stay within
+// the caller's latency budget.
+func (m *MemoryManager) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, m.timeout)
+}
+
+// newRequest creates a memory server request bound to ctx, passing the time
+// remaining until its deadline on to the server.
+func newRequest(ctx context.Context, method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(requestTimeoutHeader, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	}
+	return req, nil
+}
+
+// observe records memory server latency, classifying the outcome from the
+// operation's returned error.
+func observe(operation string, start time.Time, err *error) {
+	outcome := metrics.OutcomeOK
+	switch {
+	case errors.Is(*err, ErrMemoryNotFound):
+		outcome = metrics.OutcomeNotFound
+	case *err != nil:
+		outcome = metrics.OutcomeError
+	}
+	metrics.ObserveMemoryServer(operation, outcome, start)
+}
@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"agent-comm-hub/internal/config"
+)
+
+// Memory value storage backends.
+const (
+	OffloadInline = "inline"
+	OffloadS3     = "s3"
+)
+
+// ErrObjectNotFound is returned when an offloaded value is missing from the
+// object store.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore holds memory values too large to keep inline in the memory
+// server.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix deletes every object whose key starts with prefix.
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// NewObjectStore creates the object store for the configured offload
+// backend. The inline backend offloads nothing and returns a nil store.
+func NewObjectStore(cfg *config.MemoryConfig) (ObjectStore, error) {
+	switch cfg.OffloadBackend {
+	case OffloadInline, "":
+		return nil, nil
+	case OffloadS3:
+		return NewS3Store(&cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown memory offload backend %q", cfg.OffloadBackend)
+	}
+}
@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"agent-comm-hub/internal/models"
+)
+
+const (
+	// offloadRefField marks a memory value that was moved to the object store.
+	offloadRefField = "$offloaded"
+
+	// offloadIndexPrefix prefixes the per-agent set of memory server keys
+	// whose values are held in the object store, so storing a value inline
+	// only deletes an object when there is one.
+	offloadIndexPrefix = "memory:offloaded:"
+)
+
+// ErrInvalidValue is returned for memory values that use the reserved field.
+var ErrInvalidValue = errors.New("invalid memory value")
+
+// offloadRef is stored in the memory server in place of an offloaded value.
+type offloadRef struct {
+	Object string `json:"$offloaded"` // Object store key holding the value
+	Size   int    `json:"size"`
+}
+
+// offload moves a request's value to the object store when its encoding is
+// larger than the threshold, replacing it with a reference. A value stored
+// inline deletes any object left by an earlier offloaded value of the key.
+func (m *MemoryManager) offload(ctx context.Context, agentID string, req *models.StoreMemoryRequest) error {
+	if m.objects == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(req.Value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal memory value: %w", err)
+	}
+	object := offloadObjectPrefix + req.Key
+	if len(data) <= m.offloadThreshold {
+		return m.dropObject(ctx, agentID, req.Key)
+	}
+
+	// Record the object before writing it, so it is never left untracked
+	if err := m.redis.SAdd(ctx, offloadIndexPrefix+agentID, req.Key).Err(); err != nil {
+		return fmt.Errorf("failed to record offloaded memory value: %w", err)
+	}
+	if err := m.objects.Put(ctx, object, data); err != nil {
+		return fmt.Errorf("failed to offload memory value: %w", err)
+	}
+	req.Value = offloadRef{Object: object, Size: len(data)}
+	return nil
+}
+
+// dropObject deletes the object holding a key's offloaded value, if the key
+// has one.
+func (m *MemoryManager) dropObject(ctx context.Context, agentID, key string) error {
+	if m.objects == nil {
+		return nil
+	}
+
+	index := offloadIndexPrefix + agentID
+	offloaded, err := m.redis.SIsMember(ctx, index, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to check for offloaded memory value: %w", err)
+	}
+	if !offloaded {
+		return nil
+	}
+
+	if err := m.objects.Delete(ctx, offloadObjectPrefix+key); err != nil {
+		return fmt.Errorf("failed to delete offloaded memory value: %w", err)
+	}
+	if err := m.redis.SRem(ctx, index, key).Err(); err != nil {
+		return fmt.Errorf("failed to record deleted memory value: %w", err)
+	}
+	return nil
+}
+
+// resolve replaces an offloaded value reference with the value itself. Only
+// a reference to the object for key itself is followed, so a value can never
+// reach another key's object.
+func (m *MemoryManager) resolve(ctx context.Context, key string, memory *models.Memory) error {
+	fields, ok := memory.Value.(map[string]interface{})
+	if !ok || len(fields) != 2 {
+		return nil
+	}
+	object, ok := fields[offloadRefField].(string)
+	if !ok || object != offloadObjectPrefix+key {
+		return nil
+	}
+	if m.objects == nil {
+		return fmt.Errorf("memory value was offloaded but no object store is configured")
+	}
+
+	data, err := m.objects.Get(ctx, object)
+	if err != nil {
+		return fmt.Errorf("failed to load offloaded memory value: %w", err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("failed to decode offloaded memory value: %w", err)
+	}
+	memory.Value = value
+	return nil
+}
+
+// ValidateValue checks that a memory value is not an object with the
+// reserved offloadRefField, which the hub uses to mark offloaded values.
+func ValidateValue(value interface{}) error {
+	if fields, ok := value.(map[string]interface{}); ok {
+		if _, reserved := fields[offloadRefField]; reserved {
+			return fmt.Errorf("%w: field %q is reserved", ErrInvalidValue, offloadRefField)
+		}
+	}
+	return nil
+}
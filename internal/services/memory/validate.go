@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxKeyLength is the longest memory key accepted, in bytes.
+const MaxKeyLength = 256
+
+// ErrInvalidKey is returned for memory keys that can't be stored safely.
+var ErrInvalidKey = errors.New("invalid memory key")
+
+// ValidateKey checks that a memory key is non-empty valid UTF-8 of at most
+// MaxKeyLength bytes, without whitespace, control characters or the glob
+// characters * ? [ ] \ that would make the key match others when agents'
+// keys are scanned by pattern.
+func ValidateKey(key string) error {
+	switch {
+	case key == "":
+		return fmt.Errorf("%w: key is required", ErrInvalidKey)
+	case len(key) > MaxKeyLength:
+		return fmt.Errorf("%w: key must be at most %d bytes", ErrInvalidKey, MaxKeyLength)
+	case !utf8.ValidString(key):
+		return fmt.Errorf("%w: key must be valid UTF-8", ErrInvalidKey)
+	}
+
+	if i := strings.IndexFunc(key, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(`*?[]\`, r)
+	}); i >= 0 {
+		r, _ := utf8.DecodeRuneInString(key[i:])
+		return fmt.Errorf("%w: key must not contain %q", ErrInvalidKey, r)
+	}
+	return nil
+}
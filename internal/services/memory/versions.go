@@ -0,0 +1,169 @@
+package memory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// memoryVersionsPrefix keys the hash of an agent's long-term memory keys
+	// to their versions, which count the writes to each key. A deleted key
+	// keeps its count negated as a tombstone, so a key written again goes on
+	// counting instead of reusing versions. Keys without a version have never
+	// been written, or predate versioning.
+	memoryVersionsPrefix = "memory:versions:"
+	// memoryLockPrefix keys the lock serializing writes to one long-term
+	// memory key.
+	memoryLockPrefix = "memory:lock:"
+
+	// lockHoldMargin is added to the request timeout for how long a write
+	// lock is held at most, should its holder die without releasing it.
+	lockHoldMargin  = 5 * time.Second
+	defaultLockHold = 30 * time.Second
+	lockRetryDelay  = 10 * time.Millisecond
+	maxLockDelay    = 100 * time.Millisecond
+)
+
+// Errors for versioned memory writes.
+var (
+	ErrVersionConflict = errors.New("memory version conflict")
+	ErrMemoryBusy      = errors.New("memory key is being written")
+)
+
+// releaseLock deletes the lock at KEYS[1] only if it still holds the token
+// ARGV[1], so an expired holder can't release a lock taken over by another.
+var releaseLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// lockHold returns how long a write lock may be held.
+func (m *MemoryManager) lockHold() time.Duration {
+	if m.timeout <= 0 {
+		return defaultLockHold
+	}
+	return m.timeout + lockHoldMargin
+}
+
+// withVersion runs write holding the lock on a long-term memory key, and
+// bumps the key's version if it succeeds, returning the new version. With a
+// non-nil expected version, the write is refused with ErrVersionConflict
+// unless the key's current version matches. Every long-term write goes
+// through here, so a read-modify-write guarded by the version read can't be
+// clobbered by any other write.
+func (m *MemoryManager) withVersion(ctx context.Context, agentID, key string, expected *int64, write func() error) (int64, error) {
+	unlock, err := m.lock(ctx, agentID, key)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	stored, err := m.storedVersion(ctx, agentID, key)
+	if err != nil {
+		return 0, err
+	}
+	if expected != nil {
+		if current := max(stored, 0); current != *expected {
+			return current, fmt.Errorf("%w: expected version %d, current version is %d", ErrVersionConflict, *expected, current)
+		}
+	}
+
+	if err := write(); err != nil {
+		return 0, err
+	}
+
+	// The lock is held, so nothing else moves the version in between
+	version := abs(stored) + 1
+	if err := m.redis.HSet(ctx, memoryVersionsPrefix+agentID, key, version).Err(); err != nil {
+		return 0, fmt.Errorf("failed to update memory version: %w", err)
+	}
+	return version, nil
+}
+
+// version returns the current version of a long-term memory key, 0 if it
+// has none or was deleted.
+func (m *MemoryManager) version(ctx context.Context, agentID, key string) (int64, error) {
+	stored, err := m.storedVersion(ctx, agentID, key)
+	return max(stored, 0), err
+}
+
+// storedVersion returns the version entry of a long-term memory key: its
+// version, or its last version negated if it was deleted.
+func (m *MemoryManager) storedVersion(ctx context.Context, agentID, key string) (int64, error) {
+	version, err := m.redis.HGet(ctx, memoryVersionsPrefix+agentID, key).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("failed to get memory version: %w", err)
+	}
+	return version, nil
+}
+
+// tombstoneVersion marks the version of a deleted long-term memory key, so
+// the key reads as version 0 but its next write continues the count. The
+// caller holds the key's lock.
+func (m *MemoryManager) tombstoneVersion(ctx context.Context, agentID, key string) error {
+	stored, err := m.storedVersion(ctx, agentID, key)
+	if err != nil || stored <= 0 {
+		return err
+	}
+	if err := m.redis.HSet(ctx, memoryVersionsPrefix+agentID, key, -stored).Err(); err != nil {
+		return fmt.Errorf("failed to update memory version: %w", err)
+	}
+	return nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// lock takes the write lock on a long-term memory key, waiting for another
+// writer to finish until ctx is done or for at most the time a lock can be
+// held. It returns a function releasing the lock.
+func (m *MemoryManager) lock(ctx context.Context, agentID, key string) (func(), error) {
+	lockKey := memoryLockPrefix + agentID + ":" + key
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to create lock token: %w", err)
+	}
+	value := hex.EncodeToString(token)
+
+	hold := m.lockHold()
+	deadline := time.Now().Add(hold)
+	delay := lockRetryDelay
+	for {
+		acquired, err := m.redis.SetNX(ctx, lockKey, value, hold).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock memory key: %w", err)
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrMemoryBusy
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", ErrMemoryBusy, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxLockDelay)
+	}
+
+	return func() {
+		// Release even if the request was cancelled mid-write
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		releaseLock.Run(releaseCtx, m.redis, []string{lockKey}, value)
+	}, nil
+}
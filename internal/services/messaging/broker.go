@@ -0,0 +1,52 @@
+package messaging
+
+import (
+	"context"
+	"time"
+
+	"agent-comm-hub/internal/models"
+)
+
+// Broker is the message broker used by the HTTP handlers. MessageBroker is
+// the Redis implementation.
+type Broker interface {
+	SendMessage(ctx context.Context, fromAgentID string, req *models.SendMessageRequest) (*models.Message, *Receipt, error)
+	PreviewMessage(ctx context.Context, fromAgentID string, req *models.SendMessageRequest) (*models.Message, *Receipt, error)
+	SendBatch(ctx context.Context, fromAgentID string, reqs []models.SendMessageRequest) ([]models.BatchSendResult, error)
+	GetMessageHistory(ctx context.Context, agentID string, offset, limit int) ([]models.Message, int, error)
+	SearchHistory(ctx context.Context, agentID, query string, offset, limit int) ([]models.Message, int, error)
+	GetMergedHistory(ctx context.Context, agentIDs []string, offset, limit int) ([]models.Message, int, error)
+	CompactedTopic(ctx context.Context, topic string) ([]models.Message, error)
+	GetStats(ctx context.Context, agentID string) (*models.AgentStats, error)
+	RecordDelivery(ctx context.Context, agentID string, latency time.Duration)
+	Ping(ctx context.Context, agentID string, wait time.Duration) (*models.PingResult, error)
+	DrainQueue(ctx context.Context, agentID string, deliver func(models.Message) error) error
+	PullQueue(ctx context.Context, agentID string, limit int, visibility time.Duration) ([]models.Message, int64, error)
+	Ack(ctx context.Context, agentID string, messageIDs []string) (int, error)
+	QueueDepth(ctx context.Context, agentID string) (*models.QueueDepth, error)
+	DeadLetters(ctx context.Context, agentID string, offset, limit int) ([]models.Message, int, error)
+	NotifyDraining(ctx context.Context, agentID string) ([]string, error)
+	BroadcastCategories(ctx context.Context, agentID string) ([]string, error)
+	SetBroadcastCategories(ctx context.Context, agentID string, categories []string) error
+
+	Channels() *ChannelNames
+	Subscribe(ctx context.Context, agentID string) (Subscription, error)
+	SubscribeAgent(ctx context.Context, agentID string, topics, groups []string) (Subscription, error)
+	SubscribeAll(ctx context.Context) (Subscription, error)
+	PSubscribe(ctx context.Context, pattern string) (Subscription, error)
+}
+
+// Envelope is a message received from a subscription.
+type Envelope struct {
+	Channel string // Channel the message was published on
+	Payload string // Encoded models.Message
+}
+
+// Subscription is a live feed of published messages. Messages is closed
+// when the subscription ends.
+type Subscription interface {
+	Messages() <-chan Envelope
+	Close() error
+}
+
+var _ Broker = (*MessageBroker)(nil)
@@ -0,0 +1,67 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/models"
+)
+
+// broadcastCategoriesPrefix keys the set of broadcast categories an agent
+// accepts. Agents without one receive every broadcast.
+const broadcastCategoriesPrefix = "agent:categories:"
+
+// validCategory reports whether a broadcast category is a non-empty name
+// without whitespace or commas.
+func validCategory(category string) bool {
+	return category != "" && !strings.ContainsFunc(category, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// BroadcastCategories returns the broadcast categories an agent accepts, or
+// an empty list when it accepts every broadcast.
+func (b *MessageBroker) BroadcastCategories(ctx context.Context, agentID string) ([]string, error) {
+	categories, err := b.redisStd.SMembers(ctx, broadcastCategoriesPrefix+agentID).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get broadcast categories: %w", err)
+	}
+	return categories, nil
+}
+
+// SetBroadcastCategories replaces the broadcast categories an agent accepts.
+// An empty list accepts every broadcast. Streams pick up the change when
+// they next connect.
+func (b *MessageBroker) SetBroadcastCategories(ctx context.Context, agentID string, categories []string) error {
+	members := make([]interface{}, 0, len(categories))
+	for _, category := range categories {
+		if !validCategory(category) {
+			return fmt.Errorf("%w: %q", ErrInvalidCategory, category)
+		}
+		members = append(members, category)
+	}
+
+	key := broadcastCategoriesPrefix + agentID
+	_, err := b.redisStd.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		if len(members) > 0 {
+			pipe.SAdd(ctx, key, members...)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set broadcast categories: %w", err)
+	}
+	return nil
+}
+
+// AcceptsBroadcast reports whether a subscriber accepting categories, where
+// empty accepts everything, should receive a broadcast. Uncategorized
+// broadcasts reach every subscriber.
+func AcceptsBroadcast(categories map[string]bool, msg *models.Message) bool {
+	return len(categories) == 0 || msg.Category == "" || categories[msg.Category]
+}
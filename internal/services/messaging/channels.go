@@ -0,0 +1,200 @@
+package messaging
+
+import (
+	"fmt"
+	"strings"
+
+	"agent-comm-hub/internal/config"
+	"agent-comm-hub/internal/models"
+)
+
+// Placeholders in channel name templates.
+const (
+	placeholderID   = "{id}"
+	placeholderName = "{name}"
+)
+
+// globChars are special in Redis channel patterns and not allowed in
+// channel names.
+const globChars = "*?[]\\"
+
+// natsNameChars can't appear in NATS subject tokens, or would be read as
+// token separators or wildcards, so names filled into channel templates may
+// not contain them on the NATS backend.
+const natsNameChars = ".*> \t\r\n"
+
+// ChannelNames builds and recognizes the pub/sub channels messages are
+// published on, following configurable templates: direct messages on
+// "agent:message:{id}", topics on "agent:topic:{name}", groups on
+// "agent:group:{name}" and broadcasts on "agent:broadcast" by default.
+type ChannelNames struct {
+	direct    channelTemplate
+	topic     channelTemplate
+	group     channelTemplate
+	broadcast string
+	reserved  string // Characters names may not contain, beyond globChars
+}
+
+// channelTemplate is a channel name template split around its placeholder.
+type channelTemplate struct {
+	prefix, suffix string
+}
+
+// NewChannelNames parses and validates the configured channel templates.
+// Each template must contain its placeholder exactly once, and the channels
+// of different kinds must not overlap.
+func NewChannelNames(cfg *config.MessagingConfig) (*ChannelNames, error) {
+	c := &ChannelNames{broadcast: cfg.BroadcastChannel, reserved: globChars}
+	if cfg.Backend == BackendNATS {
+		c.reserved += natsNameChars
+	}
+
+	var err error
+	if c.direct, err = parseTemplate("direct", cfg.DirectChannel, placeholderID); err != nil {
+		return nil, err
+	}
+	if c.topic, err = parseTemplate("topic", cfg.TopicChannel, placeholderName); err != nil {
+		return nil, err
+	}
+	if c.group, err = parseTemplate("group", cfg.GroupChannel, placeholderName); err != nil {
+		return nil, err
+	}
+	if c.broadcast == "" || strings.ContainsAny(c.broadcast, globChars+"{}") {
+		return nil, fmt.Errorf("broadcast channel %q must be a non-empty literal name", c.broadcast)
+	}
+
+	templates := map[string]channelTemplate{"direct": c.direct, "topic": c.topic, "group": c.group}
+	for kind, t := range templates {
+		if _, ok := t.match(c.broadcast); ok {
+			return nil, fmt.Errorf("broadcast channel %q overlaps %s channels", c.broadcast, kind)
+		}
+		for other, u := range templates {
+			if kind < other && t.overlaps(u) {
+				return nil, fmt.Errorf("%s and %s channel templates overlap", kind, other)
+			}
+		}
+	}
+	return c, nil
+}
+
+func parseTemplate(kind, template, placeholder string) (channelTemplate, error) {
+	prefix, suffix, ok := strings.Cut(template, placeholder)
+	if !ok || strings.Contains(suffix, placeholder) {
+		return channelTemplate{}, fmt.Errorf("%s channel template %q must contain %s once", kind, template, placeholder)
+	}
+	if prefix == "" || strings.ContainsAny(template, globChars) {
+		return channelTemplate{}, fmt.Errorf("%s channel template %q must start with a fixed prefix and contain no pattern characters", kind, template)
+	}
+	return channelTemplate{prefix: prefix, suffix: suffix}, nil
+}
+
+// name fills in the template.
+func (t channelTemplate) name(value string) string {
+	return t.prefix + value + t.suffix
+}
+
+// match reports whether channel follows the template, and the value filled in.
+func (t channelTemplate) match(channel string) (string, bool) {
+	value, ok := strings.CutPrefix(channel, t.prefix)
+	if !ok {
+		return "", false
+	}
+	value, ok = strings.CutSuffix(value, t.suffix)
+	return value, ok && value != ""
+}
+
+// overlaps reports whether some channel could follow both templates.
+func (t channelTemplate) overlaps(u channelTemplate) bool {
+	prefixes := strings.HasPrefix(t.prefix, u.prefix) || strings.HasPrefix(u.prefix, t.prefix)
+	suffixes := strings.HasSuffix(t.suffix, u.suffix) || strings.HasSuffix(u.suffix, t.suffix)
+	return prefixes && suffixes
+}
+
+// pattern returns the pattern matching every channel of the template.
+func (t channelTemplate) pattern() string {
+	return t.prefix + "*" + t.suffix
+}
+
+// For returns the channel for a recipient: "broadcast", "topic:<name>",
+// "group:<name>" or an agent ID. Other reserved recipients are invalid.
+func (c *ChannelNames) For(recipient string) (string, error) {
+	switch {
+	case recipient == models.RecipientBroadcast:
+		return c.broadcast, nil
+	case strings.HasPrefix(recipient, models.RecipientTopicPrefix):
+		return c.Topic(strings.TrimPrefix(recipient, models.RecipientTopicPrefix))
+	case strings.HasPrefix(recipient, models.RecipientGroupPrefix):
+		return c.Group(strings.TrimPrefix(recipient, models.RecipientGroupPrefix))
+	case recipient == "", models.IsReserved(recipient), strings.ContainsAny(recipient, c.reserved):
+		return "", ErrInvalidRecipient
+	default:
+		return c.Direct(recipient), nil
+	}
+}
+
+// Direct returns the channel of an agent's direct messages.
+func (c *ChannelNames) Direct(agentID string) string {
+	return c.direct.name(agentID)
+}
+
+// Topic returns a topic's channel.
+func (c *ChannelNames) Topic(name string) (string, error) {
+	return c.named(c.topic, name)
+}
+
+// Group returns a group's channel.
+func (c *ChannelNames) Group(name string) (string, error) {
+	return c.named(c.group, name)
+}
+
+// Broadcast returns the broadcast channel.
+func (c *ChannelNames) Broadcast() string {
+	return c.broadcast
+}
+
+// IsDirect reports whether channel carries direct messages to one agent.
+func (c *ChannelNames) IsDirect(channel string) bool {
+	_, ok := c.direct.match(channel)
+	return ok
+}
+
+// TopicName returns the topic a channel carries, if it is a topic channel.
+func (c *ChannelNames) TopicName(channel string) (string, bool) {
+	return c.topic.match(channel)
+}
+
+// patterns returns the patterns matching every direct, topic and group
+// channel.
+func (c *ChannelNames) patterns() []string {
+	return []string{c.direct.pattern(), c.topic.pattern(), c.group.pattern()}
+}
+
+// ValidatePattern checks a subscription pattern: a direct, topic or group
+// channel prefix followed by a single trailing "*", such as
+// "agent:message:worker-*" or "agent:topic:*". It reports whether the
+// pattern covers direct messages, which it does when it could match a direct
+// channel: a topic prefix that is also a prefix of the direct prefix, as with
+// "acme.*" for topics "acme.{name}.t" and direct channels "acme.agent.{id}",
+// matches both.
+func (c *ChannelNames) ValidatePattern(pattern string) (direct bool, err error) {
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	if !ok || strings.ContainsAny(prefix, globChars) {
+		return false, ErrInvalidPattern
+	}
+	switch {
+	case strings.HasPrefix(prefix, c.direct.prefix), strings.HasPrefix(c.direct.prefix, prefix):
+		return true, nil
+	case strings.HasPrefix(prefix, c.topic.prefix), strings.HasPrefix(prefix, c.group.prefix):
+		return false, nil
+	default:
+		return false, ErrInvalidPattern
+	}
+}
+
+// named fills a topic or group name into its template.
+func (c *ChannelNames) named(t channelTemplate, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, c.reserved) {
+		return "", ErrInvalidRecipient
+	}
+	return t.name(name), nil
+}
@@ -0,0 +1,70 @@
+package messaging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"agent-comm-hub/internal/models"
+)
+
+// History entries are stored either as plain JSON (always starting with '{')
+// or as a version byte followed by an encoded body. Entries written before
+// compression existed are plain JSON and continue to decode unchanged.
+const historyEntryGzip byte = 0x01
+
+// encodeHistoryEntry serializes a message for history storage, gzipping it
+// when the JSON form exceeds threshold bytes. A threshold of 0 disables
+// compression.
+func encodeHistoryEntry(msg *models.Message, threshold int) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	if threshold <= 0 || len(data) <= threshold {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(historyEntryGzip)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress message: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress message: %w", err)
+	}
+
+	// Keep the plain form if compression didn't help
+	if buf.Len() >= len(data) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeHistoryEntry parses a stored history entry in any supported format.
+func decodeHistoryEntry(entry []byte, msg *models.Message) error {
+	if len(entry) == 0 {
+		return fmt.Errorf("empty history entry")
+	}
+
+	switch entry[0] {
+	case historyEntryGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(entry[1:]))
+		if err != nil {
+			return fmt.Errorf("failed to decompress message: %w", err)
+		}
+		defer zr.Close()
+
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			return fmt.Errorf("failed to decompress message: %w", err)
+		}
+		return json.Unmarshal(data, msg)
+	default:
+		return json.Unmarshal(entry, msg)
+	}
+}
@@ -0,0 +1,92 @@
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-comm-hub/internal/models"
+)
+
+func largeMessage() *models.Message {
+	rows := make([]map[string]any, 200)
+	for i := range rows {
+		rows[i] = map[string]any{"sensor": "temperature", "unit": "celsius", "reading": 21.5, "status": "nominal"}
+	}
+	return &models.Message{
+		ID:        "msg-1",
+		FromAgent: "agent-a",
+		ToAgent:   "agent-b",
+		Type:      models.MessageTypeEvent,
+		Payload:   map[string]any{"rows": rows},
+		Timestamp: time.Now(),
+	}
+}
+
+func TestEncodeHistoryEntryCompressesLargeMessages(t *testing.T) {
+	msg := largeMessage()
+
+	plain, err := encodeHistoryEntry(msg, 0)
+	if err != nil {
+		t.Fatalf("encode without compression: %v", err)
+	}
+	compressed, err := encodeHistoryEntry(msg, 1024)
+	if err != nil {
+		t.Fatalf("encode with compression: %v", err)
+	}
+	t.Logf("history entry: %d bytes before, %d bytes after compression", len(plain), len(compressed))
+
+	if compressed[0] != historyEntryGzip {
+		t.Fatalf("compressed entry starts with %#x, want version byte %#x", compressed[0], historyEntryGzip)
+	}
+	if len(compressed)*4 > len(plain) {
+		t.Errorf("compressed entry is %d bytes, want at most a quarter of %d", len(compressed), len(plain))
+	}
+
+	var decoded models.Message
+	if err := decodeHistoryEntry(compressed, &decoded); err != nil {
+		t.Fatalf("decode compressed entry: %v", err)
+	}
+	roundTrip, _ := json.Marshal(&decoded)
+	if !bytes.Equal(roundTrip, plain) {
+		t.Errorf("decoded message differs from the original")
+	}
+}
+
+func TestEncodeHistoryEntryKeepsSmallMessagesPlain(t *testing.T) {
+	msg := &models.Message{ID: "msg-1", FromAgent: "agent-a", ToAgent: "agent-b", Payload: "hello"}
+
+	entry, err := encodeHistoryEntry(msg, 1024)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if entry[0] != '{' {
+		t.Errorf("small entry was not stored as plain JSON: %q", entry)
+	}
+}
+
+func TestDecodeHistoryEntryReadsLegacyEntries(t *testing.T) {
+	legacy := `{"id":"msg-1","from_agent":"agent-a","to_agent":"agent-b","type":"event","payload":"hello","timestamp":"2024-05-01T12:00:00Z"}`
+
+	var msg models.Message
+	if err := decodeHistoryEntry([]byte(legacy), &msg); err != nil {
+		t.Fatalf("decode legacy entry: %v", err)
+	}
+	if msg.ID != "msg-1" || msg.Payload != "hello" {
+		t.Errorf("decoded %+v from legacy entry", msg)
+	}
+}
+
+func TestDecodeHistoryEntryRejectsCorruptEntries(t *testing.T) {
+	corrupt := append([]byte{historyEntryGzip}, strings.Repeat("x", 16)...)
+
+	var msg models.Message
+	if err := decodeHistoryEntry(corrupt, &msg); err == nil {
+		t.Error("decoded a corrupt compressed entry without error")
+	}
+	if err := decodeHistoryEntry(nil, &msg); err == nil {
+		t.Error("decoded an empty entry without error")
+	}
+}
@@ -0,0 +1,128 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/models"
+)
+
+const (
+	// historyCompactionPrefix keys a hash of the latest history entry for
+	// each channel and compaction key in an agent's history.
+	historyCompactionPrefix = "agent:history:compaction:"
+	// topicStatePrefix keys a hash of the latest message for each compaction
+	// key sent to a topic.
+	topicStatePrefix = "agent:compacted:topic:"
+
+	// MaxCompactionKeyLength is the longest accepted compaction key.
+	MaxCompactionKeyLength = 256
+)
+
+// appendHistoryScript pushes an entry onto a history list, trimming it to
+// ARGV[2] entries and expiring it after ARGV[3] seconds. With a compaction
+// field (ARGV[4], see compactionField) the previous entry with that field is
+// removed first, and the new entry is recorded as the field's latest. Once
+// the hash of latest entries holds twice as many fields as the list may
+// hold entries, fields whose entries have been trimmed are dropped.
+var appendHistoryScript = redis.NewScript(`
+if ARGV[4] ~= '' then
+	local previous = redis.call('HGET', KEYS[2], ARGV[4])
+	if previous then
+		redis.call('LREM', KEYS[1], 1, previous)
+	end
+	redis.call('HSET', KEYS[2], ARGV[4], ARGV[1])
+	redis.call('EXPIRE', KEYS[2], ARGV[3])
+end
+redis.call('LPUSH', KEYS[1], ARGV[1])
+redis.call('LTRIM', KEYS[1], 0, tonumber(ARGV[2]) - 1)
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+if ARGV[4] ~= '' and redis.call('HLEN', KEYS[2]) > 2 * tonumber(ARGV[2]) then
+	local kept = {}
+	for _, entry in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+		kept[entry] = true
+	end
+	local fields = redis.call('HGETALL', KEYS[2])
+	for i = 1, #fields, 2 do
+		if not kept[fields[i + 1]] then
+			redis.call('HDEL', KEYS[2], fields[i])
+		end
+	end
+end
+return redis.status_reply('OK')
+`)
+
+// compactionField returns the field under which a message's history entry
+// is recorded as the latest for its compaction key. Compaction is scoped to
+// the message's channel, so a key reused on another topic or to another
+// agent doesn't remove the message. It is empty for messages without a key.
+func compactionField(msg *models.Message) string {
+	if msg.CompactionKey == "" {
+		return ""
+	}
+	return msg.Channel + "\x00" + msg.CompactionKey
+}
+
+// compactable reports whether a send request may carry a compaction key:
+// events and topic messages can, with a key of printable characters.
+func (b *MessageBroker) compactable(req *models.SendMessageRequest, channel string) bool {
+	if _, topic := b.channels.TopicName(channel); !topic && req.Type != models.MessageTypeEvent {
+		return false
+	}
+	return len(req.CompactionKey) <= MaxCompactionKeyLength &&
+		strings.IndexFunc(req.CompactionKey, func(r rune) bool { return !unicode.IsPrint(r) }) < 0
+}
+
+// storeTopicState records a message as the latest for its compaction key on
+// a topic. Topic state expires with history, a day after the last update.
+func (b *MessageBroker) storeTopicState(ctx context.Context, topic string, msg *models.Message) error {
+	data, err := encodeHistoryEntry(msg, b.compressAt)
+	if err != nil {
+		return err
+	}
+
+	key := topicStatePrefix + topic
+	_, err = b.redisStd.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, msg.CompactionKey, data)
+		pipe.Expire(ctx, key, messageHistoryTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store topic state: %w", err)
+	}
+	return nil
+}
+
+// CompactedTopic returns the latest message sent to a topic for each
+// compaction key, oldest first. Messages whose TTL has elapsed are left out,
+// as they no longer describe the current state.
+func (b *MessageBroker) CompactedTopic(ctx context.Context, topic string) ([]models.Message, error) {
+	if _, err := b.channels.Topic(topic); err != nil {
+		return nil, err
+	}
+
+	entries, err := b.redisStd.HGetAll(ctx, topicStatePrefix+topic).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get topic state: %w", err)
+	}
+
+	now := time.Now()
+	messages := make([]models.Message, 0, len(entries))
+	for _, entry := range entries {
+		var msg models.Message
+		if err := decodeHistoryEntry([]byte(entry), &msg); err != nil || msg.Expired(now) {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	slices.SortFunc(messages, func(a, b models.Message) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return messages, nil
+}
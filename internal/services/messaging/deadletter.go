@@ -0,0 +1,43 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/models"
+)
+
+// deadLetterPrefix keys each agent's dead-letter queue: inbox messages that
+// were pulled MESSAGE_MAX_DELIVERIES times without being acknowledged, oldest
+// first.
+const deadLetterPrefix = "agent:deadletter:"
+
+// DeadLetters returns up to limit of an agent's dead-lettered messages,
+// oldest first, after skipping offset, and how many there are in all.
+func (b *MessageBroker) DeadLetters(ctx context.Context, agentID string, offset, limit int) ([]models.Message, int, error) {
+	key := deadLetterPrefix + agentID
+
+	var entries *redis.StringSliceCmd
+	var total *redis.IntCmd
+	_, err := b.redisStd.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		entries = pipe.LRange(ctx, key, int64(offset), int64(offset+limit-1))
+		total = pipe.LLen(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+
+	messages := make([]models.Message, 0, len(entries.Val()))
+	for _, entry := range entries.Val() {
+		var msg models.Message
+		if err := json.Unmarshal([]byte(entry), &msg); err != nil {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, int(total.Val()), nil
+}
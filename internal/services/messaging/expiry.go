@@ -0,0 +1,183 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/metrics"
+	"agent-comm-hub/internal/models"
+)
+
+const (
+	// expiryPendingKey is a sorted set of the IDs of queued messages whose
+	// sender asked to hear if they expire undelivered, scored by expiry time
+	// in milliseconds.
+	expiryPendingKey = "agent:expiry:pending"
+	// expiryDataKey maps those message IDs to their queue entries.
+	expiryDataKey = "agent:expiry:data"
+
+	expirySweepBatch = 100
+)
+
+// trackExpiry starts tracking a queued message whose sender asked to be
+// notified if it expires undelivered. Messages are only tracked while the
+// expiry sweeper runs, which is what stops tracking those that expire.
+func (b *MessageBroker) trackExpiry(ctx context.Context, msg *models.Message, data []byte) error {
+	_, err := b.redisStd.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, expiryPendingKey, redis.Z{Score: float64(msg.ExpiresAt().UnixMilli()), Member: msg.ID})
+		pipe.HSet(ctx, expiryDataKey, msg.ID, data)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch message expiry: %w", err)
+	}
+	return nil
+}
+
+// delivered stops tracking the expiry of messages handed to their recipient
+// for good.
+func (b *MessageBroker) delivered(ctx context.Context, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	_, err := b.redisStd.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, expiryPendingKey, members...)
+		pipe.HDel(ctx, expiryDataKey, ids...)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: failed to stop watching expiry of delivered messages: %v", err)
+	}
+}
+
+// expired drops an expired message from its recipient's queue and, if its
+// sender asked, notifies the sender. Only the caller that stops tracking the
+// message notifies, so each sender hears once even with several hub
+// instances sweeping. It reports whether the message was still queued.
+func (b *MessageBroker) expired(ctx context.Context, msg *models.Message, entry string) (bool, error) {
+	if !msg.NotifyExpiry {
+		return false, nil
+	}
+
+	removed, err := b.redisStd.ZRem(ctx, expiryPendingKey, msg.ID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim expired message: %w", err)
+	}
+	if removed == 0 {
+		return false, nil
+	}
+
+	var dropped *redis.IntCmd
+	_, err = b.redisStd.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, expiryDataKey, msg.ID)
+		dropped = pipe.LRem(ctx, messageQueuePrefix+msg.ToAgent, 1, entry)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to drop expired message: %w", err)
+	}
+	return dropped.Val() > 0, b.notifyExpired(ctx, msg)
+}
+
+// notifyExpired sends a message's sender an expired notice, from the agent
+// it failed to reach. A sender with no subscriber gets the notice queued,
+// when store-and-forward is on.
+func (b *MessageBroker) notifyExpired(ctx context.Context, msg *models.Message) error {
+	notice := &models.Message{
+		ID:            b.ids.NewID(),
+		FromAgent:     msg.ToAgent,
+		ToAgent:       msg.FromAgent,
+		Channel:       b.channels.Direct(msg.FromAgent),
+		Type:          models.MessageTypeExpired,
+		CorrelationID: msg.CorrelationID,
+		Timestamp:     models.Now(),
+		Payload: models.ExpiryNotice{
+			MessageID:     msg.ID,
+			CorrelationID: msg.CorrelationID,
+			ToAgent:       msg.ToAgent,
+			ExpiredAt:     msg.ExpiresAt().UTC(),
+		},
+	}
+	data, err := json.Marshal(notice)
+	if err != nil {
+		return fmt.Errorf("failed to marshal expiry notice: %w", err)
+	}
+
+	delivered, err := b.transport.Publish(ctx, notice.Channel, data)
+	if err != nil {
+		return fmt.Errorf("failed to publish expiry notice: %w", err)
+	}
+	if delivered == 0 && b.storeForward {
+		return b.enqueue(ctx, msg.FromAgent, data)
+	}
+	return nil
+}
+
+// RunExpirySweeper drops queued messages that expire undelivered and
+// notifies their senders, for messages sent with notify_expiry, each
+// interval until ctx is cancelled.
+func (b *MessageBroker) RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.sweepExpired(ctx); err != nil {
+				log.Printf("Warning: expiry sweep failed: %v", err)
+			}
+		}
+	}
+}
+
+// sweepExpired handles the messages whose expiry has passed, a batch at a
+// time.
+func (b *MessageBroker) sweepExpired(ctx context.Context) error {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	for {
+		ids, err := b.redisStd.ZRangeByScore(ctx, expiryPendingKey, &redis.ZRangeBy{Min: "-inf", Max: now, Count: expirySweepBatch}).Result()
+		if err != nil {
+			return fmt.Errorf("failed to find expired messages: %w", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		entries, err := b.redisStd.HMGet(ctx, expiryDataKey, ids...).Result()
+		if err != nil {
+			return fmt.Errorf("failed to get expired messages: %w", err)
+		}
+
+		for i, id := range ids {
+			entry, _ := entries[i].(string)
+			var msg models.Message
+			if err := json.Unmarshal([]byte(entry), &msg); err != nil {
+				// Nothing to notify about, stop tracking it
+				b.redisStd.ZRem(ctx, expiryPendingKey, id)
+				continue
+			}
+			dropped, err := b.expired(ctx, &msg, entry)
+			if err != nil {
+				return err
+			}
+			if dropped {
+				metrics.MessageExpired(metrics.ExpiredInQueue)
+			}
+		}
+		if len(ids) < expirySweepBatch {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/metrics"
+	"agent-comm-hub/internal/models"
+)
+
+// In-flight messages have been pulled from an agent's inbox with a
+// visibility timeout but not yet acknowledged. Each agent's in-flight
+// messages are a sorted set of message IDs scored by when they become
+// visible again, with their encodings and delivery counts in hashes.
+const (
+	inflightPrefix      = "agent:inflight:"
+	inflightDataSuffix  = ":data"
+	inflightCountSuffix = ":deliveries"
+	// inflightIndexKey is the set of agents that may have in-flight
+	// messages, walked by the redelivery sweeper.
+	inflightIndexKey = "agent:inflight:agents"
+)
+
+// pullInflight pops up to ARGV[1] messages from the queue at KEYS[1] and
+// holds them in flight until ARGV[2] milliseconds from now, or the message's
+// own visibility_timeout, using the Redis clock so instances agree. Messages
+// with neither are not held. It returns the messages, their delivery counts
+// and the remaining queue length.
+var pullInflight = redis.NewScript(`
+local entries = redis.call('LPOP', KEYS[1], ARGV[1])
+if not entries then
+	return {{}, {}, redis.call('LLEN', KEYS[1])}
+end
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local counts = {}
+local held = false
+for i, entry in ipairs(entries) do
+	counts[i] = 0
+	local ok, msg = pcall(cjson.decode, entry)
+	if ok and type(msg) == 'table' and type(msg.id) == 'string' then
+		local visibility = tonumber(ARGV[2])
+		local own = tonumber(msg.visibility_timeout)
+		if own and own > 0 then
+			visibility = own * 1000
+		end
+		if visibility > 0 then
+			redis.call('ZADD', KEYS[2], now + visibility, msg.id)
+			redis.call('HSET', KEYS[3], msg.id, entry)
+			counts[i] = redis.call('HINCRBY', KEYS[4], msg.id, 1)
+			held = true
+		end
+	end
+end
+if held then
+	for i = 2, 4 do
+		redis.call('PEXPIRE', KEYS[i], ARGV[3])
+	end
+	redis.call('SADD', KEYS[5], ARGV[4])
+end
+return {entries, counts, redis.call('LLEN', KEYS[1])}
+`)
+
+// requeueExpired moves in-flight messages whose visibility timeout has passed
+// back to the front of the queue at KEYS[1], oldest first, and removes the
+// agent ARGV[1] from the sweeper index once nothing is in flight. Messages
+// already delivered ARGV[2] times, when positive, are moved to the end of the
+// dead-letter queue at KEYS[6] instead, which is trimmed to ARGV[3] entries
+// and expires ARGV[4] milliseconds after its last message. It returns the
+// number of messages requeued and the number dead-lettered.
+var requeueExpired = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local ids = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)
+local maxDeliveries = tonumber(ARGV[2])
+local dead = {}
+for i = #ids, 1, -1 do
+	local entry = redis.call('HGET', KEYS[3], ids[i])
+	if entry then
+		local count = tonumber(redis.call('HGET', KEYS[5], ids[i])) or 0
+		if maxDeliveries > 0 and count >= maxDeliveries then
+			table.insert(dead, 1, entry)
+			redis.call('HDEL', KEYS[5], ids[i])
+		else
+			redis.call('LPUSH', KEYS[1], entry)
+		end
+	end
+	redis.call('ZREM', KEYS[2], ids[i])
+	redis.call('HDEL', KEYS[3], ids[i])
+end
+if #dead > 0 then
+	for _, entry in ipairs(dead) do
+		redis.call('RPUSH', KEYS[6], entry)
+	end
+	if tonumber(ARGV[3]) > 0 then
+		redis.call('LTRIM', KEYS[6], -tonumber(ARGV[3]), -1)
+	end
+	redis.call('PEXPIRE', KEYS[6], ARGV[4])
+end
+if redis.call('ZCARD', KEYS[2]) == 0 then
+	redis.call('SREM', KEYS[4], ARGV[1])
+end
+return {#ids - #dead, #dead}
+`)
+
+// inflightKeys returns the in-flight set, data and delivery count keys of an
+// agent.
+func inflightKeys(agentID string) (set, data, counts string) {
+	set = inflightPrefix + agentID
+	return set, set + inflightDataSuffix, set + inflightCountSuffix
+}
+
+// pullWithVisibility pops up to limit messages from an agent's queue and holds
+// them in flight for visibility, or each message's own visibility timeout,
+// after which they are requeued unless acknowledged. Messages with neither
+// are removed for good. Messages whose TTL has elapsed are acknowledged and
+// not returned.
+func (b *MessageBroker) pullWithVisibility(ctx context.Context, agentID string, limit int, visibility time.Duration) ([]models.Message, int64, error) {
+	// Make expired messages visible again before pulling
+	if _, err := b.RequeueExpired(ctx, agentID); err != nil {
+		return nil, 0, err
+	}
+
+	set, data, counts := inflightKeys(agentID)
+	keys := []string{messageQueuePrefix + agentID, set, data, counts, inflightIndexKey}
+	result, err := pullInflight.Run(ctx, b.redisStd, keys, limit, visibility.Milliseconds(), messageHistoryTTL.Milliseconds(), agentID).Slice()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to pull message queue: %w", err)
+	}
+	entries, _ := result[0].([]interface{})
+	deliveries, _ := result[1].([]interface{})
+	remaining, _ := result[2].(int64)
+
+	now := time.Now()
+	messages := make([]models.Message, 0, len(entries))
+	var expired, delivered []string
+	for i, entry := range entries {
+		s, _ := entry.(string)
+		var msg models.Message
+		if err := json.Unmarshal([]byte(s), &msg); err != nil {
+			continue
+		}
+		if msg.Expired(now) {
+			metrics.MessageExpired(metrics.ExpiredInQueue)
+			expired = append(expired, msg.ID)
+			if _, err := b.expired(ctx, &msg, s); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+			continue
+		}
+		if i < len(deliveries) {
+			count, _ := deliveries[i].(int64)
+			msg.DeliveryCount = int(count)
+		}
+		// Messages held in flight are delivered once acknowledged
+		if msg.DeliveryCount == 0 && msg.NotifyExpiry {
+			delivered = append(delivered, msg.ID)
+		}
+		messages = append(messages, msg)
+	}
+
+	b.delivered(ctx, delivered)
+	if len(expired) > 0 {
+		if _, err := b.Ack(ctx, agentID, expired); err != nil {
+			log.Printf("Warning: failed to discard expired in-flight messages for %s: %v", agentID, err)
+		}
+	}
+	return messages, remaining, nil
+}
+
+// ackInflight removes the messages ARGV from the in-flight set KEYS[1] and
+// its data and delivery count hashes KEYS[2] and KEYS[3]. Messages that were
+// in flight have been delivered, so their expiry is no longer tracked in
+// KEYS[4] and KEYS[5]. It returns the number of messages that were in flight.
+var ackInflight = redis.NewScript(`
+local acked = 0
+for _, id in ipairs(ARGV) do
+	if redis.call('ZREM', KEYS[1], id) == 1 then
+		acked = acked + 1
+		redis.call('ZREM', KEYS[4], id)
+		redis.call('HDEL', KEYS[5], id)
+	end
+	redis.call('HDEL', KEYS[2], id)
+	redis.call('HDEL', KEYS[3], id)
+end
+return acked
+`)
+
+// Ack acknowledges in-flight messages, so they are not redelivered, and
+// returns how many were in flight. IDs of messages that are not in flight,
+// because they were already acknowledged or have been requeued, are ignored.
+func (b *MessageBroker) Ack(ctx context.Context, agentID string, messageIDs []string) (int, error) {
+	if len(messageIDs) == 0 {
+		return 0, nil
+	}
+
+	set, data, counts := inflightKeys(agentID)
+	members := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		members[i] = id
+	}
+
+	keys := []string{set, data, counts, expiryPendingKey, expiryDataKey}
+	acked, err := ackInflight.Run(ctx, b.redisStd, keys, members...).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to acknowledge messages: %w", err)
+	}
+	return acked, nil
+}
+
+// RequeueExpired makes an agent's in-flight messages whose visibility timeout
+// has passed visible again at the front of its queue, and returns how many
+// were requeued. Messages that have reached the maximum number of deliveries
+// are moved to the agent's dead-letter queue instead.
+func (b *MessageBroker) RequeueExpired(ctx context.Context, agentID string) (int, error) {
+	set, data, counts := inflightKeys(agentID)
+	keys := []string{messageQueuePrefix + agentID, set, data, inflightIndexKey, counts, deadLetterPrefix + agentID}
+	result, err := requeueExpired.Run(ctx, b.redisStd, keys, agentID, b.maxDeliveries, b.queueMax, messageHistoryTTL.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue expired messages: %w", err)
+	}
+	n, dead := int(result[0]), int(result[1])
+	if n > 0 {
+		metrics.MessagesRedelivered(n)
+	}
+	if dead > 0 {
+		metrics.MessagesDeadLettered(dead)
+		log.Printf("Moved %d messages for agent %s to its dead-letter queue", dead, agentID)
+	}
+	return n, nil
+}
+
+// RunRedeliverySweeper requeues expired in-flight messages of every agent
+// each interval until ctx is cancelled, so unacknowledged messages are
+// redelivered even to agents that stopped pulling.
+func (b *MessageBroker) RunRedeliverySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			agentIDs, err := b.redisStd.SMembers(ctx, inflightIndexKey).Result()
+			if err != nil {
+				log.Printf("Warning: redelivery sweep failed: %v", err)
+				continue
+			}
+			for _, agentID := range agentIDs {
+				if _, err := b.RequeueExpired(ctx, agentID); err != nil {
+					log.Printf("Warning: redelivery sweep for agent %s failed: %v", agentID, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package messaging
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/metrics"
+	"agent-comm-hub/internal/models"
+)
+
+const (
+	statsLatency      = "latency"
+	latencySamplesMax = 1000 // Recent delivery latencies kept per agent
+)
+
+// RecordDelivery records how long a message took from being sent to being
+// delivered to an agent, both in the hub-wide metrics and in the agent's
+// recent samples reported by GetStats. Failures are logged.
+func (b *MessageBroker) RecordDelivery(ctx context.Context, agentID string, latency time.Duration) {
+	metrics.ObserveDeliveryLatency(latency)
+
+	key := statsKeyPrefix + agentID + ":" + statsLatency
+	ms := strconv.FormatFloat(float64(latency.Microseconds())/1000, 'f', 3, 64)
+	_, err := b.redisStd.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, ms)
+		pipe.LTrim(ctx, key, 0, latencySamplesMax-1)
+		pipe.Expire(ctx, key, messageHistoryTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: failed to record delivery latency for %s: %v", agentID, err)
+	}
+}
+
+// latencyStats summarizes latency samples in milliseconds, or returns nil if
+// there are none.
+func latencyStats(samples []string) *models.LatencyStats {
+	values := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	slices.Sort(values)
+
+	percentile := func(p float64) float64 {
+		return values[int(p*float64(len(values)-1))]
+	}
+	return &models.LatencyStats{
+		Samples: len(values),
+		P50MS:   percentile(0.50),
+		P90MS:   percentile(0.90),
+		P99MS:   percentile(0.99),
+		MaxMS:   values[len(values)-1],
+	}
+}
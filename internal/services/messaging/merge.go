@@ -0,0 +1,57 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/models"
+)
+
+// MaxMergedAgents caps the agents whose histories one request can merge.
+const MaxMergedAgents = 20
+
+// GetMergedHistory merges the retained histories of several agents into one
+// chronological timeline, listing a message that appears in more than one
+// history once. Like GetMessageHistory it returns up to limit messages,
+// skipping the offset most recent, and the number of distinct messages.
+func (b *MessageBroker) GetMergedHistory(ctx context.Context, agentIDs []string, offset, limit int) ([]models.Message, int, error) {
+	if limit <= 0 || limit > b.historyMax {
+		limit = b.historyMax
+	}
+
+	cmds := make([]*redis.StringSliceCmd, len(agentIDs))
+	_, err := b.redisStd.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, agentID := range agentIDs {
+			cmds[i] = pipe.LRange(ctx, messageHistoryPrefix+agentID, 0, -1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get message history: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var merged []models.Message
+	for _, cmd := range cmds {
+		for _, entry := range cmd.Val() {
+			var msg models.Message
+			if err := decodeHistoryEntry([]byte(entry), &msg); err != nil || seen[msg.ID] {
+				continue
+			}
+			seen[msg.ID] = true
+			merged = append(merged, msg)
+		}
+	}
+	slices.SortStableFunc(merged, func(a, b models.Message) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	// Page back from the most recent, as for a single agent's history
+	total := len(merged)
+	end := max(total-offset, 0)
+	start := max(end-limit, 0)
+	return append([]models.Message{}, merged[start:end]...), total, nil
+}
@@ -6,151 +6,470 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 
+	"agent-comm-hub/internal/config"
+	"agent-comm-hub/internal/idgen"
 	"agent-comm-hub/internal/models"
 )
 
 const (
-	directMessageChannelPrefix = "agent:message:"
-	broadcastChannel           = "agent:broadcast"
-	messageHistoryPrefix       = "agent:history:"
-	messageHistoryTTL          = 24 * time.Hour // Messages kept for 24 hours
-	messageHistoryMax          = 100            // Max messages per agent
+	messageHistoryPrefix = "agent:history:"
+	messageHistoryTTL    = 24 * time.Hour // Messages kept for 24 hours
 )
 
 // Errors for message broker.
 var (
-	ErrInvalidRecipient = errors.New("invalid recipient")
+	ErrInvalidRecipient   = errors.New("invalid recipient")
+	ErrInvalidMessageType = errors.New("message type not allowed")
+	ErrInvalidPattern     = errors.New("invalid channel pattern")
+	ErrMessageRejected    = errors.New("message rejected")
+	ErrSelfMessage        = errors.New("message addressed to its sender")
+	ErrNoRecipient        = errors.New("no recipient or route for message type")
+	ErrInvalidCategory    = errors.New("invalid broadcast category")
+	ErrInvalidCompaction  = errors.New("invalid compaction key")
 )
 
 // MessageBroker handles message passing between agents.
 type MessageBroker struct {
-	redisPubSub *redis.Client
-	redisStd    *redis.Client
+	transport     Transport
+	channels      *ChannelNames
+	redisStd      *redis.Client
+	ids           idgen.IDGenerator
+	allowedTypes  map[models.MessageType]bool
+	compressAt    int
+	defaultTTL    int
+	storeForward  bool
+	watchExpiry   bool // Track notify_expiry messages for the expiry sweeper
+	persist       bool // Record messages in history
+	queueMax      int64
+	visibility    time.Duration // How long pulled inbox messages stay in flight, 0 removes them
+	maxDeliveries int           // Deliveries of an unacknowledged inbox message before it is dead-lettered, 0 = unlimited
+	historyMax    int           // Messages retained per agent
+	peerWindow    time.Duration // How long direct message peers are remembered, 0 disables
+	transforms    []Transform
+	routes        TypeRoutes // Recipients of messages sent without one, by type
+	webhooks      WebhookDispatcher
+	retention     RetentionSource // Per-agent history retention overrides
+}
+
+// Receipt describes what happened to a message at publish time.
+type Receipt struct {
+	DeliveredTo int64 // Subscribers connected when the message was published
+	Queued      bool  // Held for store-and-forward delivery
+	Webhook     bool  // Queued for delivery to the recipient's webhook
 }
 
 // NewMessageBroker creates a new message broker.
-func NewMessageBroker(redisPubSub, redisStd *redis.Client) *MessageBroker {
-	return &MessageBroker{
-		redisPubSub: redisPubSub,
-		redisStd:    redisStd,
+// Messages are published through the transport on channels named by
+// channels; history, queues and stats are kept in Redis.
+func NewMessageBroker(transport Transport, channels *ChannelNames, redisStd *redis.Client, ids idgen.IDGenerator, cfg *config.MessagingConfig) *MessageBroker {
+	var allowedTypes map[models.MessageType]bool
+	if len(cfg.AllowedTypes) > 0 {
+		allowedTypes = make(map[models.MessageType]bool, len(cfg.AllowedTypes))
+		for _, t := range cfg.AllowedTypes {
+			allowedTypes[models.MessageType(t)] = true
+		}
+	}
+
+	b := &MessageBroker{
+		transport:     transport,
+		channels:      channels,
+		redisStd:      redisStd,
+		ids:           ids,
+		allowedTypes:  allowedTypes,
+		compressAt:    cfg.CompressionThreshold,
+		defaultTTL:    int(cfg.DefaultTTL.Seconds()),
+		storeForward:  cfg.StoreAndForward,
+		watchExpiry:   cfg.ExpirySweepInterval > 0,
+		persist:       cfg.Persist,
+		queueMax:      int64(cfg.QueueMax),
+		visibility:    cfg.VisibilityTimeout,
+		maxDeliveries: cfg.MaxDeliveries,
+		historyMax:    cfg.MaxHistoryLimit,
+		peerWindow:    peerWindow(cfg),
+	}
+	if len(cfg.AllowFields) > 0 || len(cfg.RedactFields) > 0 {
+		b.AddTransform(RedactFields(cfg.AllowFields, cfg.RedactFields))
+	}
+	return b
+}
+
+// SendMessage sends a message to an agent. The receipt reports how many
+// subscribers received it and whether it was queued for later delivery.
+func (b *MessageBroker) SendMessage(ctx context.Context, fromAgentID string, req *models.SendMessageRequest) (*models.Message, *Receipt, error) {
+	msg, data, err := b.prepareMessage(fromAgentID, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Publish message via Pub/Sub, retrying transient failures
+	receipt := &Receipt{}
+	err = withRetry(ctx, func() error {
+		delivered, err := b.transport.Publish(ctx, msg.Channel, data)
+		receipt.DeliveredTo = delivered
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	b.afterPublish(ctx, msg, data, receipt, b.persists(req))
+
+	return msg, receipt, nil
+}
+
+// PreviewMessage validates a send request and reports what SendMessage would
+// do with it, without publishing or storing anything. The receipt counts the
+// subscribers currently connected to the message's channel.
+func (b *MessageBroker) PreviewMessage(ctx context.Context, fromAgentID string, req *models.SendMessageRequest) (*models.Message, *Receipt, error) {
+	msg, _, err := b.prepareMessage(fromAgentID, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subscribers, err := b.transport.Subscribers(ctx, msg.Channel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to count subscribers: %w", err)
+	}
+	receipt := &Receipt{
+		DeliveredTo: subscribers,
+		Queued:      b.storeForward && subscribers == 0 && b.channels.IsDirect(msg.Channel),
+	}
+	return msg, receipt, nil
+}
+
+// SendBatch sends several messages from one agent, publishing them in a
+// single pipeline. Every request is validated before any is published, so an
+// invalid request fails the whole batch; publish failures are reported per
+// message.
+func (b *MessageBroker) SendBatch(ctx context.Context, fromAgentID string, reqs []models.SendMessageRequest) ([]models.BatchSendResult, error) {
+	messages := make([]*models.Message, len(reqs))
+	encoded := make([][]byte, len(reqs))
+	for i := range reqs {
+		msg, data, err := b.prepareMessage(fromAgentID, &reqs[i])
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		messages[i], encoded[i] = msg, data
+	}
+
+	pubs := make([]Publication, len(messages))
+	for i, msg := range messages {
+		pubs[i] = Publication{Channel: msg.Channel, Data: encoded[i]}
 	}
+	published := b.transport.PublishBatch(ctx, pubs)
+
+	results := make([]models.BatchSendResult, len(messages))
+	for i, msg := range messages {
+		results[i] = models.BatchSendResult{
+			Index:     i,
+			MessageID: msg.ID,
+			Channel:   msg.Channel,
+		}
+		if err := published[i].Err; err != nil {
+			results[i].Error = fmt.Sprintf("failed to publish message: %v", err)
+			continue
+		}
+
+		receipt := &Receipt{DeliveredTo: published[i].Delivered}
+		b.afterPublish(ctx, msg, encoded[i], receipt, b.persists(&reqs[i]))
+		results[i].Timestamp = msg.Timestamp
+		results[i].DeliveredTo = max(receipt.DeliveredTo, 0)
+		results[i].DeliveryUnknown = receipt.DeliveredTo == DeliveryUnknown
+		results[i].Queued = receipt.Queued
+		results[i].Webhook = receipt.Webhook
+	}
+
+	return results, nil
 }
 
-// SendMessage sends a message to an agent.
-func (b *MessageBroker) SendMessage(ctx context.Context, fromAgentID string, req *models.SendMessageRequest) (*models.Message, error) {
+// prepareMessage validates a send request and builds the message and its
+// encoding.
+func (b *MessageBroker) prepareMessage(fromAgentID string, req *models.SendMessageRequest) (*models.Message, []byte, error) {
+	if b.allowedTypes != nil && !b.allowedTypes[req.Type] {
+		return nil, nil, ErrInvalidMessageType
+	}
+	recipient, err := b.recipient(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if recipient == fromAgentID && !req.AllowSelf {
+		return nil, nil, ErrSelfMessage
+	}
+
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = b.defaultTTL
+	}
+
+	channel, err := b.channels.For(recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.Category != "" && (channel != b.channels.Broadcast() || !validCategory(req.Category)) {
+		return nil, nil, ErrInvalidCategory
+	}
+	if req.CompactionKey != "" && !b.compactable(req, channel) {
+		return nil, nil, ErrInvalidCompaction
+	}
+
 	// Create message
 	msg := &models.Message{
-		ID:            uuid.New().String(),
+		ID:            b.ids.NewID(),
 		FromAgent:     fromAgentID,
-		ToAgent:       req.ToAgent,
+		ToAgent:       recipient,
+		Channel:       channel,
 		Type:          req.Type,
 		Payload:       req.Payload,
 		CorrelationID: req.CorrelationID,
-		Timestamp:     time.Now(),
-		TTL:           req.TTL,
+		Timestamp:     models.Now(),
+		TTL:           ttl,
+		Category:      req.Category,
+		CompactionKey: req.CompactionKey,
+		NotifyExpiry:  req.NotifyExpiry && ttl > 0,
+
+		VisibilityTimeout: req.VisibilityTimeout,
+	}
+
+	// Apply the transform pipeline
+	for _, transform := range b.transforms {
+		if err := transform(msg); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrMessageRejected, err)
+		}
 	}
 
 	// Serialize message
 	data, err := json.Marshal(msg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal message: %w", err)
+		return nil, nil, fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	// Determine channel
-	channel := directMessageChannelPrefix + req.ToAgent
-	if req.ToAgent == "broadcast" {
-		channel = broadcastChannel
+	return msg, data, nil
+}
+
+// persists reports whether a message sent by req is recorded in history:
+// only when persistence is enabled and the request does not opt out.
+func (b *MessageBroker) persists(req *models.SendMessageRequest) bool {
+	return b.persist && (req.Persist == nil || *req.Persist)
+}
+
+// afterPublish records stats and, if persist is set, history for a published
+// message, hands direct messages to the recipient's webhook, and queues direct
+// messages whose recipient has no subscriber when store-and-forward is on,
+// unless the recipient takes webhook delivery only.
+func (b *MessageBroker) afterPublish(ctx context.Context, msg *models.Message, data []byte, receipt *Receipt, persist bool) {
+	direct := b.channels.IsDirect(msg.Channel)
+	b.recordStats(ctx, msg, direct)
+	pubsub := true
+	if direct {
+		b.recordPeers(ctx, msg)
+		pubsub = b.dispatchWebhook(ctx, msg, data, receipt)
+	}
+
+	// Hold direct messages nobody received until the recipient connects
+	if direct && pubsub && b.storeForward && !b.subscribed(ctx, msg.Channel, receipt.DeliveredTo) {
+		if err := b.enqueue(ctx, msg.ToAgent, data); err != nil {
+			log.Printf("Warning: failed to queue message for %s: %v", msg.ToAgent, err)
+		} else {
+			receipt.Queued = true
+			if msg.NotifyExpiry && b.watchExpiry {
+				if err := b.trackExpiry(ctx, msg, data); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+				}
+			}
+		}
 	}
 
-	// Publish message via Pub/Sub
-	if err := b.redisPubSub.Publish(ctx, channel, data).Err(); err != nil {
-		return nil, fmt.Errorf("failed to publish message: %w", err)
+	if !persist {
+		return
+	}
+
+	// Keep the latest state of each key on a topic
+	if msg.CompactionKey != "" {
+		if topic, ok := b.channels.TopicName(msg.Channel); ok {
+			if err := b.storeTopicState(ctx, topic, msg); err != nil {
+				fmt.Printf("Warning: failed to store compacted topic state: %v\n", err)
+			}
+		}
 	}
 
 	// Store message history for sender
-	if err := b.storeMessageHistory(ctx, fromAgentID, msg); err != nil {
+	if err := b.storeMessageHistory(ctx, msg.FromAgent, msg); err != nil {
 		// Log error but don't fail the message send
 		fmt.Printf("Warning: failed to store sender message history: %v\n", err)
 	}
 
-	// Store message history for receiver (if not broadcast)
-	if req.ToAgent != "broadcast" {
-		if err := b.storeMessageHistory(ctx, req.ToAgent, msg); err != nil {
+	// Store message history for receiver (direct messages only)
+	if direct {
+		if err := b.storeMessageHistory(ctx, msg.ToAgent, msg); err != nil {
 			fmt.Printf("Warning: failed to store receiver message history: %v\n", err)
 		}
 	}
-
-	return msg, nil
 }
 
-// GetMessageHistory retrieves message history for an agent.
-func (b *MessageBroker) GetMessageHistory(ctx context.Context, agentID string, limit int) ([]models.Message, error) {
-	if limit <= 0 || limit > messageHistoryMax {
-		limit = messageHistoryMax
+// GetMessageHistory retrieves up to limit messages from an agent's history,
+// skipping the offset most recent, in chronological order. It also returns
+// the number of messages in the history.
+func (b *MessageBroker) GetMessageHistory(ctx context.Context, agentID string, offset, limit int) ([]models.Message, int, error) {
+	if limit <= 0 || limit > b.historyMax {
+		limit = b.historyMax
 	}
 
 	key := messageHistoryPrefix + agentID
 
 	// Get messages from list (newest first)
-	messages, err := b.redisStd.LRange(ctx, key, 0, int64(limit-1)).Result()
+	var entries *redis.StringSliceCmd
+	var total *redis.IntCmd
+	_, err := b.redisStd.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		entries = pipe.LRange(ctx, key, int64(offset), int64(offset+limit-1))
+		total = pipe.LLen(ctx, key)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get message history: %w", err)
+		return nil, 0, fmt.Errorf("failed to get message history: %w", err)
 	}
+	messages := entries.Val()
 
 	if len(messages) == 0 {
-		return []models.Message{}, nil
+		return []models.Message{}, int(total.Val()), nil
 	}
 
 	// Parse messages (reverse to get chronological order)
 	result := make([]models.Message, 0, len(messages))
 	for i := len(messages) - 1; i >= 0; i-- {
 		var msg models.Message
-		if err := json.Unmarshal([]byte(messages[i]), &msg); err != nil {
+		if err := decodeHistoryEntry([]byte(messages[i]), &msg); err != nil {
 			continue
 		}
 		result = append(result, msg)
 	}
 
-	return result, nil
+	return result, int(total.Val()), nil
+}
+
+// retainedHistory returns every message retained in an agent's history, in
+// chronological order.
+func (b *MessageBroker) retainedHistory(ctx context.Context, agentID string) ([]models.Message, error) {
+	entries, err := b.redisStd.LRange(ctx, messageHistoryPrefix+agentID, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message history: %w", err)
+	}
+
+	messages := make([]models.Message, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		var msg models.Message
+		if err := decodeHistoryEntry([]byte(entries[i]), &msg); err != nil {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
 }
 
 // Subscribe subscribes to messages for an agent.
-func (b *MessageBroker) Subscribe(ctx context.Context, agentID string) *redis.PubSub {
-	channel := directMessageChannelPrefix + agentID
-	return b.redisPubSub.Subscribe(ctx, channel)
+func (b *MessageBroker) Subscribe(ctx context.Context, agentID string) (Subscription, error) {
+	return b.transport.Subscribe(ctx, []string{b.channels.Direct(agentID)}, nil)
 }
 
-// SubscribeToBroadcast subscribes to broadcast messages.
-func (b *MessageBroker) SubscribeToBroadcast(ctx context.Context) *redis.PubSub {
-	return b.redisPubSub.Subscribe(ctx, broadcastChannel)
+// subscribed reports whether a direct channel had a subscriber when a message
+// was published to it, given the number of subscribers that received it.
+// That count includes pattern subscribers such as monitoring streams, so a
+// non-zero count is confirmed against the channel's own subscribers. Errors
+// count as no subscriber, preferring a duplicate to a lost message.
+func (b *MessageBroker) subscribed(ctx context.Context, channel string, delivered int64) bool {
+	if delivered == 0 {
+		return false
+	}
+	subscribers, err := b.transport.Subscribers(ctx, channel)
+	if err != nil {
+		log.Printf("Warning: failed to count subscribers of %s: %v", channel, err)
+		return false
+	}
+	return subscribers != 0
 }
 
-func (b *MessageBroker) storeMessageHistory(ctx context.Context, agentID string, msg *models.Message) error {
-	key := messageHistoryPrefix + agentID
+// SubscribeAgent subscribes to direct and broadcast messages for an agent,
+// plus any of the given topics and groups, over a single connection.
+func (b *MessageBroker) SubscribeAgent(ctx context.Context, agentID string, topics, groups []string) (Subscription, error) {
+	channels := []string{b.channels.Direct(agentID), b.channels.Broadcast()}
+	for _, topic := range topics {
+		channel, err := b.channels.Topic(topic)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	for _, group := range groups {
+		channel, err := b.channels.Group(group)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+	return b.transport.Subscribe(ctx, channels, nil)
+}
+
+// SubscribeAll subscribes to every direct and broadcast message in the hub.
+func (b *MessageBroker) SubscribeAll(ctx context.Context) (Subscription, error) {
+	return b.transport.Subscribe(ctx, []string{b.channels.Broadcast()}, b.channels.patterns())
+}
+
+// Channels returns the broker's channel naming.
+func (b *MessageBroker) Channels() *ChannelNames {
+	return b.channels
+}
+
+// PSubscribe subscribes to every channel matching pattern; see
+// ChannelNames.ValidatePattern. Messages are delivered with their concrete
+// channel.
+func (b *MessageBroker) PSubscribe(ctx context.Context, pattern string) (Subscription, error) {
+	if _, err := b.channels.ValidatePattern(pattern); err != nil {
+		return nil, err
 	}
+	return b.transport.Subscribe(ctx, nil, []string{pattern})
+}
+
+// SubscribeToBroadcast subscribes to broadcast messages.
+func (b *MessageBroker) SubscribeToBroadcast(ctx context.Context) (Subscription, error) {
+	return b.transport.Subscribe(ctx, []string{b.channels.Broadcast()}, nil)
+}
 
-	// Add to list (LPUSH for newest first)
-	if err := b.redisStd.LPush(ctx, key, data).Err(); err != nil {
-		return fmt.Errorf("failed to push message to history: %w", err)
+// PurgeAgent removes all messaging state held for an agent.
+func (b *MessageBroker) PurgeAgent(ctx context.Context, agentID string) error {
+	set, data, counts := inflightKeys(agentID)
+	keys := append([]string{messageHistoryPrefix + agentID, historyCompactionPrefix + agentID, messageQueuePrefix + agentID, recentPeersPrefix + agentID, broadcastCategoriesPrefix + agentID, deadLetterPrefix + agentID, set, data, counts}, statsKeys(agentID, time.Now())...)
+	if err := b.redisStd.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to delete messaging state: %w", err)
 	}
+	return nil
+}
+
+// storeMessageHistory records a message in an agent's history, keeping as
+// many messages for as long as the agent's retention allows.
+func (b *MessageBroker) storeMessageHistory(ctx context.Context, agentID string, msg *models.Message) error {
+	count, ttl := b.historyRetention(ctx, agentID)
+	return withRetry(ctx, func() error {
+		return b.appendHistory(ctx, agentID, msg, count, ttl)
+	})
+}
 
-	// Trim list to max size
-	if err := b.redisStd.LTrim(ctx, key, 0, messageHistoryMax-1).Err(); err != nil {
-		return fmt.Errorf("failed to trim message history: %w", err)
+func (b *MessageBroker) appendHistory(ctx context.Context, agentID string, msg *models.Message, count int, ttl time.Duration) error {
+	data, err := encodeHistoryEntry(msg, b.compressAt)
+	if err != nil {
+		return err
 	}
 
-	// Set TTL on the key
-	if err := b.redisStd.Expire(ctx, key, messageHistoryTTL).Err(); err != nil {
-		return fmt.Errorf("failed to set message history TTL: %w", err)
+	// Push, compact, trim and expire atomically so a retried append can't
+	// duplicate
+	keys := []string{messageHistoryPrefix + agentID, historyCompactionPrefix + agentID}
+	err = appendHistoryScript.Run(ctx, b.redisStd, keys, data, count, int(ttl.Seconds()), compactionField(msg)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store message history: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,100 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/config"
+	"agent-comm-hub/internal/models"
+)
+
+// recentPeersPrefix keys a sorted set of the agents an agent recently
+// exchanged direct messages with, scored by the last exchange time.
+const recentPeersPrefix = "agent:peers:"
+
+// peerWindow returns how long peers are remembered, or 0 when drain
+// notifications are disabled.
+func peerWindow(cfg *config.MessagingConfig) time.Duration {
+	if !cfg.DrainNotify {
+		return 0
+	}
+	return cfg.PeerWindow
+}
+
+// recordPeers notes that the sender and recipient of a direct message are
+// peers. Nothing is tracked unless drain notifications are enabled.
+func (b *MessageBroker) recordPeers(ctx context.Context, msg *models.Message) {
+	if b.peerWindow <= 0 || strings.HasPrefix(msg.FromAgent, pingSenderPrefix) {
+		return
+	}
+
+	now := time.Now()
+	cutoff := strconv.FormatInt(now.Add(-b.peerWindow).UnixMilli(), 10)
+	_, err := b.redisStd.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, pair := range [][2]string{{msg.FromAgent, msg.ToAgent}, {msg.ToAgent, msg.FromAgent}} {
+			key := recentPeersPrefix + pair[0]
+			pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: pair[1]})
+			pipe.ZRemRangeByScore(ctx, key, "-inf", "("+cutoff)
+			pipe.Expire(ctx, key, b.peerWindow)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to record message peers: %v\n", err)
+	}
+}
+
+// NotifyDraining tells every agent that exchanged direct messages with
+// agentID within the peer window that it is going offline, by publishing an
+// agent_draining message to each. Notices bypass the message type
+// allow-list and are not recorded in history or stats. It returns the peers
+// notified.
+func (b *MessageBroker) NotifyDraining(ctx context.Context, agentID string) ([]string, error) {
+	if b.peerWindow <= 0 {
+		return []string{}, nil
+	}
+
+	cutoff := strconv.FormatInt(time.Now().Add(-b.peerWindow).UnixMilli(), 10)
+	peers, err := b.redisStd.ZRangeByScore(ctx, recentPeersPrefix+agentID, &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent peers: %w", err)
+	}
+	if len(peers) == 0 {
+		return []string{}, nil
+	}
+
+	now := models.Now()
+	pubs := make([]Publication, len(peers))
+	for i, peer := range peers {
+		msg := &models.Message{
+			ID:        b.ids.NewID(),
+			FromAgent: agentID,
+			ToAgent:   peer,
+			Channel:   b.channels.Direct(peer),
+			Type:      models.MessageTypeDraining,
+			Payload:   map[string]string{"agent_id": agentID},
+			Timestamp: now,
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal drain notice: %w", err)
+		}
+		pubs[i] = Publication{Channel: msg.Channel, Data: data}
+	}
+
+	notified := make([]string, 0, len(peers))
+	for i, result := range b.transport.PublishBatch(ctx, pubs) {
+		if result.Err != nil {
+			fmt.Printf("Warning: failed to notify %s that %s is draining: %v\n", peers[i], agentID, result.Err)
+			continue
+		}
+		notified = append(notified, peers[i])
+	}
+	return notified, nil
+}
@@ -0,0 +1,84 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"agent-comm-hub/internal/models"
+)
+
+// pingSenderPrefix prefixes the synthetic sender of diagnostic pings. Agents
+// acknowledge a ping by sending any message back to its from_agent with the
+// ping's correlation_id.
+const pingSenderPrefix = "hub:ping:"
+
+// Ping sends a diagnostic ping to an agent and, if a subscriber received it,
+// waits up to wait for the agent to acknowledge it. Pings bypass the message
+// type allow-list and are not recorded in history or stats.
+func (b *MessageBroker) Ping(ctx context.Context, agentID string, wait time.Duration) (*models.PingResult, error) {
+	id := b.ids.NewID()
+	replyTo := pingSenderPrefix + id
+
+	// Subscribe for the acknowledgement before the ping can be answered
+	replies, err := b.transport.Subscribe(ctx, []string{b.channels.Direct(replyTo)}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe for ping reply: %w", err)
+	}
+	defer replies.Close()
+
+	msg := &models.Message{
+		ID:            id,
+		FromAgent:     replyTo,
+		ToAgent:       agentID,
+		Channel:       b.channels.Direct(agentID),
+		Type:          models.MessageTypePing,
+		CorrelationID: id,
+		Timestamp:     models.Now(),
+		TTL:           int(math.Ceil(wait.Seconds())),
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ping: %w", err)
+	}
+
+	start := time.Now()
+	delivered, err := b.transport.Publish(ctx, msg.Channel, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish ping: %w", err)
+	}
+
+	result := &models.PingResult{
+		MessageID:       id,
+		DeliveredTo:     max(delivered, 0),
+		DeliveryUnknown: delivered == DeliveryUnknown,
+	}
+	if delivered == 0 || wait <= 0 {
+		return result, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	channel := replies.Messages()
+	for {
+		select {
+		case <-ctx.Done():
+			return result, nil
+		case <-timer.C:
+			return result, nil
+		case reply, ok := <-channel:
+			if !ok {
+				return result, nil
+			}
+			var ack models.Message
+			if err := json.Unmarshal([]byte(reply.Payload), &ack); err != nil || ack.CorrelationID != id {
+				continue
+			}
+			result.Acked = true
+			result.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+			return result, nil
+		}
+	}
+}
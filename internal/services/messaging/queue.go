@@ -0,0 +1,138 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/metrics"
+	"agent-comm-hub/internal/models"
+)
+
+// messageQueuePrefix keys the store-and-forward queue of direct messages
+// published while the recipient had no subscriber, oldest first.
+const messageQueuePrefix = "agent:queue:"
+
+// enqueue holds an encoded message for later delivery to an agent. The queue
+// is capped at queueMax entries, dropping the oldest, and expires with the
+// message history.
+func (b *MessageBroker) enqueue(ctx context.Context, agentID string, data []byte) error {
+	key := messageQueuePrefix + agentID
+
+	var length *redis.IntCmd
+	_, err := b.redisStd.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		length = pipe.RPush(ctx, key, data)
+		if b.queueMax > 0 {
+			pipe.LTrim(ctx, key, -b.queueMax, -1)
+		}
+		pipe.Expire(ctx, key, messageHistoryTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue message: %w", err)
+	}
+	if b.queueMax > 0 && length.Val() > b.queueMax {
+		metrics.MessagesDropped(metrics.DropReasonQueueFull, int(length.Val()-b.queueMax))
+	}
+	return nil
+}
+
+// HoldUndelivered queues a direct message whose webhook delivery failed, so
+// the recipient can still pull it from its inbox, and reports whether it was
+// queued. Without store-and-forward there is no queue to hold it in.
+func (b *MessageBroker) HoldUndelivered(ctx context.Context, agentID string, data []byte) (bool, error) {
+	if !b.storeForward {
+		return false, nil
+	}
+	if err := b.enqueue(ctx, agentID, data); err != nil {
+		return false, err
+	}
+
+	var msg models.Message
+	if err := json.Unmarshal(data, &msg); err == nil && msg.NotifyExpiry && b.watchExpiry {
+		if err := b.trackExpiry(ctx, &msg, data); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// QueueDepth reports how many messages are waiting in an agent's queue and
+// how many are retained in its history.
+func (b *MessageBroker) QueueDepth(ctx context.Context, agentID string) (*models.QueueDepth, error) {
+	pipe := b.redisStd.Pipeline()
+	queued := pipe.LLen(ctx, messageQueuePrefix+agentID)
+	history := pipe.LLen(ctx, messageHistoryPrefix+agentID)
+	set, _, _ := inflightKeys(agentID)
+	inflight := pipe.ZCard(ctx, set)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get queue depth: %w", err)
+	}
+
+	return &models.QueueDepth{
+		AgentID:  agentID,
+		Queued:   queued.Val(),
+		History:  history.Val(),
+		InFlight: inflight.Val(),
+	}, nil
+}
+
+// PullQueue removes and returns up to limit of the oldest messages queued for
+// an agent, along with how many remain queued. Messages whose TTL has elapsed
+// are removed but not returned. With a positive visibility, or for messages
+// sent with their own visibility timeout, the returned messages are held in
+// flight and requeued unless acknowledged with Ack before it passes.
+func (b *MessageBroker) PullQueue(ctx context.Context, agentID string, limit int, visibility time.Duration) ([]models.Message, int64, error) {
+	if visibility <= 0 {
+		visibility = b.visibility
+	}
+	return b.pullWithVisibility(ctx, agentID, limit, visibility)
+}
+
+// drainBatchSize is how many queued messages DrainQueue reads at a time.
+const drainBatchSize = 100
+
+// DrainQueue passes the messages queued for an agent to deliver in the order
+// they were sent, removing each from the queue only once deliver succeeds, so
+// when deliver fails that message and those after it stay queued. Messages
+// whose TTL has elapsed are discarded.
+func (b *MessageBroker) DrainQueue(ctx context.Context, agentID string, deliver func(models.Message) error) error {
+	key := messageQueuePrefix + agentID
+
+	for {
+		entries, err := b.redisStd.LRange(ctx, key, 0, drainBatchSize-1).Result()
+		if err != nil {
+			return fmt.Errorf("failed to drain message queue: %w", err)
+		}
+
+		now := time.Now()
+		for _, entry := range entries {
+			var msg models.Message
+			switch err := json.Unmarshal([]byte(entry), &msg); {
+			case err != nil:
+			case msg.Expired(now):
+				metrics.MessageExpired(metrics.ExpiredInQueue)
+				if _, err := b.expired(ctx, &msg, entry); err != nil {
+					log.Printf("Warning: %v", err)
+				}
+			default:
+				if err := deliver(msg); err != nil {
+					return err
+				}
+				if msg.NotifyExpiry {
+					b.delivered(ctx, []string{msg.ID})
+				}
+			}
+			if err := b.redisStd.LRem(ctx, key, 1, entry).Err(); err != nil {
+				return fmt.Errorf("failed to drain message queue: %w", err)
+			}
+		}
+		if len(entries) < drainBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package messaging
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"agent-comm-hub/internal/models"
+)
+
+// RetentionSource looks up per-agent message history retention overrides.
+type RetentionSource interface {
+	// Retention returns an agent's override, or nil if it keeps the
+	// defaults.
+	Retention(ctx context.Context, agentID string) (*models.MessageRetention, error)
+}
+
+// SetRetention sets where per-agent history retention overrides come from.
+// A broker without a source keeps MAX_HISTORY_LIMIT messages for a day for
+// every agent.
+func (b *MessageBroker) SetRetention(retention RetentionSource) {
+	b.retention = retention
+}
+
+// historyRetention returns how many messages to keep in an agent's history,
+// and for how long after the latest. Fields the agent doesn't override, or
+// an override that can't be looked up, fall back to the defaults.
+func (b *MessageBroker) historyRetention(ctx context.Context, agentID string) (int, time.Duration) {
+	count, ttl := b.historyMax, messageHistoryTTL
+	if b.retention == nil {
+		return count, ttl
+	}
+
+	retention, err := b.retention.Retention(ctx, agentID)
+	if err != nil {
+		log.Printf("Warning: failed to look up history retention for %s, using the default: %v", agentID, err)
+		return count, ttl
+	}
+	if retention == nil {
+		return count, ttl
+	}
+	if retention.Messages > 0 {
+		count = retention.Messages
+	}
+	if retention.TTL > 0 {
+		ttl = time.Duration(retention.TTL) * time.Second
+	}
+	return count, ttl
+}
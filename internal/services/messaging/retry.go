@@ -0,0 +1,72 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	retryAttempts     = 4
+	retryInitialDelay = 50 * time.Millisecond
+	retryMaxDelay     = 1 * time.Second
+)
+
+// withRetry runs op, retrying transient Redis failures with exponential
+// backoff until it succeeds, fails permanently, runs out of attempts or the
+// context is done.
+func withRetry(ctx context.Context, op func() error) error {
+	delay := retryInitialDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = op(); err == nil || !isTransient(err) || attempt == retryAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
+
+// isTransient reports whether a Redis error is likely to succeed on retry,
+// such as a dropped connection or a server in the middle of a failover.
+// Command errors like a wrong key type are permanent.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, redis.ErrClosed) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		msg := redisErr.Error()
+		for _, prefix := range []string{"LOADING", "READONLY", "MASTERDOWN", "TRYAGAIN", "CLUSTERDOWN"} {
+			if strings.HasPrefix(msg, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package messaging
+
+import (
+	"fmt"
+	"strings"
+
+	"agent-comm-hub/internal/models"
+)
+
+// TypeRoutes maps message types to the recipient that messages of the type
+// are sent to when the sender names none.
+type TypeRoutes map[models.MessageType]string
+
+// ParseTypeRoutes parses routing rules of the form "type=recipient", where
+// the recipient is anything accepted as to_agent: "broadcast",
+// "topic:<name>", "group:<name>" or an agent ID. Each recipient must map to a
+// valid channel.
+func ParseTypeRoutes(entries []string, channels *ChannelNames) (TypeRoutes, error) {
+	routes := make(TypeRoutes, len(entries))
+	for _, entry := range entries {
+		msgType, recipient, ok := strings.Cut(entry, "=")
+		msgType, recipient = strings.TrimSpace(msgType), strings.TrimSpace(recipient)
+		if !ok || msgType == "" || recipient == "" {
+			return nil, fmt.Errorf("invalid route %q: expected type=recipient", entry)
+		}
+		if _, err := channels.For(recipient); err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", entry, err)
+		}
+		if _, dup := routes[models.MessageType(msgType)]; dup {
+			return nil, fmt.Errorf("duplicate route for message type %q", msgType)
+		}
+		routes[models.MessageType(msgType)] = recipient
+	}
+	return routes, nil
+}
+
+// SetTypeRoutes sets the rules used to pick the recipient of messages sent
+// without one. An explicit recipient always takes precedence.
+func (b *MessageBroker) SetTypeRoutes(routes TypeRoutes) {
+	b.routes = routes
+}
+
+// recipient returns who a send request is addressed to: its to_agent, or
+// else the route for its type.
+func (b *MessageBroker) recipient(req *models.SendMessageRequest) (string, error) {
+	if req.ToAgent != "" {
+		return req.ToAgent, nil
+	}
+	if recipient, ok := b.routes[req.Type]; ok {
+		return recipient, nil
+	}
+	return "", ErrNoRecipient
+}
@@ -0,0 +1,83 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"agent-comm-hub/internal/models"
+)
+
+// SearchHistory scans an agent's message history, oldest first, for up to
+// limit messages matching query after skipping offset matches, and returns
+// the number of matches in the whole history. A query of the form
+// "field=value" matches messages whose field equals value, where field is a
+// dotted path into the message such as "type" or "payload.order.id"; any
+// other query matches messages whose serialized payload contains it,
+// ignoring case. Only the retained history is searched.
+func (b *MessageBroker) SearchHistory(ctx context.Context, agentID, query string, offset, limit int) ([]models.Message, int, error) {
+	history, err := b.retainedHistory(ctx, agentID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	match := payloadContains(query)
+	if field, value, ok := strings.Cut(query, "="); ok && field != "" {
+		match = fieldEquals(strings.Split(field, "."), value)
+	}
+
+	var matches []models.Message
+	for _, msg := range history {
+		if match(&msg) {
+			matches = append(matches, msg)
+		}
+	}
+	page := models.Page(matches, offset, limit)
+	return page.Items, page.Total, nil
+}
+
+func payloadContains(query string) func(*models.Message) bool {
+	needle := bytes.ToLower([]byte(query))
+	return func(msg *models.Message) bool {
+		data, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return false
+		}
+		return bytes.Contains(bytes.ToLower(data), needle)
+	}
+}
+
+// fieldEquals matches messages whose field at path equals value. Strings
+// compare as-is; other values compare by their JSON encoding, so "true" or
+// "42" match booleans and numbers.
+func fieldEquals(path []string, value string) func(*models.Message) bool {
+	return func(msg *models.Message) bool {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return false
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var current interface{}
+		if err := dec.Decode(&current); err != nil {
+			return false
+		}
+
+		for _, key := range path {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			if current, ok = object[key]; !ok {
+				return false
+			}
+		}
+
+		if s, ok := current.(string); ok {
+			return s == value
+		}
+		encoded, err := json.Marshal(current)
+		return err == nil && string(encoded) == value
+	}
+}
@@ -0,0 +1,117 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/models"
+)
+
+const (
+	statsKeyPrefix = "agent:stats:"
+	statsSent      = "sent"
+	statsReceived  = "recv"
+	statsBucket    = time.Minute
+	statsWindow    = 5 * time.Minute // Window the reported rates are averaged over
+)
+
+// recordStats counts a message as sent by the sender and, for direct
+// messages, received by the recipient. Each counter has a running total plus
+// per-minute buckets that expire once they fall out of the rate window.
+// Failures are logged and never fail the send.
+func (b *MessageBroker) recordStats(ctx context.Context, msg *models.Message, direct bool) {
+	bucket := statsBucketKey(time.Now())
+	_, err := b.redisStd.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		incrStat(ctx, pipe, msg.FromAgent, statsSent, bucket)
+		if direct {
+			incrStat(ctx, pipe, msg.ToAgent, statsReceived, bucket)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: failed to record message stats: %v", err)
+	}
+}
+
+func incrStat(ctx context.Context, pipe redis.Pipeliner, agentID, counter, bucket string) {
+	key := statsKeyPrefix + agentID + ":" + counter
+	pipe.Incr(ctx, key)
+	pipe.Incr(ctx, key+":"+bucket)
+	pipe.Expire(ctx, key+":"+bucket, statsWindow+statsBucket)
+}
+
+// GetStats returns message throughput for an agent: totals and average
+// per-second rates over the recent window.
+func (b *MessageBroker) GetStats(ctx context.Context, agentID string) (*models.AgentStats, error) {
+	now := time.Now()
+	pipe := b.redisStd.Pipeline()
+
+	sentTotal := pipe.Get(ctx, statsKeyPrefix+agentID+":"+statsSent)
+	recvTotal := pipe.Get(ctx, statsKeyPrefix+agentID+":"+statsReceived)
+	sentWindow := pipe.MGet(ctx, statsBucketKeys(agentID, statsSent, now)...)
+	recvWindow := pipe.MGet(ctx, statsBucketKeys(agentID, statsReceived, now)...)
+	latencies := pipe.LRange(ctx, statsKeyPrefix+agentID+":"+statsLatency, 0, -1)
+	queued := pipe.LLen(ctx, messageQueuePrefix+agentID)
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get message stats: %w", err)
+	}
+
+	window := statsWindow.Seconds()
+	return &models.AgentStats{
+		AgentID:         agentID,
+		Sent:            intValue(sentTotal),
+		Received:        intValue(recvTotal),
+		SentRate:        float64(sumValues(sentWindow.Val())) / window,
+		ReceivedRate:    float64(sumValues(recvWindow.Val())) / window,
+		WindowSeconds:   int(window),
+		Queued:          queued.Val(),
+		DeliveryLatency: latencyStats(latencies.Val()),
+	}, nil
+}
+
+// statsKeys returns every stats key that may exist for an agent.
+func statsKeys(agentID string, now time.Time) []string {
+	keys := []string{
+		statsKeyPrefix + agentID + ":" + statsSent,
+		statsKeyPrefix + agentID + ":" + statsReceived,
+		statsKeyPrefix + agentID + ":" + statsLatency,
+	}
+	keys = append(keys, statsBucketKeys(agentID, statsSent, now)...)
+	return append(keys, statsBucketKeys(agentID, statsReceived, now)...)
+}
+
+// statsBucketKeys returns the bucket keys covering the rate window ending now.
+func statsBucketKeys(agentID, counter string, now time.Time) []string {
+	n := int(statsWindow / statsBucket)
+	keys := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		keys = append(keys, statsKeyPrefix+agentID+":"+counter+":"+statsBucketKey(now.Add(-time.Duration(i)*statsBucket)))
+	}
+	return keys
+}
+
+func statsBucketKey(t time.Time) string {
+	return strconv.FormatInt(t.Unix()/int64(statsBucket.Seconds()), 10)
+}
+
+func intValue(cmd *redis.StringCmd) int64 {
+	n, _ := cmd.Int64()
+	return n
+}
+
+func sumValues(values []interface{}) int64 {
+	var sum int64
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			n, _ := strconv.ParseInt(s, 10, 64)
+			sum += n
+		}
+	}
+	return sum
+}
@@ -0,0 +1,64 @@
+package messaging
+
+import (
+	"strings"
+
+	"agent-comm-hub/internal/models"
+)
+
+// Transform rewrites a message before it is published and stored, for
+// example to enrich or redact it. Returning an error rejects the message.
+type Transform func(msg *models.Message) error
+
+// AddTransform appends a transform to the pipeline applied to every message
+// sent through the broker. Transforms run in the order they were added.
+func (b *MessageBroker) AddTransform(t Transform) {
+	b.transforms = append(b.transforms, t)
+}
+
+// RedactFields returns a transform that strips fields from object payloads.
+// Fields in deny are removed; each is a dotted path such as "auth.token".
+// If allow is not empty, only the listed top-level payload fields are kept.
+func RedactFields(allow, deny []string) Transform {
+	var allowed map[string]bool
+	if len(allow) > 0 {
+		allowed = make(map[string]bool, len(allow))
+		for _, field := range allow {
+			allowed[field] = true
+		}
+	}
+	denied := make([][]string, len(deny))
+	for i, field := range deny {
+		denied[i] = strings.Split(field, ".")
+	}
+
+	return func(msg *models.Message) error {
+		payload, ok := msg.Payload.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if allowed != nil {
+			for field := range payload {
+				if !allowed[field] {
+					delete(payload, field)
+				}
+			}
+		}
+		for _, path := range denied {
+			deletePath(payload, path)
+		}
+		return nil
+	}
+}
+
+// deletePath removes the field at path from a decoded JSON object.
+func deletePath(object map[string]interface{}, path []string) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := object[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		object = next
+	}
+	delete(object, path[len(path)-1])
+}
@@ -0,0 +1,72 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/config"
+)
+
+// Message transport backends.
+const (
+	BackendRedis = "redis"
+	BackendNATS  = "nats"
+)
+
+// DeliveryUnknown is reported as the delivered count by transports that can't
+// tell how many subscribers received a message.
+const DeliveryUnknown = -1
+
+// Transport carries published messages from senders to subscribers. Channel
+// names use the hub's Redis-style form, e.g. "agent:message:<id>", and
+// patterns end in "*".
+type Transport interface {
+	// Publish sends data on a channel and returns the number of subscribers
+	// that received it, or DeliveryUnknown.
+	Publish(ctx context.Context, channel string, data []byte) (int64, error)
+	// PublishBatch publishes several messages in one round trip.
+	PublishBatch(ctx context.Context, pubs []Publication) []PublishResult
+	// Subscribe subscribes to channels and patterns. It returns once the
+	// subscription is active, so nothing published afterwards is missed.
+	Subscribe(ctx context.Context, channels, patterns []string) (Subscription, error)
+	// Subscribers returns the number of subscribers to a channel, not
+	// counting pattern subscribers, or DeliveryUnknown.
+	Subscribers(ctx context.Context, channel string) (int64, error)
+	// Check verifies the transport is reachable.
+	Check(ctx context.Context) error
+	// Close releases the transport's connections.
+	Close() error
+}
+
+// Publication is a message to publish on a channel.
+type Publication struct {
+	Channel string
+	Data    []byte
+}
+
+// PublishResult is the outcome of publishing one message in a batch.
+type PublishResult struct {
+	Delivered int64
+	Err       error
+}
+
+// NewTransport creates the transport for the configured messaging backend.
+// The Redis pub/sub client and keepalive are only used by the Redis backend.
+// Store-and-forward needs to know whether a recipient is subscribed, so it
+// can't be combined with NATS.
+func NewTransport(cfg *config.MessagingConfig, redisPubSub *redis.Client, redisKeepalive time.Duration) (Transport, error) {
+	switch cfg.Backend {
+	case BackendRedis, "":
+		return NewRedisTransport(redisPubSub, redisKeepalive), nil
+	case BackendNATS:
+		if cfg.StoreAndForward {
+			return nil, fmt.Errorf("store-and-forward is not supported by the %s backend, which can't count subscribers", BackendNATS)
+		}
+		return NewNATSTransport(cfg.NATSURL)
+	default:
+		return nil, fmt.Errorf("unknown message backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,213 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsChannelHeader carries the hub channel name, since subjects can't
+// represent every channel name faithfully.
+const natsChannelHeader = "Hub-Channel"
+
+// NATSTransport is a Transport over NATS core pub/sub. NATS does not report
+// how many subscribers received a message, so deliveries are always
+// DeliveryUnknown.
+type NATSTransport struct {
+	conn *nats.Conn
+}
+
+// NewNATSTransport connects to NATS and creates a new transport.
+func NewNATSTransport(url string) (*NATSTransport, error) {
+	conn, err := nats.Connect(url, nats.Name("agent-comm-hub"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return &NATSTransport{conn: conn}, nil
+}
+
+// Publish implements Transport.
+func (t *NATSTransport) Publish(ctx context.Context, channel string, data []byte) (int64, error) {
+	if err := t.conn.PublishMsg(natsMessage(channel, data)); err != nil {
+		return 0, err
+	}
+	// Flush so publish errors surface here rather than being lost
+	if err := t.conn.FlushWithContext(ctx); err != nil {
+		return 0, err
+	}
+	return DeliveryUnknown, nil
+}
+
+// Subscribers implements Transport. NATS does not expose subscriber counts to
+// clients.
+func (t *NATSTransport) Subscribers(ctx context.Context, channel string) (int64, error) {
+	return DeliveryUnknown, nil
+}
+
+// PublishBatch implements Transport, flushing once after all messages.
+func (t *NATSTransport) PublishBatch(ctx context.Context, pubs []Publication) []PublishResult {
+	results := make([]PublishResult, len(pubs))
+	for i, pub := range pubs {
+		results[i] = PublishResult{Delivered: DeliveryUnknown}
+		results[i].Err = t.conn.PublishMsg(natsMessage(pub.Channel, pub.Data))
+	}
+
+	if err := t.conn.FlushWithContext(ctx); err != nil {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+	}
+	return results
+}
+
+// Subscribe implements Transport.
+func (t *NATSTransport) Subscribe(ctx context.Context, channels, patterns []string) (Subscription, error) {
+	s := &natsSubscription{
+		messages: make(chan Envelope),
+		done:     make(chan struct{}),
+	}
+
+	handlers := make(map[string]nats.MsgHandler, len(channels)+len(patterns))
+	for _, channel := range channels {
+		handlers[natsSubject(channel)] = s.deliver
+	}
+	for _, pattern := range patterns {
+		// NATS wildcards match whole tokens, so a pattern that is not a
+		// whole-token prefix, like "agent:message:worker-*" or
+		// "acme.agent.*.inbox", subscribes to the subjects under its fixed
+		// prefix and filters
+		prefix, _, _ := strings.Cut(pattern, "*")
+		parent := prefix[:strings.LastIndexAny(prefix, ":.")+1]
+		if parent == prefix && pattern == prefix+"*" {
+			handlers[natsSubject(prefix)+">"] = s.deliver
+			continue
+		}
+		handlers[natsSubject(parent)+">"] = func(msg *nats.Msg) {
+			if globMatch(pattern, natsChannel(msg)) {
+				s.deliver(msg)
+			}
+		}
+	}
+
+	for subject, handler := range handlers {
+		sub, err := t.conn.Subscribe(subject, handler)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to subscribe: %w", err)
+		}
+		s.subs = append(s.subs, sub)
+	}
+
+	// Make sure the server has registered the subscriptions
+	if err := t.conn.FlushWithContext(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to subscribe: %w", err)
+	}
+	return s, nil
+}
+
+// Check implements Transport.
+func (t *NATSTransport) Check(ctx context.Context) error {
+	if !t.conn.IsConnected() {
+		return fmt.Errorf("not connected (%s)", t.conn.Status())
+	}
+	return t.conn.FlushWithContext(ctx)
+}
+
+// Close implements Transport.
+func (t *NATSTransport) Close() error {
+	return t.conn.Drain()
+}
+
+// natsSubscription adapts NATS subscriptions to Subscription.
+type natsSubscription struct {
+	subs     []*nats.Subscription
+	messages chan Envelope
+	done     chan struct{}
+
+	mu        sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+}
+
+// deliver is the NATS message handler for every subject in the subscription.
+func (s *natsSubscription) deliver(msg *nats.Msg) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.messages <- Envelope{Channel: natsChannel(msg), Payload: string(msg.Data)}:
+	case <-s.done:
+	}
+}
+
+// Messages implements Subscription.
+func (s *natsSubscription) Messages() <-chan Envelope {
+	return s.messages
+}
+
+// Close implements Subscription.
+func (s *natsSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		// Release any handler blocked on delivery before taking the write lock
+		close(s.done)
+		for _, sub := range s.subs {
+			sub.Unsubscribe()
+		}
+
+		s.mu.Lock()
+		s.closed = true
+		close(s.messages)
+		s.mu.Unlock()
+	})
+	return nil
+}
+
+// globMatch reports whether channel matches a pattern whose only wildcard is
+// "*", matching any run of characters as in Redis patterns.
+func globMatch(pattern, channel string) bool {
+	parts := strings.Split(pattern, "*")
+	rest, ok := strings.CutPrefix(channel, parts[0])
+	if !ok {
+		return false
+	}
+	if len(parts) == 1 {
+		return rest == ""
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return strings.HasSuffix(rest, parts[len(parts)-1])
+}
+
+// natsSubject maps a hub channel name to a NATS subject.
+func natsSubject(channel string) string {
+	return strings.ReplaceAll(channel, ":", ".")
+}
+
+// natsChannel returns the hub channel a NATS message was published on.
+func natsChannel(msg *nats.Msg) string {
+	if channel := msg.Header.Get(natsChannelHeader); channel != "" {
+		return channel
+	}
+	return strings.ReplaceAll(msg.Subject, ".", ":")
+}
+
+func natsMessage(channel string, data []byte) *nats.Msg {
+	msg := nats.NewMsg(natsSubject(channel))
+	msg.Header.Set(natsChannelHeader, channel)
+	msg.Data = data
+	return msg
+}
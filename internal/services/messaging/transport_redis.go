@@ -0,0 +1,165 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/metrics"
+)
+
+// RedisTransport is a Transport over Redis pub/sub.
+type RedisTransport struct {
+	redis     *redis.Client
+	keepalive time.Duration
+}
+
+// NewRedisTransport creates a new Redis pub/sub transport. Subscriptions that
+// receive nothing for keepalive are pinged, and reconnected and resubscribed
+// if the ping fails (0 disables keepalive).
+func NewRedisTransport(client *redis.Client, keepalive time.Duration) *RedisTransport {
+	return &RedisTransport{redis: client, keepalive: keepalive}
+}
+
+// Publish implements Transport.
+func (t *RedisTransport) Publish(ctx context.Context, channel string, data []byte) (int64, error) {
+	return t.redis.Publish(ctx, channel, data).Result()
+}
+
+// PublishBatch implements Transport with a single pipeline.
+func (t *RedisTransport) PublishBatch(ctx context.Context, pubs []Publication) []PublishResult {
+	pipe := t.redis.Pipeline()
+	cmds := make([]*redis.IntCmd, len(pubs))
+	for i, pub := range pubs {
+		cmds[i] = pipe.Publish(ctx, pub.Channel, pub.Data)
+	}
+	// Per-command errors are reported in the results below
+	pipe.Exec(ctx)
+
+	results := make([]PublishResult, len(pubs))
+	for i, cmd := range cmds {
+		results[i].Delivered, results[i].Err = cmd.Result()
+	}
+	return results
+}
+
+// Subscribe implements Transport.
+func (t *RedisTransport) Subscribe(ctx context.Context, channels, patterns []string) (Subscription, error) {
+	pubsub := t.redis.Subscribe(ctx)
+	if len(channels) > 0 {
+		if err := pubsub.Subscribe(ctx, channels...); err != nil {
+			pubsub.Close()
+			return nil, fmt.Errorf("failed to subscribe: %w", err)
+		}
+	}
+	if len(patterns) > 0 {
+		if err := pubsub.PSubscribe(ctx, patterns...); err != nil {
+			pubsub.Close()
+			return nil, fmt.Errorf("failed to subscribe: %w", err)
+		}
+	}
+
+	// Wait for every subscription to be confirmed, keeping any messages that
+	// arrive in the meantime
+	var pending []Envelope
+	for confirmed := 0; confirmed < len(channels)+len(patterns); {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			pubsub.Close()
+			return nil, fmt.Errorf("failed to subscribe: %w", err)
+		}
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			confirmed++
+		case *redis.Message:
+			pending = append(pending, Envelope{Channel: m.Channel, Payload: m.Payload})
+		}
+	}
+
+	s := &redisSubscription{
+		pubsub:   pubsub,
+		name:     strings.Join(slices.Concat(channels, patterns), ","),
+		messages: make(chan Envelope),
+		done:     make(chan struct{}),
+	}
+	go s.forward(pending, t.keepalive)
+	return s, nil
+}
+
+// Subscribers implements Transport.
+func (t *RedisTransport) Subscribers(ctx context.Context, channel string) (int64, error) {
+	counts, err := t.redis.PubSubNumSub(ctx, channel).Result()
+	if err != nil {
+		return 0, err
+	}
+	return counts[channel], nil
+}
+
+// Check implements Transport.
+func (t *RedisTransport) Check(ctx context.Context) error {
+	return t.redis.Ping(ctx).Err()
+}
+
+// Close implements Transport. The Redis client is owned by the Redis manager,
+// which closes it.
+func (t *RedisTransport) Close() error {
+	return nil
+}
+
+// redisSubscription adapts a Redis pub/sub connection to Subscription.
+type redisSubscription struct {
+	pubsub    *redis.PubSub
+	name      string // Subscribed channels and patterns, for logging
+	messages  chan Envelope
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// forward relays pending and then live messages until the subscription is
+// closed. go-redis pings the connection after keepalive without traffic and
+// transparently reconnects and resubscribes when that fails; the
+// resubscription confirmations are what reveal a reconnect here. Messages
+// published while the connection was down are lost.
+func (s *redisSubscription) forward(pending []Envelope, keepalive time.Duration) {
+	defer close(s.messages)
+	for _, envelope := range pending {
+		select {
+		case s.messages <- envelope:
+		case <-s.done:
+			return
+		}
+	}
+	for msg := range s.pubsub.ChannelWithSubscriptions(redis.WithChannelHealthCheckInterval(keepalive)) {
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			// The first confirmation on a fresh connection has a count of 1
+			if m.Count == 1 && (m.Kind == "subscribe" || m.Kind == "psubscribe") {
+				log.Printf("Reconnected pub/sub subscription to %s", s.name)
+				metrics.PubSubReconnected()
+			}
+		case *redis.Message:
+			select {
+			case s.messages <- Envelope{Channel: m.Channel, Payload: m.Payload}:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// Messages implements Subscription.
+func (s *redisSubscription) Messages() <-chan Envelope {
+	return s.messages
+}
+
+// Close implements Subscription.
+func (s *redisSubscription) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return s.pubsub.Close()
+}
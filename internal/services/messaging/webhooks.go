@@ -0,0 +1,40 @@
+package messaging
+
+import (
+	"context"
+	"log"
+
+	"agent-comm-hub/internal/models"
+)
+
+// WebhookDispatcher delivers direct messages to recipients registered for
+// webhook delivery.
+type WebhookDispatcher interface {
+	// Dispatch queues msg for webhook delivery if its recipient receives
+	// messages that way, and returns the recipient's delivery mode.
+	Dispatch(ctx context.Context, msg *models.Message, data []byte) (models.DeliveryMode, error)
+}
+
+// SetWebhooks sets the dispatcher for direct messages to webhook agents. A
+// broker without one delivers over pub/sub only.
+func (b *MessageBroker) SetWebhooks(webhooks WebhookDispatcher) {
+	b.webhooks = webhooks
+}
+
+// dispatchWebhook hands a direct message to the webhook dispatcher, reporting
+// it in the receipt. It returns whether the recipient also takes pub/sub
+// delivery, or the dispatch failed, so the message should be queued if
+// nobody received it.
+func (b *MessageBroker) dispatchWebhook(ctx context.Context, msg *models.Message, data []byte, receipt *Receipt) bool {
+	if b.webhooks == nil {
+		return true
+	}
+	mode, err := b.webhooks.Dispatch(ctx, msg, data)
+	if err != nil {
+		log.Printf("Warning: failed to dispatch message %s to webhook of %s: %v", msg.ID, msg.ToAgent, err)
+	}
+	if mode == models.DeliveryWebhook || mode == models.DeliveryBoth {
+		receipt.Webhook = err == nil
+	}
+	return mode != models.DeliveryWebhook || err != nil
+}
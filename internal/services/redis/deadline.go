@@ -0,0 +1,37 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// deadlineHook bounds every command and pipeline by a per-operation timeout,
+// on top of whatever deadline the caller's context already carries, so a hung
+// Redis cannot hold a request past it.
+type deadlineHook struct {
+	timeout time.Duration
+}
+
+func (h deadlineHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h deadlineHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, cancel := context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+		return next(ctx, cmd)
+	}
+}
+
+func (h deadlineHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, cancel := context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+		return next(ctx, cmds)
+	}
+}
+
+var _ redis.Hook = deadlineHook{}
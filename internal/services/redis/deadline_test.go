@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slowRedis accepts connections and reads commands but never answers, like
+// a Redis server that has hung.
+func slowRedis(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// slowClient returns a client of a hung Redis whose socket timeouts alone
+// would hold a command for a minute.
+func slowClient(t *testing.T, opTimeout time.Duration) *redis.Client {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{
+		Addr:                  slowRedis(t),
+		ReadTimeout:           time.Minute,
+		WriteTimeout:          time.Minute,
+		MaxRetries:            -1,
+		ContextTimeoutEnabled: true,
+	})
+	if opTimeout > 0 {
+		client.AddHook(deadlineHook{timeout: opTimeout})
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestDeadlineHookBoundsCommands(t *testing.T) {
+	client := slowClient(t, 100*time.Millisecond)
+
+	start := time.Now()
+	err := client.Get(context.Background(), "key").Err()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("command took %v against a hung Redis", elapsed)
+	}
+	if err == nil || errors.Is(err, redis.Nil) {
+		t.Fatalf("command against a hung Redis returned %v, want a timeout", err)
+	}
+}
+
+func TestDeadlineHookBoundsPipelines(t *testing.T) {
+	client := slowClient(t, 100*time.Millisecond)
+
+	start := time.Now()
+	_, err := client.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.Get(context.Background(), "a")
+		pipe.Get(context.Background(), "b")
+		return nil
+	})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("pipeline took %v against a hung Redis", elapsed)
+	}
+	if err == nil {
+		t.Fatal("pipeline against a hung Redis succeeded")
+	}
+}
+
+func TestHandlerReturnsPromptlyWithSlowRedis(t *testing.T) {
+	// No per-operation timeout: the request deadline alone must bound the call
+	client := slowClient(t, 0)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := client.Get(r.Context(), "key").Err(); err != nil && !errors.Is(err, redis.Nil) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(rec, req)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("handler took %v against a hung Redis", elapsed)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler answered %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,86 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/models"
+)
+
+// scanBatch is the number of keys examined per SCAN call.
+const scanBatch = 1000
+
+// ScanKeys returns up to max keys in the standard Redis whose names contain
+// substr, with their types and TTLs. It walks the keyspace with SCAN, so it
+// does not block Redis the way KEYS does, but still visits every key; use it
+// for troubleshooting only. truncated reports whether more keys matched.
+func (m *Manager) ScanKeys(ctx context.Context, substr string, max int) (_ []models.RedisKey, truncated bool, err error) {
+	pattern := "*" + escapeGlob(substr) + "*"
+
+	var names []string
+	iter := m.standard.Scan(ctx, 0, pattern, scanBatch).Iterator()
+	for iter.Next(ctx) {
+		if len(names) == max {
+			truncated = true
+			break
+		}
+		names = append(names, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to scan keys: %w", err)
+	}
+
+	keys, err := m.describeKeys(ctx, names)
+	if err != nil {
+		return nil, false, err
+	}
+	return keys, truncated, nil
+}
+
+// describeKeys looks up the types and TTLs of keys, leaving out keys that
+// no longer exist.
+func (m *Manager) describeKeys(ctx context.Context, names []string) ([]models.RedisKey, error) {
+	keys := make([]models.RedisKey, 0, len(names))
+	if len(names) == 0 {
+		return keys, nil
+	}
+
+	pipe := m.standard.Pipeline()
+	types := make([]*redis.StatusCmd, len(names))
+	ttls := make([]*redis.DurationCmd, len(names))
+	for i, name := range names {
+		types[i] = pipe.Type(ctx, name)
+		ttls[i] = pipe.PTTL(ctx, name)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to describe keys: %w", err)
+	}
+
+	for i, name := range names {
+		if types[i].Val() == "none" {
+			continue
+		}
+		ttl := int64(-1)
+		if d := ttls[i].Val(); d > 0 {
+			ttl = d.Milliseconds()
+		}
+		keys = append(keys, models.RedisKey{Key: name, Type: types[i].Val(), TTLMS: ttl})
+	}
+	return keys, nil
+}
+
+// escapeGlob escapes the characters SCAN MATCH treats as wildcards.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -4,27 +4,47 @@ package redis
 import (
 	"context"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 
 	"agent-comm-hub/internal/config"
 )
 
+const (
+	healthLoopbackPrefix  = "health:loopback:"
+	healthLoopbackTimeout = 2 * time.Second
+	startupMaxBackoff     = 30 * time.Second
+)
+
 // Manager manages Redis connections.
 type Manager struct {
 	standard *redis.Client
 	pubsub   *redis.Client
 }
 
-// NewManager creates a new Redis manager.
+// NewManager creates a new Redis manager, waiting for both servers to answer.
 func NewManager(cfg *config.RedisConfig) (*Manager, error) {
-	standard, err := newRedisClient(cfg.StandardURL, cfg.PoolSize, cfg.MinIdleConn, cfg.Timeout)
+	return newManager(cfg, true)
+}
+
+// NewLazyManager creates a Redis manager without waiting for Redis, for hubs
+// that can serve some requests without it. The clients connect on first use,
+// so Redis-backed features fail until Redis is reachable.
+func NewLazyManager(cfg *config.RedisConfig) (*Manager, error) {
+	return newManager(cfg, false)
+}
+
+func newManager(cfg *config.RedisConfig, wait bool) (*Manager, error) {
+	standard, err := newRedisClient("standard", cfg.StandardURL, cfg, cfg.ReadTimeout, wait)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create standard Redis client: %w", err)
 	}
 
-	pubsub, err := newRedisClient(cfg.PubSubURL, cfg.PoolSize, cfg.MinIdleConn, cfg.Timeout)
+	pubsub, err := newRedisClient("pubsub", cfg.PubSubURL, cfg, cfg.PubSubReadTimeout, wait)
 	if err != nil {
 		standard.Close()
 		return nil, fmt.Errorf("failed to create pubsub Redis client: %w", err)
@@ -36,32 +56,90 @@ func NewManager(cfg *config.RedisConfig) (*Manager, error) {
 	}, nil
 }
 
-func newRedisClient(url string, poolSize, minIdleConn int, timeout time.Duration) (*redis.Client, error) {
+func newRedisClient(name, url string, cfg *config.RedisConfig, readTimeout time.Duration, wait bool) (*redis.Client, error) {
 	opts, err := redis.ParseURL(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
 	}
 
-	opts.PoolSize = poolSize
-	opts.MinIdleConns = minIdleConn
-	opts.ReadTimeout = timeout
-	opts.WriteTimeout = timeout
-	opts.DialTimeout = timeout
+	opts.PoolSize = cfg.PoolSize
+	opts.MinIdleConns = cfg.MinIdleConn
+	opts.DialTimeout = cfg.DialTimeout
+	opts.ReadTimeout = readTimeout
+	opts.WriteTimeout = cfg.WriteTimeout
+	// Honor context deadlines on the socket instead of only the fixed
+	// read/write timeouts, so cancelled requests release Redis promptly
+	opts.ContextTimeoutEnabled = true
 
 	client := redis.NewClient(opts)
+	if cfg.OpTimeout > 0 {
+		client.AddHook(deadlineHook{timeout: cfg.OpTimeout})
+	}
 
-	// Test connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := client.Ping(ctx).Err(); err != nil {
+	if !wait {
+		return client, nil
+	}
+	if err := waitForRedis(name, client, cfg); err != nil {
 		client.Close()
-		return nil, fmt.Errorf("failed to ping Redis: %w", err)
+		return nil, err
 	}
 
 	return client, nil
 }
 
+// waitForRedis pings a new client until Redis answers, retrying with
+// exponential backoff so the hub can start alongside Redis during a deploy.
+func waitForRedis(name string, client *redis.Client, cfg *config.RedisConfig) error {
+	attempts := max(cfg.StartupAttempts, 1)
+	backoff := cfg.StartupBackoff
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.StartupTimeout)
+		err = client.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		log.Printf("Waiting for %s Redis (attempt %d/%d): %v; retrying in %s", name, attempt, attempts, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, startupMaxBackoff)
+	}
+	return fmt.Errorf("failed to ping Redis after %d attempts: %w", attempts, err)
+}
+
+// SameServer reports whether the standard and pub/sub clients are connected
+// to the same Redis server, comparing the run IDs the servers report.
+func (m *Manager) SameServer(ctx context.Context) (bool, error) {
+	standard, err := runID(ctx, m.standard)
+	if err != nil {
+		return false, fmt.Errorf("standard Redis: %w", err)
+	}
+	pubsub, err := runID(ctx, m.pubsub)
+	if err != nil {
+		return false, fmt.Errorf("pubsub Redis: %w", err)
+	}
+	return standard == pubsub, nil
+}
+
+// runID returns the run ID that identifies a Redis server process.
+func runID(ctx context.Context, client *redis.Client) (string, error) {
+	info, err := client.Info(ctx, "server").Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to get server info: %w", err)
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if id, ok := strings.CutPrefix(strings.TrimSpace(line), "run_id:"); ok {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("server info has no run_id")
+}
+
 // Standard returns the standard Redis client.
 func (m *Manager) Standard() *redis.Client {
 	return m.standard
@@ -93,11 +171,57 @@ func (m *Manager) Close() error {
 
 // Ping checks Redis connectivity.
 func (m *Manager) Ping(ctx context.Context) error {
+	if err := m.PingStandard(ctx); err != nil {
+		return err
+	}
+	return m.PingPubSub(ctx)
+}
+
+// PingStandard checks connectivity to the standard Redis.
+func (m *Manager) PingStandard(ctx context.Context) error {
 	if err := m.standard.Ping(ctx).Err(); err != nil {
 		return fmt.Errorf("standard Redis ping failed: %w", err)
 	}
+	return nil
+}
+
+// PingPubSub checks connectivity to the pub/sub Redis.
+func (m *Manager) PingPubSub(ctx context.Context) error {
 	if err := m.pubsub.Ping(ctx).Err(); err != nil {
 		return fmt.Errorf("pubsub Redis ping failed: %w", err)
 	}
 	return nil
 }
+
+// CheckPubSub verifies pub/sub delivery end to end by publishing a probe to a
+// loopback channel and waiting for it to arrive on a subscription to the same
+// channel. A plain PING cannot detect a pubsub URL pointing at the wrong server.
+func (m *Manager) CheckPubSub(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthLoopbackTimeout)
+	defer cancel()
+
+	channel := healthLoopbackPrefix + uuid.New().String()
+
+	sub := m.pubsub.Subscribe(ctx, channel)
+	defer sub.Close()
+
+	// Wait for the subscription to be confirmed before publishing
+	if _, err := sub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to loopback channel: %w", err)
+	}
+
+	token := uuid.New().String()
+	if err := m.pubsub.Publish(ctx, channel, token).Err(); err != nil {
+		return fmt.Errorf("failed to publish loopback message: %w", err)
+	}
+
+	for {
+		msg, err := sub.ReceiveMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("loopback message not received: %w", err)
+		}
+		if msg.Payload == token {
+			return nil
+		}
+	}
+}
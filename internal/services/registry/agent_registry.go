@@ -6,44 +6,127 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
-
+	"agent-comm-hub/internal/config"
+	"agent-comm-hub/internal/idgen"
 	"agent-comm-hub/internal/models"
 )
 
-const (
-	agentKeyPrefix    = "agent:"
-	agentIndexKey     = "agents:index"
-	agentHeartbeatTTL = 5 * time.Minute
-)
+const agentHeartbeatTTL = 5 * time.Minute
 
 // Errors for agent registry.
 var (
-	ErrAgentNotFound = errors.New("agent not found")
-	ErrAgentExists   = errors.New("agent already exists")
+	ErrAgentNotFound      = errors.New("agent not found")
+	ErrAgentExists        = errors.New("agent already exists")
+	ErrAgentLimit         = errors.New("maximum number of agents reached")
+	ErrInvalidType        = errors.New("invalid agent type")
+	ErrInvalidUpdate      = errors.New("name, type and status cannot be cleared")
+	ErrAgentCorrupt       = errors.New("agent records could not be loaded")
+	ErrInvalidCapability  = errors.New("capabilities must be non-empty strings")
+	ErrPreconditionFailed = errors.New("agent does not match precondition")
+	ErrInvalidDelivery    = errors.New("delivery must be pubsub, webhook or both, and webhook delivery needs an http or https endpoint")
+	ErrInvalidRetention   = errors.New("invalid message retention")
+	ErrReservedName       = errors.New("agent name is reserved")
+	ErrInvalidStatus      = errors.New("status must be online, offline or busy")
 )
 
+// Precondition checks an agent's current state before an update is applied
+// to it. An update whose precondition returns false fails with
+// ErrPreconditionFailed and changes nothing.
+type Precondition func(agent *models.Agent) bool
+
+// CleanupFunc releases resources owned by an agent when it is unregistered.
+type CleanupFunc func(ctx context.Context, agentID string) error
+
 // AgentRegistry manages agent registration and discovery.
 type AgentRegistry struct {
-	redis *redis.Client
+	store           Store
+	ids             idgen.IDGenerator
+	maxAgents       int
+	eventsMaxLen    int64
+	agentTypes      []string
+	validTypes      map[string]bool
+	heartbeatJitter time.Duration
+	maxRetention    models.MessageRetention // Largest accepted retention overrides
+	cleanups        []CleanupFunc
 }
 
 // NewAgentRegistry creates a new agent registry.
-func NewAgentRegistry(redisClient *redis.Client) *AgentRegistry {
+func NewAgentRegistry(store Store, ids idgen.IDGenerator, cfg *config.RegistryConfig) *AgentRegistry {
+	var validTypes map[string]bool
+	if len(cfg.AgentTypes) > 0 {
+		validTypes = make(map[string]bool, len(cfg.AgentTypes))
+		for _, t := range cfg.AgentTypes {
+			validTypes[t] = true
+		}
+	}
+
 	return &AgentRegistry{
-		redis: redisClient,
+		store:           store,
+		ids:             ids,
+		maxAgents:       cfg.MaxAgents,
+		eventsMaxLen:    cfg.EventsMax,
+		agentTypes:      cfg.AgentTypes,
+		validTypes:      validTypes,
+		heartbeatJitter: cfg.HeartbeatJitter,
+		maxRetention: models.MessageRetention{
+			Messages: cfg.MaxRetentionMessages,
+			TTL:      int(cfg.MaxRetentionTTL.Seconds()),
+		},
 	}
 }
 
-// Register registers a new agent.
-func (r *AgentRegistry) Register(ctx context.Context, req *models.RegisterAgentRequest) (*models.Agent, error) {
+// OnUnregister adds a cleanup function that is run after an agent has been
+// removed from the registry. Cleanup failures are logged and do not fail the
+// unregistration.
+func (r *AgentRegistry) OnUnregister(fn CleanupFunc) {
+	r.cleanups = append(r.cleanups, fn)
+}
+
+// Register registers a new agent. Agent names are unique: registering a name
+// that already belongs to an agent of the same type returns that agent with
+// created set to false, while a type mismatch returns ErrAgentExists.
+func (r *AgentRegistry) Register(ctx context.Context, req *models.RegisterAgentRequest) (*models.Agent, bool, error) {
+	if !r.isValidType(req.Type) {
+		return nil, false, ErrInvalidType
+	}
+	if models.IsReserved(req.Name) {
+		return nil, false, ErrReservedName
+	}
+	if !validDelivery(req.Delivery, req.Endpoint) {
+		return nil, false, ErrInvalidDelivery
+	}
+	if err := r.checkRetention(req.Retention); err != nil {
+		return nil, false, err
+	}
+
+	existing, err := r.findExisting(ctx, req)
+	if err != nil || existing != nil {
+		return existing, false, err
+	}
+
+	// Enforce registration quota
+	if r.maxAgents > 0 {
+		count, err := r.store.CountAgents(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		if count >= int64(r.maxAgents) {
+			return nil, false, ErrAgentLimit
+		}
+	}
+
 	// Generate unique ID
-	agentID := uuid.New().String()
+	agentID := r.ids.NewID()
 
-	now := time.Now()
+	now := models.Now()
 	agent := &models.Agent{
 		ID:           agentID,
 		Name:         req.Name,
@@ -52,143 +135,622 @@ func (r *AgentRegistry) Register(ctx context.Context, req *models.RegisterAgentR
 		Endpoint:     req.Endpoint,
 		Status:       models.StatusOnline,
 		Metadata:     req.Metadata,
+		Delivery:     req.Delivery,
+		Retention:    req.Retention,
 		CreatedAt:    now,
 		LastSeen:     now,
+		OnlineSince:  &now,
 	}
 
 	// Store agent data
-	agentKey := agentKeyPrefix + agentID
-	data, err := json.Marshal(agent)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal agent: %w", err)
+	if err := r.store.PutAgent(ctx, agent); err != nil {
+		return nil, false, err
 	}
 
-	if err := r.redis.Set(ctx, agentKey, data, 0).Err(); err != nil {
-		return nil, fmt.Errorf("failed to store agent: %w", err)
+	// Claim the name, deferring to the winner of a concurrent registration
+	claimed, err := r.store.ClaimName(ctx, req.Name, agentID)
+	if err != nil || !claimed {
+		r.store.DeleteAgent(ctx, agentID)
+		if err != nil {
+			return nil, false, err
+		}
+		existing, err := r.findExisting(ctx, req)
+		if err == nil && existing == nil {
+			err = ErrAgentExists
+		}
+		return existing, false, err
 	}
 
-	// Add to index
-	if err := r.redis.SAdd(ctx, agentIndexKey, agentID).Err(); err != nil {
-		return nil, fmt.Errorf("failed to add agent to index: %w", err)
+	// Add to indexes
+	if err := r.store.AddAgent(ctx, agentID); err != nil {
+		return nil, false, err
+	}
+	if err := r.store.IndexType(ctx, agentID, agent.Type); err != nil {
+		return nil, false, err
+	}
+	if err := r.reindexCapabilities(ctx, agentID, nil, agent.Capabilities); err != nil {
+		return nil, false, err
 	}
 
 	// Set heartbeat
 	if err := r.updateHeartbeat(ctx, agentID); err != nil {
+		return nil, false, err
+	}
+
+	r.recordEvent(ctx, models.EventRegister, agent)
+
+	return agent, true, nil
+}
+
+// findExisting returns the agent already registered under the requested name,
+// or nil if the name is free.
+func (r *AgentRegistry) findExisting(ctx context.Context, req *models.RegisterAgentRequest) (*models.Agent, error) {
+	agentID, err := r.store.NameOwner(ctx, req.Name)
+	if err != nil || agentID == "" {
+		return nil, err
+	}
+
+	agent, err := r.Get(ctx, agentID)
+	if errors.Is(err, ErrAgentNotFound) {
+		// Stale name entry, release it
+		r.store.ReleaseName(ctx, req.Name)
+		return nil, nil
+	}
+	if err != nil {
 		return nil, err
 	}
 
+	if agent.Type != req.Type {
+		return nil, ErrAgentExists
+	}
 	return agent, nil
 }
 
 // Get retrieves an agent by ID.
 func (r *AgentRegistry) Get(ctx context.Context, agentID string) (*models.Agent, error) {
-	agentKey := agentKeyPrefix + agentID
-	data, err := r.redis.Get(ctx, agentKey).Bytes()
-	if err == redis.Nil {
-		return nil, ErrAgentNotFound
+	return r.store.GetAgent(ctx, agentID)
+}
+
+// List retrieves the registered agents matching the query, in the order it
+// requests; a nil query matches every agent.
+func (r *AgentRegistry) List(ctx context.Context, query *models.AgentQuery) ([]models.Agent, int, error) {
+	// Get matching agent IDs from the indexes
+	agentIDs, err := r.agentIDs(ctx, query)
+	if err != nil {
+		return nil, 0, err
 	}
+
+	agents, corrupt, err := r.store.GetAgents(ctx, agentIDs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get agent: %w", err)
+		return nil, 0, err
+	}
+	if err := checkCorrupt(query, corrupt); err != nil {
+		return nil, 0, err
+	}
+
+	if query != nil && query.Sort != "" {
+		sortAgents(agents, query.Sort, query.Descending)
 	}
+	return agents, len(corrupt), nil
+}
 
-	var agent models.Agent
-	if err := json.Unmarshal(data, &agent); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal agent: %w", err)
+// checkCorrupt reports agent records that failed to load: as ErrAgentCorrupt
+// for strict queries, and otherwise by logging them so the agents don't
+// silently vanish from lists.
+func checkCorrupt(query *models.AgentQuery, corrupt []string) error {
+	if len(corrupt) == 0 {
+		return nil
+	}
+	if query != nil && query.Strict {
+		return fmt.Errorf("%w: %s", ErrAgentCorrupt, strings.Join(corrupt, ", "))
 	}
+	log.Printf("Warning: skipped %d agent records that could not be loaded: %s", len(corrupt), strings.Join(corrupt, ", "))
+	return nil
+}
 
-	return &agent, nil
+// sortAgents orders agents by a field, breaking ties by ID so the order is
+// stable between calls.
+func sortAgents(agents []models.Agent, by models.AgentSort, descending bool) {
+	sort.Slice(agents, func(i, j int) bool {
+		a, b := &agents[i], &agents[j]
+		if descending {
+			a, b = b, a
+		}
+
+		var c int
+		switch by {
+		case models.SortByName:
+			c = strings.Compare(a.Name, b.Name)
+		case models.SortByLastSeen:
+			c = a.LastSeen.Compare(b.LastSeen)
+		default:
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return a.ID < b.ID
+	})
 }
 
-// List retrieves all registered agents.
-func (r *AgentRegistry) List(ctx context.Context) ([]models.Agent, error) {
-	// Get all agent IDs from index
-	agentIDs, err := r.redis.SMembers(ctx, agentIndexKey).Result()
+// Version returns a counter that changes whenever any agent is written or
+// removed.
+func (r *AgentRegistry) Version(ctx context.Context) (int64, error) {
+	return r.store.Version(ctx)
+}
+
+// Each calls fn for every registered agent matching the query, loading
+// agents in batches so memory use does not grow with the number of agents.
+// The query's sort order is not applied. Records that fail to load are
+// skipped and logged, or stop iteration with ErrAgentCorrupt if the query is
+// strict. It stops at the first error returned by fn.
+func (r *AgentRegistry) Each(ctx context.Context, query *models.AgentQuery, fn func(agent *models.Agent) error) error {
+	agentIDs, err := r.agentIDs(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get agent index: %w", err)
+		return err
 	}
 
-	if len(agentIDs) == 0 {
-		return []models.Agent{}, nil
+	for start := 0; start < len(agentIDs); start += agentFetchBatch {
+		end := min(start+agentFetchBatch, len(agentIDs))
+		agents, corrupt, err := r.store.GetAgents(ctx, agentIDs[start:end])
+		if err != nil {
+			return err
+		}
+		if err := checkCorrupt(query, corrupt); err != nil {
+			return err
+		}
+		for i := range agents {
+			if err := fn(&agents[i]); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	// Get all agents
-	agents := make([]models.Agent, 0, len(agentIDs))
-	for _, agentID := range agentIDs {
-		agent, err := r.Get(ctx, agentID)
-		if err != nil {
-			// Skip agents that can't be retrieved
-			continue
+// Reindex adds every registered agent to the name, type and capability
+// indexes, backfilling records written before those indexes existed. Index
+// writes are idempotent, so it is safe to run on every startup. A name held by
+// another agent is left with its holder. It returns the number of agents
+// indexed.
+func (r *AgentRegistry) Reindex(ctx context.Context) (int, error) {
+	indexed := 0
+	err := r.Each(ctx, nil, func(agent *models.Agent) error {
+		if agent.Name != "" {
+			claimed, err := r.store.ClaimName(ctx, agent.Name, agent.ID)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				if owner, err := r.store.NameOwner(ctx, agent.Name); err == nil && owner != agent.ID {
+					log.Printf("Warning: agent %s not indexed by name %q, which is held by agent %s", agent.ID, agent.Name, owner)
+				}
+			}
+		}
+		if err := r.store.IndexType(ctx, agent.ID, agent.Type); err != nil {
+			return err
+		}
+		if err := r.reindexCapabilities(ctx, agent.ID, nil, agent.Capabilities); err != nil {
+			return err
+		}
+		indexed++
+		return nil
+	})
+	return indexed, err
+}
+
+// Replace replaces an agent's mutable fields. Fields omitted from the request
+// are cleared, except status, which is kept when omitted because it is
+// normally driven by heartbeats and streams. A nil precondition always
+// passes.
+func (r *AgentRegistry) Replace(ctx context.Context, agentID string, req *models.UpdateAgentRequest, pre Precondition) (*models.Agent, error) {
+	if req.Status != "" && !req.Status.Valid() {
+		return nil, ErrInvalidStatus
+	}
+
+	return r.modifyIf(ctx, agentID, pre, func(agent *models.Agent) error {
+		if err := r.setName(ctx, agent, req.Name); err != nil {
+			return err
+		}
+		if err := r.setType(ctx, agent, req.Type); err != nil {
+			return err
+		}
+		agent.Capabilities = req.Capabilities
+		agent.Endpoint = req.Endpoint
+		agent.Metadata = req.Metadata
+		agent.Delivery = req.Delivery
+		agent.Retention = req.Retention
+		if req.Status != "" {
+			agent.Status = req.Status
+		}
+		if !validDelivery(agent.Delivery, agent.Endpoint) {
+			return ErrInvalidDelivery
+		}
+		return r.checkRetention(agent.Retention)
+	})
+}
+
+// Patch partially updates an agent. Absent fields are left unchanged and
+// null fields are cleared; name, type and status cannot be cleared. A nil
+// precondition always passes.
+func (r *AgentRegistry) Patch(ctx context.Context, agentID string, req *models.PatchAgentRequest, pre Precondition) (*models.Agent, error) {
+	if req.Name.Null || req.Type.Null || req.Status.Null {
+		return nil, ErrInvalidUpdate
+	}
+	if req.Status.Set && !req.Status.Value.Valid() {
+		return nil, ErrInvalidStatus
+	}
+
+	return r.modifyIf(ctx, agentID, pre, func(agent *models.Agent) error {
+		if req.Name.Set {
+			if err := r.setName(ctx, agent, req.Name.Value); err != nil {
+				return err
+			}
+		}
+		if req.Type.Set {
+			if err := r.setType(ctx, agent, req.Type.Value); err != nil {
+				return err
+			}
+		}
+		if req.Capabilities.Set {
+			agent.Capabilities = req.Capabilities.Value
+		}
+		if req.Endpoint.Set {
+			agent.Endpoint = req.Endpoint.Value
+		}
+		if req.Status.Set {
+			agent.Status = req.Status.Value
+		}
+		if req.Metadata.Null {
+			agent.Metadata = nil
+		} else if req.Metadata.Set {
+			agent.Metadata = mergeMetadata(agent.Metadata, req.Metadata.Value)
+		}
+		if req.Delivery.Set {
+			agent.Delivery = req.Delivery.Value
 		}
-		agents = append(agents, *agent)
+		if req.Retention.Set {
+			agent.Retention = req.Retention.Value
+		}
+		if !validDelivery(agent.Delivery, agent.Endpoint) {
+			return ErrInvalidDelivery
+		}
+		return r.checkRetention(agent.Retention)
+	})
+}
+
+// mergeMetadata applies a JSON Merge Patch to a copy of metadata: nil values
+// delete their key and others set it.
+func mergeMetadata(current map[string]string, patch map[string]*string) map[string]string {
+	metadata := make(map[string]string, len(current)+len(patch))
+	maps.Copy(metadata, current)
+	for key, value := range patch {
+		if value == nil {
+			delete(metadata, key)
+		} else {
+			metadata[key] = *value
+		}
+	}
+	if len(metadata) == 0 {
+		return nil
 	}
+	return metadata
+}
+
+// SetStatus updates an agent's status.
+func (r *AgentRegistry) SetStatus(ctx context.Context, agentID string, status models.AgentStatus) (*models.Agent, error) {
+	return r.modify(ctx, agentID, func(agent *models.Agent) error {
+		agent.Status = status
+		return nil
+	})
+}
+
+// ReportStatus applies an agent's self-reported status and last error.
+func (r *AgentRegistry) ReportStatus(ctx context.Context, agentID string, report *models.StatusReport) (*models.Agent, error) {
+	if report.Status != "" && !report.Status.Valid() {
+		return nil, ErrInvalidStatus
+	}
+
+	return r.modify(ctx, agentID, func(agent *models.Agent) error {
+		if report.Status != "" {
+			agent.Status = report.Status
+		}
+		if report.Error.Set {
+			if report.Error.Value == "" {
+				agent.LastError = ""
+				agent.LastErrorAt = nil
+			} else {
+				now := models.Now()
+				agent.LastError = report.Error.Value
+				agent.LastErrorAt = &now
+			}
+		}
+		return nil
+	})
+}
 
-	return agents, nil
+// modify loads an agent, applies a change to it and saves the result.
+func (r *AgentRegistry) modify(ctx context.Context, agentID string, apply func(agent *models.Agent) error) (*models.Agent, error) {
+	return r.update(ctx, agentID, models.EventUpdate, nil, apply)
 }
 
-// Update updates an existing agent.
-func (r *AgentRegistry) Update(ctx context.Context, agentID string, req *models.UpdateAgentRequest) (*models.Agent, error) {
+// modifyIf is modify, applying the change only if the agent passes the
+// precondition.
+func (r *AgentRegistry) modifyIf(ctx context.Context, agentID string, pre Precondition, apply func(agent *models.Agent) error) (*models.Agent, error) {
+	return r.update(ctx, agentID, models.EventUpdate, pre, apply)
+}
+
+// update is modifyIf, recording the given event type.
+func (r *AgentRegistry) update(ctx context.Context, agentID string, eventType models.RegistryEventType, pre Precondition, apply func(agent *models.Agent) error) (*models.Agent, error) {
 	// Get existing agent
 	agent, err := r.Get(ctx, agentID)
 	if err != nil {
 		return nil, err
 	}
+	if pre != nil && !pre(agent) {
+		return nil, ErrPreconditionFailed
+	}
+	// Conditional updates are only saved if the agent is still as checked
+	var checked []byte
+	if pre != nil {
+		if checked, err = json.Marshal(agent); err != nil {
+			return nil, fmt.Errorf("failed to marshal agent: %w", err)
+		}
+	}
+	name, agentType, capabilities := agent.Name, agent.Type, agent.Capabilities
+	wasOffline := agent.Status == models.StatusOffline
 
-	// Update fields if provided
-	if req.Name != "" {
-		agent.Name = req.Name
+	if err := apply(agent); err != nil {
+		r.releaseClaimedName(ctx, agent, name)
+		return nil, err
 	}
-	if req.Type != "" {
-		agent.Type = req.Type
+	trackOnline(agent, wasOffline)
+
+	// Save updated agent
+	if checked == nil {
+		err = r.store.PutAgent(ctx, agent)
+	} else {
+		var swapped bool
+		if swapped, err = r.store.SwapAgent(ctx, agent, checked); err == nil && !swapped {
+			err = ErrPreconditionFailed
+		}
 	}
-	if len(req.Capabilities) > 0 {
-		agent.Capabilities = req.Capabilities
+	if err != nil {
+		r.releaseClaimedName(ctx, agent, name)
+		return nil, err
 	}
-	if req.Endpoint != "" {
-		agent.Endpoint = req.Endpoint
+
+	r.reindex(ctx, agent, name, agentType, capabilities)
+	r.recordEvent(ctx, eventType, agent)
+
+	return agent, nil
+}
+
+// reindex moves a saved agent's type, capability and name index entries
+// from the given previous values to its current ones. It only runs once the
+// update is saved, so an update that lost a race never touches the indexes
+// of the one that won. Failures are logged, as the update itself succeeded.
+func (r *AgentRegistry) reindex(ctx context.Context, agent *models.Agent, name, agentType string, capabilities []string) {
+	err := r.reindexCapabilities(ctx, agent.ID, capabilities, agent.Capabilities)
+	if agent.Type != agentType {
+		err = errors.Join(err, r.store.UnindexType(ctx, agent.ID, agentType), r.store.IndexType(ctx, agent.ID, agent.Type))
 	}
-	if req.Status != "" {
-		agent.Status = req.Status
+	if agent.Name != name {
+		err = errors.Join(err, r.releaseName(ctx, agent.ID, name))
 	}
-	if req.Metadata != nil {
-		agent.Metadata = req.Metadata
+	if err != nil {
+		log.Printf("Warning: failed to update indexes of agent %s: %v", agent.ID, err)
 	}
+}
 
-	// Save updated agent
-	agentKey := agentKeyPrefix + agentID
-	data, err := json.Marshal(agent)
+// releaseClaimedName releases the new name claimed by an update that was not
+// saved, if it renamed the agent from name.
+func (r *AgentRegistry) releaseClaimedName(ctx context.Context, agent *models.Agent, name string) {
+	if agent.Name == name {
+		return
+	}
+	if err := r.releaseName(ctx, agent.ID, agent.Name); err != nil {
+		log.Printf("Warning: failed to release name %q claimed by agent %s: %v", agent.Name, agent.ID, err)
+	}
+}
+
+// trackOnline records when an agent comes online after being offline, and
+// clears the record when it goes offline.
+func trackOnline(agent *models.Agent, wasOffline bool) {
+	switch {
+	case agent.Status == models.StatusOffline:
+		agent.OnlineSince = nil
+	case wasOffline || agent.OnlineSince == nil:
+		now := models.Now()
+		agent.OnlineSince = &now
+	}
+}
+
+// setName renames an agent, claiming the new name in the name index so a
+// taken name fails with ErrAgentExists. The old name is released by reindex
+// once the rename is saved.
+func (r *AgentRegistry) setName(ctx context.Context, agent *models.Agent, name string) error {
+	if name == "" {
+		return ErrInvalidUpdate
+	}
+	if name == agent.Name {
+		return nil
+	}
+	if models.IsReserved(name) {
+		return ErrReservedName
+	}
+
+	claimed, err := r.store.ClaimName(ctx, name, agent.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal agent: %w", err)
+		return err
+	}
+	if !claimed {
+		return ErrAgentExists
 	}
+	agent.Name = name
+	return nil
+}
 
-	if err := r.redis.Set(ctx, agentKey, data, 0).Err(); err != nil {
-		return nil, fmt.Errorf("failed to update agent: %w", err)
+// setType changes an agent's type. The type index is updated by reindex once
+// the change is saved.
+func (r *AgentRegistry) setType(ctx context.Context, agent *models.Agent, agentType string) error {
+	if agentType == "" {
+		return ErrInvalidUpdate
+	}
+	if agentType == agent.Type {
+		return nil
+	}
+	if !r.isValidType(agentType) {
+		return ErrInvalidType
 	}
+	agent.Type = agentType
+	return nil
+}
 
-	return agent, nil
+// ListTypes returns the number of registered agents per type. Configured
+// agent types are always included, even when no agents of that type exist.
+func (r *AgentRegistry) ListTypes(ctx context.Context) ([]models.AgentTypeCount, error) {
+	known, err := r.store.KnownTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	types := append([]string{}, r.agentTypes...)
+	for _, t := range known {
+		if !r.validTypes[t] {
+			types = append(types, t)
+		}
+	}
+	sort.Strings(types)
+
+	totals, err := r.store.CountTypes(ctx, types)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]models.AgentTypeCount, 0, len(types))
+	for i, t := range types {
+		counts = append(counts, models.AgentTypeCount{Type: t, Count: totals[i]})
+	}
+	return counts, nil
+}
+
+func (r *AgentRegistry) isValidType(agentType string) bool {
+	return r.validTypes == nil || r.validTypes[agentType]
+}
+
+// releaseName removes a name index entry if it still points at the agent.
+func (r *AgentRegistry) releaseName(ctx context.Context, agentID, name string) error {
+	owner, err := r.store.NameOwner(ctx, name)
+	if err != nil || owner != agentID {
+		return err
+	}
+	return r.store.ReleaseName(ctx, name)
 }
 
 // Unregister removes an agent from the registry.
 func (r *AgentRegistry) Unregister(ctx context.Context, agentID string) error {
 	// Check if agent exists
-	_, err := r.Get(ctx, agentID)
+	agent, err := r.Get(ctx, agentID)
 	if err != nil {
 		return err
 	}
 
-	// Remove from index
-	if err := r.redis.SRem(ctx, agentIndexKey, agentID).Err(); err != nil {
-		return fmt.Errorf("failed to remove agent from index: %w", err)
+	// Release the agent's name
+	if err := r.releaseName(ctx, agent.ID, agent.Name); err != nil {
+		return err
+	}
+
+	// Remove from indexes
+	if err := r.store.UnindexType(ctx, agentID, agent.Type); err != nil {
+		return err
+	}
+	if err := r.reindexCapabilities(ctx, agentID, agent.Capabilities, nil); err != nil {
+		return err
 	}
 
-	// Delete agent data
-	agentKey := agentKeyPrefix + agentID
-	if err := r.redis.Del(ctx, agentKey).Err(); err != nil {
-		return fmt.Errorf("failed to delete agent: %w", err)
+	// Delete agent data and presence
+	if err := r.store.DeleteAgent(ctx, agentID); err != nil {
+		return err
+	}
+
+	r.recordEvent(ctx, models.EventUnregister, agent)
+
+	// Release resources owned by other services
+	for _, cleanup := range r.cleanups {
+		if err := cleanup(ctx, agentID); err != nil {
+			log.Printf("Warning: cleanup for agent %s failed: %v", agentID, err)
+		}
 	}
 
 	return nil
 }
 
+// Liveness reports whether each agent of a type is online or offline, judged
+// by heartbeat presence alone, without loading agent records. An empty type
+// covers every agent.
+func (r *AgentRegistry) Liveness(ctx context.Context, agentType string) (map[string]models.AgentStatus, error) {
+	var agentIDs []string
+	var err error
+	if agentType == "" {
+		agentIDs, err = r.store.AgentIDs(ctx)
+	} else {
+		agentIDs, err = r.store.TypeMembers(ctx, agentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]models.AgentStatus, len(agentIDs))
+	for start := 0; start < len(agentIDs); start += agentFetchBatch {
+		end := min(start+agentFetchBatch, len(agentIDs))
+		alive, err := r.store.HeartbeatsAlive(ctx, agentIDs[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for i, agentID := range agentIDs[start:end] {
+			statuses[agentID] = models.StatusOffline
+			if alive[i] {
+				statuses[agentID] = models.StatusOnline
+			}
+		}
+	}
+	return statuses, nil
+}
+
+// UnregisterType unregisters every agent of a type, with the same cleanup as
+// Unregister, and returns how many were removed. Agents removed concurrently
+// are skipped.
+func (r *AgentRegistry) UnregisterType(ctx context.Context, agentType string) (int, error) {
+	agentIDs, err := r.store.TypeMembers(ctx, agentType)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, agentID := range agentIDs {
+		err := r.Unregister(ctx, agentID)
+		if errors.Is(err, ErrAgentNotFound) {
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("failed to unregister agent %s: %w", agentID, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// heartbeatTTL returns how long a heartbeat keeps an agent present: the
+// heartbeat TTL plus random jitter, spreading out the expiry of agents that
+// heartbeat in lockstep.
+func (r *AgentRegistry) heartbeatTTL() time.Duration {
+	if r.heartbeatJitter <= 0 {
+		return agentHeartbeatTTL
+	}
+	return agentHeartbeatTTL + rand.N(r.heartbeatJitter)
+}
+
 // Heartbeat updates the agent's last seen timestamp.
 func (r *AgentRegistry) Heartbeat(ctx context.Context, agentID string) error {
 	// Check if agent exists
@@ -200,34 +762,128 @@ func (r *AgentRegistry) Heartbeat(ctx context.Context, agentID string) error {
 	return r.updateHeartbeat(ctx, agentID)
 }
 
-func (r *AgentRegistry) updateHeartbeat(ctx context.Context, agentID string) error {
-	heartbeatKey := "agent:heartbeat:" + agentID
-	if err := r.redis.Set(ctx, heartbeatKey, time.Now().Unix(), agentHeartbeatTTL).Err(); err != nil {
-		return fmt.Errorf("failed to update heartbeat: %w", err)
+// Touch refreshes an agent's heartbeat without updating its last_seen time,
+// the cheapest way to keep an agent alive. It returns ErrAgentNotFound if the
+// agent is not registered.
+func (r *AgentRegistry) Touch(ctx context.Context, agentID string) error {
+	refreshed, err := r.store.RefreshHeartbeat(ctx, agentID, r.heartbeatTTL())
+	if err != nil {
+		return err
 	}
+	switch refreshed {
+	case RefreshNotFound:
+		return ErrAgentNotFound
+	case RefreshRevived:
+		return r.markOnline(ctx, agentID)
+	}
+	return nil
+}
 
-	// Update last seen in agent data
-	agentKey := agentKeyPrefix + agentID
-	data, err := r.redis.Get(ctx, agentKey).Bytes()
+// TouchMany refreshes the heartbeats of several agents at once, like Touch,
+// and reports for each whether it is registered.
+func (r *AgentRegistry) TouchMany(ctx context.Context, agentIDs []string) ([]bool, error) {
+	ttls := make([]time.Duration, len(agentIDs))
+	for i := range ttls {
+		ttls[i] = r.heartbeatTTL()
+	}
+	refreshed, err := r.store.RefreshHeartbeats(ctx, agentIDs, ttls)
 	if err != nil {
-		return fmt.Errorf("failed to get agent for heartbeat: %w", err)
+		return nil, err
 	}
 
-	var agent models.Agent
-	if err := json.Unmarshal(data, &agent); err != nil {
-		return fmt.Errorf("failed to unmarshal agent: %w", err)
+	registered := make([]bool, len(agentIDs))
+	for i, outcome := range refreshed {
+		registered[i] = outcome != RefreshNotFound
+		if outcome == RefreshRevived {
+			if err := r.markOnline(ctx, agentIDs[i]); err != nil && !errors.Is(err, ErrAgentNotFound) {
+				return nil, err
+			}
+		}
 	}
+	return registered, nil
+}
 
-	agent.LastSeen = time.Now()
+// markOnline marks an agent that the sweeper marked offline online again now
+// its heartbeat has resumed, recording when it came back, so the sweeper
+// reports its next outage too.
+func (r *AgentRegistry) markOnline(ctx context.Context, agentID string) error {
+	_, err := r.modify(ctx, agentID, func(agent *models.Agent) error {
+		if agent.Status != models.StatusOffline {
+			return errNotOffline
+		}
+		agent.Status = models.StatusOnline
+		return nil
+	})
+	if errors.Is(err, errNotOffline) {
+		return nil
+	}
+	return err
+}
 
-	updatedData, err := json.Marshal(agent)
+func (r *AgentRegistry) updateHeartbeat(ctx context.Context, agentID string) error {
+	if err := r.store.TouchHeartbeat(ctx, agentID, r.heartbeatTTL()); err != nil {
+		return err
+	}
+
+	// Update last seen in agent data
+	agent, err := r.store.GetAgent(ctx, agentID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal agent: %w", err)
+		return fmt.Errorf("failed to get agent for heartbeat: %w", err)
+	}
+	if agent.Status == models.StatusOffline {
+		_, err := r.modify(ctx, agentID, func(agent *models.Agent) error {
+			agent.LastSeen = models.Now()
+			agent.Status = models.StatusOnline
+			return nil
+		})
+		return err
 	}
 
-	if err := r.redis.Set(ctx, agentKey, updatedData, 0).Err(); err != nil {
+	agent.LastSeen = models.Now()
+
+	if err := r.store.PutAgent(ctx, agent); err != nil {
 		return fmt.Errorf("failed to save agent after heartbeat: %w", err)
 	}
 
 	return nil
 }
+
+// checkRetention validates a message retention override against the
+// configured maximums. A nil override keeps the defaults.
+func (r *AgentRegistry) checkRetention(retention *models.MessageRetention) error {
+	if retention == nil {
+		return nil
+	}
+	if retention.Messages < 0 || retention.Messages > r.maxRetention.Messages {
+		return fmt.Errorf("%w: messages must be between 0 and %d", ErrInvalidRetention, r.maxRetention.Messages)
+	}
+	if retention.TTL < 0 || retention.TTL > r.maxRetention.TTL {
+		return fmt.Errorf("%w: ttl must be between 0 and %d seconds", ErrInvalidRetention, r.maxRetention.TTL)
+	}
+	return nil
+}
+
+// Retention returns an agent's message retention override, or nil if it
+// keeps the defaults. It implements messaging.RetentionSource.
+func (r *AgentRegistry) Retention(ctx context.Context, agentID string) (*models.MessageRetention, error) {
+	agent, err := r.store.GetAgent(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+	return agent.Retention, nil
+}
+
+// validDelivery reports whether a delivery mode is known, and whether an
+// agent receiving messages by webhook has an http or https endpoint to
+// receive them on. The empty mode means pubsub.
+func validDelivery(mode models.DeliveryMode, endpoint string) bool {
+	switch mode {
+	case "", models.DeliveryPubSub:
+		return true
+	case models.DeliveryWebhook, models.DeliveryBoth:
+		u, err := url.Parse(endpoint)
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	default:
+		return false
+	}
+}
@@ -0,0 +1,304 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"agent-comm-hub/internal/config"
+	"agent-comm-hub/internal/idgen"
+	"agent-comm-hub/internal/models"
+)
+
+func newTestRegistry(t *testing.T, cfg *config.RegistryConfig) (*AgentRegistry, *MemoryStore) {
+	t.Helper()
+	if cfg == nil {
+		cfg = &config.RegistryConfig{EventsMax: 100}
+	}
+	store := NewMemoryStore()
+	return NewAgentRegistry(store, idgen.UUIDGenerator{}, cfg), store
+}
+
+func register(t *testing.T, r *AgentRegistry, name, agentType string, capabilities ...string) *models.Agent {
+	t.Helper()
+	agent, created, err := r.Register(context.Background(), &models.RegisterAgentRequest{
+		Name:         name,
+		Type:         agentType,
+		Capabilities: capabilities,
+	})
+	if err != nil {
+		t.Fatalf("register %s: %v", name, err)
+	}
+	if !created {
+		t.Fatalf("register %s: agent was not created", name)
+	}
+	return agent
+}
+
+func agentIDs(agents []models.Agent) []string {
+	ids := make([]string, len(agents))
+	for i, agent := range agents {
+		ids[i] = agent.ID
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestRegisterReturnsExistingAgentForSameName(t *testing.T) {
+	r, _ := newTestRegistry(t, nil)
+	ctx := context.Background()
+	agent := register(t, r, "planner", "worker")
+
+	again, created, err := r.Register(ctx, &models.RegisterAgentRequest{Name: "planner", Type: "worker"})
+	if err != nil {
+		t.Fatalf("re-register: %v", err)
+	}
+	if created || again.ID != agent.ID {
+		t.Errorf("re-register returned %s (created %v), want existing agent %s", again.ID, created, agent.ID)
+	}
+
+	_, _, err = r.Register(ctx, &models.RegisterAgentRequest{Name: "planner", Type: "reviewer"})
+	if !errors.Is(err, ErrAgentExists) {
+		t.Errorf("register with another type: got %v, want ErrAgentExists", err)
+	}
+}
+
+func TestRegisterEnforcesAgentLimit(t *testing.T) {
+	r, _ := newTestRegistry(t, &config.RegistryConfig{MaxAgents: 1, EventsMax: 100})
+	register(t, r, "first", "worker")
+
+	_, _, err := r.Register(context.Background(), &models.RegisterAgentRequest{Name: "second", Type: "worker"})
+	if !errors.Is(err, ErrAgentLimit) {
+		t.Fatalf("got %v, want ErrAgentLimit", err)
+	}
+}
+
+func TestListByCapabilities(t *testing.T) {
+	r, _ := newTestRegistry(t, nil)
+	ctx := context.Background()
+	both := register(t, r, "both", "worker", "search", "summarize")
+	search := register(t, r, "search", "worker", "search")
+	register(t, r, "neither", "worker")
+
+	all, _, err := r.List(ctx, &models.AgentQuery{Capabilities: []string{"search", "summarize"}, Match: models.MatchAll})
+	if err != nil {
+		t.Fatalf("list all: %v", err)
+	}
+	if got := agentIDs(all); !slices.Equal(got, []string{both.ID}) {
+		t.Errorf("match all: got %v, want [%s]", got, both.ID)
+	}
+
+	anyOf, _, err := r.List(ctx, &models.AgentQuery{Capabilities: []string{"search", "summarize"}, Match: models.MatchAny})
+	if err != nil {
+		t.Fatalf("list any: %v", err)
+	}
+	want := []string{both.ID, search.ID}
+	slices.Sort(want)
+	if got := agentIDs(anyOf); !slices.Equal(got, want) {
+		t.Errorf("match any: got %v, want %v", got, want)
+	}
+}
+
+func TestCapabilityChangesUpdateIndex(t *testing.T) {
+	r, _ := newTestRegistry(t, nil)
+	ctx := context.Background()
+	agent := register(t, r, "agent", "worker", "search")
+
+	if _, err := r.AddCapabilities(ctx, agent.ID, []string{"translate"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := r.RemoveCapability(ctx, agent.ID, "search"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	for capability, want := range map[string]int{"search": 0, "translate": 1} {
+		agents, _, err := r.List(ctx, &models.AgentQuery{Capabilities: []string{capability}})
+		if err != nil {
+			t.Fatalf("list %s: %v", capability, err)
+		}
+		if len(agents) != want {
+			t.Errorf("agents with %s: got %d, want %d", capability, len(agents), want)
+		}
+	}
+}
+
+func TestPatchRejectsUnknownStatus(t *testing.T) {
+	r, _ := newTestRegistry(t, nil)
+	ctx := context.Background()
+	agent := register(t, r, "agent", "worker")
+
+	req := &models.PatchAgentRequest{Status: models.Optional[models.AgentStatus]{Set: true, Value: "sleeping"}}
+	if _, err := r.Patch(ctx, agent.ID, req, nil); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("patch: got %v, want ErrInvalidStatus", err)
+	}
+	if _, err := r.ReportStatus(ctx, agent.ID, &models.StatusReport{Status: "sleeping"}); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("report: got %v, want ErrInvalidStatus", err)
+	}
+
+	got, err := r.ReportStatus(ctx, agent.ID, &models.StatusReport{Status: models.StatusBusy})
+	if err != nil {
+		t.Fatalf("report busy: %v", err)
+	}
+	if got.Status != models.StatusBusy {
+		t.Errorf("status: got %s, want busy", got.Status)
+	}
+}
+
+func TestUnregisterFreesName(t *testing.T) {
+	r, _ := newTestRegistry(t, nil)
+	ctx := context.Background()
+	agent := register(t, r, "agent", "worker", "search")
+
+	if err := r.Unregister(ctx, agent.ID); err != nil {
+		t.Fatalf("unregister: %v", err)
+	}
+	if _, err := r.Get(ctx, agent.ID); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("get after unregister: got %v, want ErrAgentNotFound", err)
+	}
+	agents, _, err := r.List(ctx, &models.AgentQuery{Capabilities: []string{"search"}})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(agents) != 0 {
+		t.Errorf("capability index still lists %d agents", len(agents))
+	}
+
+	again := register(t, r, "agent", "worker")
+	if again.ID == agent.ID {
+		t.Errorf("re-registration reused the unregistered agent's ID")
+	}
+}
+
+func TestSweepMarksExpiredAgentsOfflineAndHeartbeatRevives(t *testing.T) {
+	r, store := newTestRegistry(t, nil)
+	ctx := context.Background()
+	agent := register(t, r, "agent", "worker")
+	live := register(t, r, "live", "worker")
+
+	store.mu.Lock()
+	store.heartbeats[agent.ID] = time.Now().Add(-time.Second)
+	store.mu.Unlock()
+
+	down, err := r.Sweep(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if down != 1 {
+		t.Errorf("sweep marked %d agents down, want 1", down)
+	}
+	if got, _ := r.Get(ctx, agent.ID); got.Status != models.StatusOffline {
+		t.Errorf("expired agent is %s, want offline", got.Status)
+	}
+	if got, _ := r.Get(ctx, live.ID); got.Status != models.StatusOnline {
+		t.Errorf("live agent is %s, want online", got.Status)
+	}
+
+	if err := r.Touch(ctx, agent.ID); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	if got, _ := r.Get(ctx, agent.ID); got.Status != models.StatusOnline {
+		t.Errorf("touched agent is %s, want online", got.Status)
+	}
+}
+
+func TestReindexBackfillsIndexes(t *testing.T) {
+	r, store := newTestRegistry(t, nil)
+	ctx := context.Background()
+
+	// An agent written before the indexes existed
+	agent := &models.Agent{ID: "legacy", Name: "legacy", Type: "worker", Capabilities: []string{"search"}, Status: models.StatusOnline}
+	if err := store.PutAgent(ctx, agent); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := store.AddAgent(ctx, agent.ID); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	indexed, err := r.Reindex(ctx)
+	if err != nil {
+		t.Fatalf("reindex: %v", err)
+	}
+	if indexed != 1 {
+		t.Errorf("reindexed %d agents, want 1", indexed)
+	}
+
+	agents, _, err := r.List(ctx, &models.AgentQuery{Capabilities: []string{"search"}})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if got := agentIDs(agents); !slices.Equal(got, []string{agent.ID}) {
+		t.Errorf("agents with search: got %v, want [%s]", got, agent.ID)
+	}
+	if owner, _ := store.NameOwner(ctx, agent.Name); owner != agent.ID {
+		t.Errorf("name owner: got %q, want %q", owner, agent.ID)
+	}
+	types, err := r.ListTypes(ctx)
+	if err != nil {
+		t.Fatalf("list types: %v", err)
+	}
+	if len(types) != 1 || types[0].Type != "worker" || types[0].Count != 1 {
+		t.Errorf("types: got %+v, want one worker", types)
+	}
+}
+
+func TestMarkDownSkipsAgentWithResumedHeartbeat(t *testing.T) {
+	r, _ := newTestRegistry(t, nil)
+	ctx := context.Background()
+	// Registration leaves a live heartbeat, as if one landed mid-sweep
+	agent := register(t, r, "agent", "worker")
+
+	marked, err := r.markDown(ctx, agent.ID)
+	if err != nil {
+		t.Fatalf("mark down: %v", err)
+	}
+	if marked {
+		t.Errorf("agent with a live heartbeat was marked down")
+	}
+	if got, _ := r.Get(ctx, agent.ID); got.Status != models.StatusOnline {
+		t.Errorf("agent is %s, want online", got.Status)
+	}
+}
+
+func TestLosingConditionalUpdateLeavesWinnerIndexed(t *testing.T) {
+	r, _ := newTestRegistry(t, nil)
+	ctx := context.Background()
+	agent := register(t, r, "agent", "worker", "search")
+
+	// The winning update lands after the losing one passed its precondition
+	pre := func(*models.Agent) bool {
+		winner := &models.PatchAgentRequest{Capabilities: models.Optional[[]string]{Set: true, Value: []string{"translate"}}}
+		if _, err := r.Patch(ctx, agent.ID, winner, nil); err != nil {
+			t.Fatalf("winning patch: %v", err)
+		}
+		return true
+	}
+	loser := &models.PatchAgentRequest{
+		Name:         models.Optional[string]{Set: true, Value: "renamed"},
+		Type:         models.Optional[string]{Set: true, Value: "reviewer"},
+		Capabilities: models.Optional[[]string]{Set: true, Value: []string{"summarize"}},
+	}
+	if _, err := r.Patch(ctx, agent.ID, loser, pre); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("losing patch: got %v, want ErrPreconditionFailed", err)
+	}
+
+	for capability, want := range map[string]int{"search": 0, "translate": 1, "summarize": 0} {
+		agents, _, err := r.List(ctx, &models.AgentQuery{Capabilities: []string{capability}})
+		if err != nil {
+			t.Fatalf("list %s: %v", capability, err)
+		}
+		if len(agents) != want {
+			t.Errorf("agents with %s: got %d, want %d", capability, len(agents), want)
+		}
+	}
+	if owner, _ := r.store.NameOwner(ctx, "renamed"); owner != "" {
+		t.Errorf("losing update left its name claimed by %q", owner)
+	}
+	if owner, _ := r.store.NameOwner(ctx, "agent"); owner != agent.ID {
+		t.Errorf("name owner: got %q, want %q", owner, agent.ID)
+	}
+	if members, _ := r.store.TypeMembers(ctx, "reviewer"); len(members) != 0 {
+		t.Errorf("losing update indexed the agent as reviewer")
+	}
+}
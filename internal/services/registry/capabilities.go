@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"context"
+	"slices"
+
+	"agent-comm-hub/internal/models"
+)
+
+// reindexCapabilities moves an agent between capability indexes when its
+// capabilities change from old to new.
+func (r *AgentRegistry) reindexCapabilities(ctx context.Context, agentID string, old, new []string) error {
+	removed := difference(old, new)
+	added := difference(new, old)
+	if len(removed) == 0 && len(added) == 0 {
+		return nil
+	}
+	return r.store.IndexCapabilities(ctx, agentID, removed, added)
+}
+
+// AddCapabilities adds capabilities to an agent, leaving those it already
+// has. Concurrent capability changes do not overwrite each other.
+func (r *AgentRegistry) AddCapabilities(ctx context.Context, agentID string, capabilities []string) (*models.Agent, error) {
+	return r.changeCapabilities(ctx, agentID, capabilities, nil)
+}
+
+// RemoveCapability removes a capability from an agent, if it has it.
+func (r *AgentRegistry) RemoveCapability(ctx context.Context, agentID, capability string) (*models.Agent, error) {
+	return r.changeCapabilities(ctx, agentID, nil, []string{capability})
+}
+
+func (r *AgentRegistry) changeCapabilities(ctx context.Context, agentID string, add, remove []string) (*models.Agent, error) {
+	if slices.Contains(add, "") || slices.Contains(remove, "") {
+		return nil, ErrInvalidCapability
+	}
+	agent, err := r.store.ChangeCapabilities(ctx, agentID, add, remove)
+	if err != nil {
+		return nil, err
+	}
+	r.recordEvent(ctx, models.EventUpdate, agent)
+	return agent, nil
+}
+
+// changeCapabilities returns capabilities with add appended, skipping those
+// already present, and remove taken out.
+func changeCapabilities(capabilities, add, remove []string) []string {
+	out := append(difference(capabilities, remove), difference(add, capabilities)...)
+	if out == nil {
+		out = []string{}
+	}
+	return out
+}
+
+// agentIDs returns the IDs of agents matching a query: all agents when it has
+// no capabilities, otherwise those with all (MatchAll) or any of them.
+func (r *AgentRegistry) agentIDs(ctx context.Context, query *models.AgentQuery) ([]string, error) {
+	if query == nil || len(query.Capabilities) == 0 {
+		return r.store.AgentIDs(ctx)
+	}
+	return r.store.MatchCapabilities(ctx, query.Capabilities, query.Match != models.MatchAny)
+}
+
+// difference returns the items of a that are not in b.
+func difference(a, b []string) []string {
+	seen := make(map[string]bool, len(b))
+	for _, item := range b {
+		seen[item] = true
+	}
+
+	var out []string
+	for _, item := range a {
+		if !seen[item] {
+			out = append(out, item)
+			seen[item] = true
+		}
+	}
+	return out
+}
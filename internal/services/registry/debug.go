@@ -0,0 +1,43 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/models"
+)
+
+// RedisIndexes returns the shared Redis index keys that list an agent: the
+// agent index, the name index and its type and capability indexes. These
+// keys are named after the type or capability rather than the agent, so they
+// are not found by searching for the agent ID. It only applies to the redis
+// store backend.
+func RedisIndexes(ctx context.Context, client *redis.Client, agent *models.Agent) ([]string, error) {
+	sets := []string{agentIndexKey, agentTypeIndexPrefix + agent.Type}
+	for _, capability := range agent.Capabilities {
+		sets = append(sets, agentCapabilityIndexPrefix+capability)
+	}
+
+	pipe := client.Pipeline()
+	members := make([]*redis.BoolCmd, len(sets))
+	for i, set := range sets {
+		members[i] = pipe.SIsMember(ctx, set, agent.ID)
+	}
+	nameOwner := pipe.HGet(ctx, agentNameIndexKey, agent.Name)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to check agent indexes: %w", err)
+	}
+
+	indexes := []string{}
+	for i, set := range sets {
+		if members[i].Val() {
+			indexes = append(indexes, set)
+		}
+	}
+	if nameOwner.Val() == agent.ID {
+		indexes = append(indexes, agentNameIndexKey)
+	}
+	return indexes, nil
+}
@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"agent-comm-hub/internal/models"
+)
+
+const registryEventsScanBatch = 100
+
+// recordEvent appends a registry event to the capped event log. Failures are
+// logged and never fail the registry operation itself.
+func (r *AgentRegistry) recordEvent(ctx context.Context, eventType models.RegistryEventType, agent *models.Agent) {
+	event := &models.RegistryEvent{
+		Type:      eventType,
+		AgentID:   agent.ID,
+		AgentName: agent.Name,
+		AgentType: agent.Type,
+	}
+	if eventType == models.EventAgentDown {
+		event.Capabilities = agent.Capabilities
+	}
+	if err := r.store.AppendEvent(ctx, event, r.eventsMaxLen); err != nil {
+		log.Printf("Warning: failed to record %s event for agent %s: %v", eventType, agent.ID, err)
+	}
+}
+
+// ListEvents returns registry events in chronological order matching the
+// query, along with a cursor for the next page if more events may exist.
+func (r *AgentRegistry) ListEvents(ctx context.Context, query models.RegistryEventQuery) ([]models.RegistryEvent, string, error) {
+	after, since := query.Cursor, query.Since
+
+	events := []models.RegistryEvent{}
+	for {
+		batch, err := r.store.ReadEvents(ctx, after, since, registryEventsScanBatch)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, event := range batch {
+			if query.Type != "" && event.Type != query.Type {
+				continue
+			}
+			events = append(events, event)
+			if len(events) == query.Limit {
+				return events, event.ID, nil
+			}
+		}
+
+		if len(batch) < registryEventsScanBatch {
+			return events, "", nil
+		}
+		after = batch[len(batch)-1].ID
+	}
+}
+
+// eventTime returns the time encoded in an "<unix millis>-<sequence>" event ID.
+func eventTime(id string) time.Time {
+	var millis int64
+	fmt.Sscanf(id, "%d-", &millis)
+	return time.UnixMilli(millis).UTC()
+}
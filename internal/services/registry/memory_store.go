@@ -0,0 +1,431 @@
+package registry
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"agent-comm-hub/internal/models"
+)
+
+// MemoryStore is a Store that keeps everything in process memory. Its state
+// is lost on restart and is not shared between hub instances, so it suits
+// development, tests and single-instance deployments.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	agents       map[string]models.Agent
+	index        map[string]bool
+	names        map[string]string
+	types        map[string]map[string]bool
+	capabilities map[string]map[string]bool
+	heartbeats   map[string]time.Time // Agent ID to presence expiry
+	version      int64
+	events       []models.RegistryEvent
+	lastEventMS  int64
+	eventSeq     int64
+}
+
+// NewMemoryStore creates a new in-memory registry store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		agents:       make(map[string]models.Agent),
+		index:        make(map[string]bool),
+		names:        make(map[string]string),
+		types:        make(map[string]map[string]bool),
+		capabilities: make(map[string]map[string]bool),
+		heartbeats:   make(map[string]time.Time),
+	}
+}
+
+// GetAgent implements Store.
+func (s *MemoryStore) GetAgent(ctx context.Context, agentID string) (*models.Agent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return nil, ErrAgentNotFound
+	}
+	return &agent, nil
+}
+
+// GetAgents implements Store.
+func (s *MemoryStore) GetAgents(ctx context.Context, agentIDs []string) ([]models.Agent, []string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agents := make([]models.Agent, 0, len(agentIDs))
+	for _, agentID := range agentIDs {
+		if agent, ok := s.agents[agentID]; ok {
+			agents = append(agents, agent)
+		}
+	}
+	return agents, nil, nil
+}
+
+// PutAgent implements Store.
+func (s *MemoryStore) PutAgent(ctx context.Context, agent *models.Agent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.agents[agent.ID] = *agent
+	s.version++
+	return nil
+}
+
+// SwapAgent implements Store.
+func (s *MemoryStore) SwapAgent(ctx context.Context, agent *models.Agent, expected []byte) (bool, error) {
+	return s.swapAgent(agent, expected, false)
+}
+
+// SwapExpiredAgent implements Store.
+func (s *MemoryStore) SwapExpiredAgent(ctx context.Context, agent *models.Agent, expected []byte) (bool, error) {
+	return s.swapAgent(agent, expected, true)
+}
+
+func (s *MemoryStore) swapAgent(agent *models.Agent, expected []byte, expired bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.agents[agent.ID]
+	if !ok {
+		return false, ErrAgentNotFound
+	}
+	current, err := json.Marshal(&stored)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal agent: %w", err)
+	}
+	if !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	if expired && s.heartbeats[agent.ID].After(time.Now()) {
+		return false, nil
+	}
+	s.agents[agent.ID] = *agent
+	s.version++
+	return true, nil
+}
+
+// DeleteAgent implements Store.
+func (s *MemoryStore) DeleteAgent(ctx context.Context, agentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.agents, agentID)
+	delete(s.index, agentID)
+	delete(s.heartbeats, agentID)
+	s.version++
+	return nil
+}
+
+// Version implements Store.
+func (s *MemoryStore) Version(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.version, nil
+}
+
+// AddAgent implements Store.
+func (s *MemoryStore) AddAgent(ctx context.Context, agentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.index[agentID] = true
+	return nil
+}
+
+// AgentIDs implements Store.
+func (s *MemoryStore) AgentIDs(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return members(s.index), nil
+}
+
+// CountAgents implements Store.
+func (s *MemoryStore) CountAgents(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.index)), nil
+}
+
+// ClaimName implements Store.
+func (s *MemoryStore) ClaimName(ctx context.Context, name, agentID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, taken := s.names[name]; taken {
+		return false, nil
+	}
+	s.names[name] = agentID
+	return true, nil
+}
+
+// NameOwner implements Store.
+func (s *MemoryStore) NameOwner(ctx context.Context, name string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.names[name], nil
+}
+
+// ReleaseName implements Store.
+func (s *MemoryStore) ReleaseName(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.names, name)
+	return nil
+}
+
+// IndexType implements Store.
+func (s *MemoryStore) IndexType(ctx context.Context, agentID, agentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addMember(s.types, agentType, agentID)
+	return nil
+}
+
+// UnindexType implements Store. The type stays known once recorded.
+func (s *MemoryStore) UnindexType(ctx context.Context, agentID, agentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.types[agentType], agentID)
+	return nil
+}
+
+// TypeMembers implements Store.
+func (s *MemoryStore) TypeMembers(ctx context.Context, agentType string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return members(s.types[agentType]), nil
+}
+
+// KnownTypes implements Store.
+func (s *MemoryStore) KnownTypes(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	types := make([]string, 0, len(s.types))
+	for t := range s.types {
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// CountTypes implements Store.
+func (s *MemoryStore) CountTypes(ctx context.Context, types []string) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make([]int64, len(types))
+	for i, t := range types {
+		counts[i] = int64(len(s.types[t]))
+	}
+	return counts, nil
+}
+
+// IndexCapabilities implements Store.
+func (s *MemoryStore) IndexCapabilities(ctx context.Context, agentID string, removed, added []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, capability := range removed {
+		delete(s.capabilities[capability], agentID)
+		if len(s.capabilities[capability]) == 0 {
+			delete(s.capabilities, capability)
+		}
+	}
+	for _, capability := range added {
+		addMember(s.capabilities, capability, agentID)
+	}
+	return nil
+}
+
+// ChangeCapabilities implements Store.
+func (s *MemoryStore) ChangeCapabilities(ctx context.Context, agentID string, add, remove []string) (*models.Agent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return nil, ErrAgentNotFound
+	}
+	agent.Capabilities = changeCapabilities(agent.Capabilities, add, remove)
+	s.agents[agentID] = agent
+	s.version++
+
+	for _, capability := range remove {
+		delete(s.capabilities[capability], agentID)
+		if len(s.capabilities[capability]) == 0 {
+			delete(s.capabilities, capability)
+		}
+	}
+	for _, capability := range add {
+		addMember(s.capabilities, capability, agentID)
+	}
+	return &agent, nil
+}
+
+// MatchCapabilities implements Store.
+func (s *MemoryStore) MatchCapabilities(ctx context.Context, capabilities []string, all bool) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := make(map[string]int)
+	for _, capability := range capabilities {
+		for agentID := range s.capabilities[capability] {
+			matches[agentID]++
+		}
+	}
+
+	ids := make([]string, 0, len(matches))
+	for agentID, n := range matches {
+		if !all || n == len(capabilities) {
+			ids = append(ids, agentID)
+		}
+	}
+	return ids, nil
+}
+
+// TouchHeartbeat implements Store.
+func (s *MemoryStore) TouchHeartbeat(ctx context.Context, agentID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.heartbeats[agentID] = time.Now().Add(ttl)
+	return nil
+}
+
+// RefreshHeartbeat implements Store.
+func (s *MemoryStore) RefreshHeartbeat(ctx context.Context, agentID string, ttl time.Duration) (Refresh, error) {
+	refreshed, err := s.RefreshHeartbeats(ctx, []string{agentID}, []time.Duration{ttl})
+	if err != nil {
+		return RefreshNotFound, err
+	}
+	return refreshed[0], nil
+}
+
+// RefreshHeartbeats implements Store.
+func (s *MemoryStore) RefreshHeartbeats(ctx context.Context, agentIDs []string, ttls []time.Duration) ([]Refresh, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	refreshed := make([]Refresh, len(agentIDs))
+	for i, agentID := range agentIDs {
+		if _, ok := s.agents[agentID]; !ok {
+			continue
+		}
+		refreshed[i] = RefreshAlive
+		if !s.heartbeats[agentID].After(now) {
+			refreshed[i] = RefreshRevived
+		}
+		s.heartbeats[agentID] = now.Add(ttls[i])
+	}
+	return refreshed, nil
+}
+
+// HeartbeatsAlive implements Store.
+func (s *MemoryStore) HeartbeatsAlive(ctx context.Context, agentIDs []string) ([]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	alive := make([]bool, len(agentIDs))
+	for i, agentID := range agentIDs {
+		alive[i] = s.heartbeats[agentID].After(now)
+	}
+	return alive, nil
+}
+
+// ClaimSweep implements Store. A memory store belongs to a single hub
+// instance, so the claim always succeeds.
+func (s *MemoryStore) ClaimSweep(ctx context.Context, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+// AppendEvent implements Store. Event IDs use the same "<unix millis>-<seq>"
+// form as the Redis backend.
+func (s *MemoryStore) AppendEvent(ctx context.Context, event *models.RegistryEvent, maxLen int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	millis := now.UnixMilli()
+	if millis <= s.lastEventMS {
+		millis = s.lastEventMS
+		s.eventSeq++
+	} else {
+		s.lastEventMS = millis
+		s.eventSeq = 0
+	}
+
+	event.ID = fmt.Sprintf("%d-%d", millis, s.eventSeq)
+	event.Timestamp = time.UnixMilli(millis).UTC()
+	s.events = append(s.events, *event)
+
+	if maxLen > 0 && int64(len(s.events)) > maxLen {
+		s.events = append([]models.RegistryEvent(nil), s.events[int64(len(s.events))-maxLen:]...)
+	}
+	return nil
+}
+
+// ReadEvents implements Store.
+func (s *MemoryStore) ReadEvents(ctx context.Context, after string, since time.Time, count int64) ([]models.RegistryEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := 0
+	for start < len(s.events) {
+		event := s.events[start]
+		if after != "" && compareEventIDs(event.ID, after) > 0 {
+			break
+		}
+		if after == "" && !event.Timestamp.Before(since.Truncate(time.Millisecond)) {
+			break
+		}
+		start++
+	}
+
+	end := len(s.events)
+	if count > 0 && int64(end-start) > count {
+		end = start + int(count)
+	}
+	return append([]models.RegistryEvent(nil), s.events[start:end]...), nil
+}
+
+// compareEventIDs orders "<unix millis>-<seq>" event IDs.
+func compareEventIDs(a, b string) int {
+	var aMS, aSeq, bMS, bSeq int64
+	fmt.Sscanf(a, "%d-%d", &aMS, &aSeq)
+	fmt.Sscanf(b, "%d-%d", &bMS, &bSeq)
+	if c := cmp.Compare(aMS, bMS); c != 0 {
+		return c
+	}
+	return cmp.Compare(aSeq, bSeq)
+}
+
+func addMember(sets map[string]map[string]bool, key, member string) {
+	if sets[key] == nil {
+		sets[key] = make(map[string]bool)
+	}
+	sets[key][member] = true
+}
+
+func members(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for member := range set {
+		out = append(out, member)
+	}
+	return out
+}
@@ -0,0 +1,573 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/models"
+)
+
+const (
+	agentKeyPrefix             = "agent:"
+	agentHeartbeatKeyPrefix    = "agent:heartbeat:"
+	agentIndexKey              = "agents:index"
+	agentNameIndexKey          = "agents:names"
+	agentTypeIndexPrefix       = "agents:type:"
+	agentTypesKey              = "agents:types"
+	agentCapabilityIndexPrefix = "agents:capability:"
+	registryEventsKey          = "registry:events"
+	registryVersionKey         = "agents:version"
+	registrySweepKey           = "registry:sweeper"
+	agentFetchBatch            = 100 // Agents loaded per MGET
+	agentWatchRetries          = 10  // Attempts at an optimistic agent update
+)
+
+// RedisStore is a Store backed by Redis.
+type RedisStore struct {
+	redis *redis.Client
+}
+
+// NewRedisStore creates a new Redis-backed registry store.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{redis: client}
+}
+
+// GetAgent implements Store.
+func (s *RedisStore) GetAgent(ctx context.Context, agentID string) (*models.Agent, error) {
+	agentKey := agentKeyPrefix + agentID
+	data, err := s.redis.Get(ctx, agentKey).Bytes()
+	if err == redis.Nil {
+		return nil, ErrAgentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+
+	var agent models.Agent
+	if err := json.Unmarshal(data, &agent); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal agent: %w", err)
+	}
+
+	return &agent, nil
+}
+
+// GetAgents implements Store, loading agents with one MGET per batch.
+// Agents that can't be decoded are skipped along with missing ones.
+func (s *RedisStore) GetAgents(ctx context.Context, agentIDs []string) ([]models.Agent, []string, error) {
+	agents := make([]models.Agent, 0, len(agentIDs))
+	var corrupt []string
+	for start := 0; start < len(agentIDs); start += agentFetchBatch {
+		end := min(start+agentFetchBatch, len(agentIDs))
+
+		keys := make([]string, 0, end-start)
+		for _, agentID := range agentIDs[start:end] {
+			keys = append(keys, agentKeyPrefix+agentID)
+		}
+
+		values, err := s.redis.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get agents: %w", err)
+		}
+
+		for i, value := range values {
+			data, ok := value.(string)
+			if !ok {
+				continue
+			}
+			var agent models.Agent
+			if err := json.Unmarshal([]byte(data), &agent); err != nil {
+				corrupt = append(corrupt, agentIDs[start+i])
+				continue
+			}
+			agents = append(agents, agent)
+		}
+	}
+	return agents, corrupt, nil
+}
+
+// PutAgent implements Store.
+func (s *RedisStore) PutAgent(ctx context.Context, agent *models.Agent) error {
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent: %w", err)
+	}
+
+	_, err = s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, agentKeyPrefix+agent.ID, data, 0)
+		pipe.Incr(ctx, registryVersionKey)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store agent: %w", err)
+	}
+	return nil
+}
+
+// SwapAgent implements Store with an optimistic transaction on the agent's
+// record, retried if the record changes underneath it.
+func (s *RedisStore) SwapAgent(ctx context.Context, agent *models.Agent, expected []byte) (bool, error) {
+	return s.swapAgent(ctx, agent, expected, false)
+}
+
+// SwapExpiredAgent implements Store, also watching the agent's heartbeat key
+// so a heartbeat landing before the write aborts it.
+func (s *RedisStore) SwapExpiredAgent(ctx context.Context, agent *models.Agent, expected []byte) (bool, error) {
+	return s.swapAgent(ctx, agent, expected, true)
+}
+
+func (s *RedisStore) swapAgent(ctx context.Context, agent *models.Agent, expected []byte, expired bool) (bool, error) {
+	agentKey := agentKeyPrefix + agent.ID
+	heartbeatKey := agentHeartbeatKeyPrefix + agent.ID
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal agent: %w", err)
+	}
+
+	var swapped bool
+	swap := func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, agentKey).Bytes()
+		if err == redis.Nil {
+			return ErrAgentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var stored models.Agent
+		if err := json.Unmarshal(current, &stored); err != nil {
+			return fmt.Errorf("failed to unmarshal agent: %w", err)
+		}
+		if current, err = json.Marshal(&stored); err != nil {
+			return fmt.Errorf("failed to marshal agent: %w", err)
+		}
+		if swapped = bytes.Equal(current, expected); !swapped {
+			return nil
+		}
+		if expired {
+			alive, err := tx.Exists(ctx, heartbeatKey).Result()
+			if err != nil {
+				return err
+			}
+			if swapped = alive == 0; !swapped {
+				return nil
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, agentKey, data, 0)
+			pipe.Incr(ctx, registryVersionKey)
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < agentWatchRetries; attempt++ {
+		err := s.redis.Watch(ctx, swap, agentKey, heartbeatKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if errors.Is(err, ErrAgentNotFound) {
+			return false, err
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to store agent: %w", err)
+		}
+		return swapped, nil
+	}
+	return false, fmt.Errorf("failed to store agent: agent %s kept changing", agent.ID)
+}
+
+// DeleteAgent implements Store.
+func (s *RedisStore) DeleteAgent(ctx context.Context, agentID string) error {
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, agentIndexKey, agentID)
+		pipe.Del(ctx, agentKeyPrefix+agentID, agentHeartbeatKeyPrefix+agentID)
+		pipe.Incr(ctx, registryVersionKey)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete agent: %w", err)
+	}
+	return nil
+}
+
+// Version implements Store.
+func (s *RedisStore) Version(ctx context.Context) (int64, error) {
+	version, err := s.redis.Get(ctx, registryVersionKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get registry version: %w", err)
+	}
+	return version, nil
+}
+
+// AddAgent implements Store.
+func (s *RedisStore) AddAgent(ctx context.Context, agentID string) error {
+	if err := s.redis.SAdd(ctx, agentIndexKey, agentID).Err(); err != nil {
+		return fmt.Errorf("failed to add agent to index: %w", err)
+	}
+	return nil
+}
+
+// AgentIDs implements Store.
+func (s *RedisStore) AgentIDs(ctx context.Context) ([]string, error) {
+	ids, err := s.redis.SMembers(ctx, agentIndexKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agent index: %w", err)
+	}
+	return ids, nil
+}
+
+// CountAgents implements Store.
+func (s *RedisStore) CountAgents(ctx context.Context) (int64, error) {
+	count, err := s.redis.SCard(ctx, agentIndexKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count agents: %w", err)
+	}
+	return count, nil
+}
+
+// ClaimName implements Store.
+func (s *RedisStore) ClaimName(ctx context.Context, name, agentID string) (bool, error) {
+	claimed, err := s.redis.HSetNX(ctx, agentNameIndexKey, name, agentID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim agent name: %w", err)
+	}
+	return claimed, nil
+}
+
+// NameOwner implements Store.
+func (s *RedisStore) NameOwner(ctx context.Context, name string) (string, error) {
+	owner, err := s.redis.HGet(ctx, agentNameIndexKey, name).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up agent name: %w", err)
+	}
+	return owner, nil
+}
+
+// ReleaseName implements Store.
+func (s *RedisStore) ReleaseName(ctx context.Context, name string) error {
+	if err := s.redis.HDel(ctx, agentNameIndexKey, name).Err(); err != nil {
+		return fmt.Errorf("failed to release agent name: %w", err)
+	}
+	return nil
+}
+
+// IndexType implements Store.
+func (s *RedisStore) IndexType(ctx context.Context, agentID, agentType string) error {
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, agentTypeIndexPrefix+agentType, agentID)
+		pipe.SAdd(ctx, agentTypesKey, agentType)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add agent to type index: %w", err)
+	}
+	return nil
+}
+
+// UnindexType implements Store.
+func (s *RedisStore) UnindexType(ctx context.Context, agentID, agentType string) error {
+	if err := s.redis.SRem(ctx, agentTypeIndexPrefix+agentType, agentID).Err(); err != nil {
+		return fmt.Errorf("failed to remove agent from type index: %w", err)
+	}
+	return nil
+}
+
+// TypeMembers implements Store.
+func (s *RedisStore) TypeMembers(ctx context.Context, agentType string) ([]string, error) {
+	agentIDs, err := s.redis.SMembers(ctx, agentTypeIndexPrefix+agentType).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agents of type: %w", err)
+	}
+	return agentIDs, nil
+}
+
+// KnownTypes implements Store.
+func (s *RedisStore) KnownTypes(ctx context.Context) ([]string, error) {
+	types, err := s.redis.SMembers(ctx, agentTypesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agent types: %w", err)
+	}
+	return types, nil
+}
+
+// CountTypes implements Store.
+func (s *RedisStore) CountTypes(ctx context.Context, types []string) ([]int64, error) {
+	counts := make([]int64, len(types))
+	if len(types) == 0 {
+		return counts, nil
+	}
+
+	pipe := s.redis.Pipeline()
+	cmds := make([]*redis.IntCmd, len(types))
+	for i, t := range types {
+		cmds[i] = pipe.SCard(ctx, agentTypeIndexPrefix+t)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to count agent types: %w", err)
+	}
+
+	for i, cmd := range cmds {
+		counts[i] = cmd.Val()
+	}
+	return counts, nil
+}
+
+// IndexCapabilities implements Store.
+func (s *RedisStore) IndexCapabilities(ctx context.Context, agentID string, removed, added []string) error {
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, capability := range removed {
+			pipe.SRem(ctx, agentCapabilityIndexPrefix+capability, agentID)
+		}
+		for _, capability := range added {
+			pipe.SAdd(ctx, agentCapabilityIndexPrefix+capability, agentID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update capability index: %w", err)
+	}
+	return nil
+}
+
+// ChangeCapabilities implements Store with an optimistic transaction on the
+// agent's record, retried if the record changes underneath it.
+func (s *RedisStore) ChangeCapabilities(ctx context.Context, agentID string, add, remove []string) (*models.Agent, error) {
+	agentKey := agentKeyPrefix + agentID
+	var agent models.Agent
+
+	change := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, agentKey).Bytes()
+		if err == redis.Nil {
+			return ErrAgentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		agent = models.Agent{}
+		if err := json.Unmarshal(data, &agent); err != nil {
+			return fmt.Errorf("failed to unmarshal agent: %w", err)
+		}
+
+		agent.Capabilities = changeCapabilities(agent.Capabilities, add, remove)
+		if data, err = json.Marshal(&agent); err != nil {
+			return fmt.Errorf("failed to marshal agent: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, agentKey, data, 0)
+			for _, capability := range remove {
+				pipe.SRem(ctx, agentCapabilityIndexPrefix+capability, agentID)
+			}
+			for _, capability := range add {
+				pipe.SAdd(ctx, agentCapabilityIndexPrefix+capability, agentID)
+			}
+			pipe.Incr(ctx, registryVersionKey)
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < agentWatchRetries; attempt++ {
+		err := s.redis.Watch(ctx, change, agentKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if errors.Is(err, ErrAgentNotFound) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to change capabilities: %w", err)
+		}
+		return &agent, nil
+	}
+	return nil, fmt.Errorf("failed to change capabilities: agent %s kept changing", agentID)
+}
+
+// MatchCapabilities implements Store with SINTER or SUNION over the
+// capability indexes.
+func (s *RedisStore) MatchCapabilities(ctx context.Context, capabilities []string, all bool) ([]string, error) {
+	keys := make([]string, len(capabilities))
+	for i, capability := range capabilities {
+		keys[i] = agentCapabilityIndexPrefix + capability
+	}
+
+	var cmd *redis.StringSliceCmd
+	if all {
+		cmd = s.redis.SInter(ctx, keys...)
+	} else {
+		cmd = s.redis.SUnion(ctx, keys...)
+	}
+	ids, err := cmd.Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query capability index: %w", err)
+	}
+	return ids, nil
+}
+
+// TouchHeartbeat implements Store.
+func (s *RedisStore) TouchHeartbeat(ctx context.Context, agentID string, ttl time.Duration) error {
+	heartbeatKey := agentHeartbeatKeyPrefix + agentID
+	if err := s.redis.Set(ctx, heartbeatKey, time.Now().Unix(), ttl).Err(); err != nil {
+		return fmt.Errorf("failed to update heartbeat: %w", err)
+	}
+	return nil
+}
+
+// refreshHeartbeat sets the heartbeat key KEYS[2] to ARGV[1] for ARGV[2]
+// milliseconds if the agent record KEYS[1] exists. It returns a Refresh: 0 if
+// the agent is not registered, 1 if the heartbeat was alive and 2 if it had
+// expired.
+var refreshHeartbeat = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local alive = redis.call('EXISTS', KEYS[2])
+redis.call('SET', KEYS[2], ARGV[1], 'PX', ARGV[2])
+return 2 - alive
+`)
+
+// RefreshHeartbeat implements Store.
+func (s *RedisStore) RefreshHeartbeat(ctx context.Context, agentID string, ttl time.Duration) (Refresh, error) {
+	keys := []string{agentKeyPrefix + agentID, agentHeartbeatKeyPrefix + agentID}
+	refreshed, err := refreshHeartbeat.Run(ctx, s.redis, keys, time.Now().Unix(), ttl.Milliseconds()).Int()
+	if err != nil {
+		return RefreshNotFound, fmt.Errorf("failed to update heartbeat: %w", err)
+	}
+	return Refresh(refreshed), nil
+}
+
+// RefreshHeartbeats implements Store. The script is sent in full with each
+// call, as a pipeline can't fall back from EVALSHA when it isn't cached.
+func (s *RedisStore) RefreshHeartbeats(ctx context.Context, agentIDs []string, ttls []time.Duration) ([]Refresh, error) {
+	now := time.Now().Unix()
+	pipe := s.redis.Pipeline()
+	cmds := make([]*redis.Cmd, len(agentIDs))
+	for i, agentID := range agentIDs {
+		keys := []string{agentKeyPrefix + agentID, agentHeartbeatKeyPrefix + agentID}
+		cmds[i] = refreshHeartbeat.Eval(ctx, pipe, keys, now, ttls[i].Milliseconds())
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to update heartbeats: %w", err)
+	}
+
+	refreshed := make([]Refresh, len(agentIDs))
+	for i, cmd := range cmds {
+		n, _ := cmd.Int()
+		refreshed[i] = Refresh(n)
+	}
+	return refreshed, nil
+}
+
+// HeartbeatsAlive implements Store.
+func (s *RedisStore) HeartbeatsAlive(ctx context.Context, agentIDs []string) ([]bool, error) {
+	pipe := s.redis.Pipeline()
+	cmds := make([]*redis.IntCmd, len(agentIDs))
+	for i, agentID := range agentIDs {
+		cmds[i] = pipe.Exists(ctx, agentHeartbeatKeyPrefix+agentID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to check heartbeats: %w", err)
+	}
+
+	alive := make([]bool, len(agentIDs))
+	for i, cmd := range cmds {
+		alive[i] = cmd.Val() > 0
+	}
+	return alive, nil
+}
+
+// ClaimSweep implements Store with a lock key that expires after ttl, so one
+// hub instance sweeps per interval.
+func (s *RedisStore) ClaimSweep(ctx context.Context, ttl time.Duration) (bool, error) {
+	claimed, err := s.redis.SetNX(ctx, registrySweepKey, time.Now().Unix(), ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim registry sweep: %w", err)
+	}
+	return claimed, nil
+}
+
+// AppendEvent implements Store with a capped Redis stream.
+func (s *RedisStore) AppendEvent(ctx context.Context, event *models.RegistryEvent, maxLen int64) error {
+	values := map[string]interface{}{
+		"event_type": string(event.Type),
+		"agent_id":   event.AgentID,
+		"agent_name": event.AgentName,
+		"agent_type": event.AgentType,
+	}
+	if len(event.Capabilities) > 0 {
+		capabilities, err := json.Marshal(event.Capabilities)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event capabilities: %w", err)
+		}
+		values["capabilities"] = string(capabilities)
+	}
+
+	id, err := s.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: registryEventsKey,
+		MaxLen: maxLen,
+		Approx: true,
+		Values: values,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("failed to append registry event: %w", err)
+	}
+
+	event.ID = id
+	event.Timestamp = eventTime(id)
+	return nil
+}
+
+// ReadEvents implements Store.
+func (s *RedisStore) ReadEvents(ctx context.Context, after string, since time.Time, count int64) ([]models.RegistryEvent, error) {
+	start := "-"
+	switch {
+	case after != "":
+		start = "(" + after
+	case !since.IsZero():
+		start = strconv.FormatInt(since.UnixMilli(), 10)
+	}
+
+	entries, err := s.redis.XRangeN(ctx, registryEventsKey, start, "+", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry events: %w", err)
+	}
+
+	events := make([]models.RegistryEvent, 0, len(entries))
+	for _, entry := range entries {
+		events = append(events, parseEvent(entry))
+	}
+	return events, nil
+}
+
+func parseEvent(entry redis.XMessage) models.RegistryEvent {
+	event := models.RegistryEvent{
+		ID:        entry.ID,
+		Type:      models.RegistryEventType(stringValue(entry.Values, "event_type")),
+		AgentID:   stringValue(entry.Values, "agent_id"),
+		AgentName: stringValue(entry.Values, "agent_name"),
+		AgentType: stringValue(entry.Values, "agent_type"),
+		Timestamp: eventTime(entry.ID),
+	}
+	if capabilities := stringValue(entry.Values, "capabilities"); capabilities != "" {
+		json.Unmarshal([]byte(capabilities), &event.Capabilities)
+	}
+	return event
+}
+
+func stringValue(values map[string]interface{}, key string) string {
+	value, _ := values[key].(string)
+	return value
+}
@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"context"
+
+	"agent-comm-hub/internal/models"
+)
+
+// Registry is the agent registry used by the HTTP handlers. AgentRegistry is
+// the implementation, backed by a Store.
+type Registry interface {
+	Register(ctx context.Context, req *models.RegisterAgentRequest) (*models.Agent, bool, error)
+	Get(ctx context.Context, agentID string) (*models.Agent, error)
+	List(ctx context.Context, query *models.AgentQuery) ([]models.Agent, int, error)
+	Each(ctx context.Context, query *models.AgentQuery, fn func(agent *models.Agent) error) error
+	Replace(ctx context.Context, agentID string, req *models.UpdateAgentRequest, pre Precondition) (*models.Agent, error)
+	Patch(ctx context.Context, agentID string, req *models.PatchAgentRequest, pre Precondition) (*models.Agent, error)
+	SetStatus(ctx context.Context, agentID string, status models.AgentStatus) (*models.Agent, error)
+	ReportStatus(ctx context.Context, agentID string, report *models.StatusReport) (*models.Agent, error)
+	AddCapabilities(ctx context.Context, agentID string, capabilities []string) (*models.Agent, error)
+	RemoveCapability(ctx context.Context, agentID, capability string) (*models.Agent, error)
+	Unregister(ctx context.Context, agentID string) error
+	UnregisterType(ctx context.Context, agentType string) (int, error)
+	Liveness(ctx context.Context, agentType string) (map[string]models.AgentStatus, error)
+	Heartbeat(ctx context.Context, agentID string) error
+	Touch(ctx context.Context, agentID string) error
+	TouchMany(ctx context.Context, agentIDs []string) ([]bool, error)
+	ListTypes(ctx context.Context) ([]models.AgentTypeCount, error)
+	ListEvents(ctx context.Context, query models.RegistryEventQuery) ([]models.RegistryEvent, string, error)
+	Version(ctx context.Context) (int64, error)
+}
+
+var _ Registry = (*AgentRegistry)(nil)
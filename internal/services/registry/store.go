@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/models"
+)
+
+// Store backends.
+const (
+	StoreRedis  = "redis"
+	StoreMemory = "memory"
+)
+
+// Refresh is the outcome of refreshing an agent's heartbeat.
+type Refresh int
+
+const (
+	RefreshNotFound Refresh = iota // Agent is not registered
+	RefreshAlive                   // Heartbeat had not expired
+	RefreshRevived                 // Heartbeat had expired, so the agent may be marked offline
+)
+
+// Store persists agents and the indexes the registry keeps over them.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// GetAgent returns an agent by ID, or ErrAgentNotFound.
+	GetAgent(ctx context.Context, agentID string) (*models.Agent, error)
+	// GetAgents returns the agents with the given IDs, skipping missing ones
+	// and returning the IDs of records that could not be decoded.
+	GetAgents(ctx context.Context, agentIDs []string) ([]models.Agent, []string, error)
+	// PutAgent creates or replaces an agent record and bumps the version.
+	PutAgent(ctx context.Context, agent *models.Agent) error
+	// SwapAgent atomically replaces an agent record, bumping the version,
+	// only if the record still encodes to expected, and reports whether it
+	// did. It returns ErrAgentNotFound if the record is gone.
+	SwapAgent(ctx context.Context, agent *models.Agent, expected []byte) (bool, error)
+	// SwapExpiredAgent is SwapAgent, additionally requiring the agent's
+	// heartbeat to have expired, checked atomically with the write.
+	SwapExpiredAgent(ctx context.Context, agent *models.Agent, expected []byte) (bool, error)
+	// DeleteAgent removes an agent record, its presence and its membership
+	// of the agent index, and bumps the version.
+	DeleteAgent(ctx context.Context, agentID string) error
+
+	// Version returns a counter bumped by every agent write and removal.
+	Version(ctx context.Context) (int64, error)
+
+	// AddAgent adds an agent to the index of registered agents.
+	AddAgent(ctx context.Context, agentID string) error
+	// AgentIDs returns the IDs of every registered agent.
+	AgentIDs(ctx context.Context) ([]string, error)
+	// CountAgents returns the number of registered agents.
+	CountAgents(ctx context.Context) (int64, error)
+
+	// ClaimName assigns a name to an agent unless it is already taken.
+	ClaimName(ctx context.Context, name, agentID string) (bool, error)
+	// NameOwner returns the ID of the agent holding a name, or "" if none.
+	NameOwner(ctx context.Context, name string) (string, error)
+	// ReleaseName frees a name.
+	ReleaseName(ctx context.Context, name string) error
+
+	// IndexType adds an agent to a type's index and records the type as known.
+	IndexType(ctx context.Context, agentID, agentType string) error
+	// UnindexType removes an agent from a type's index.
+	UnindexType(ctx context.Context, agentID, agentType string) error
+	// TypeMembers returns the IDs of the agents indexed under a type.
+	TypeMembers(ctx context.Context, agentType string) ([]string, error)
+	// KnownTypes returns every type an agent has been registered with.
+	KnownTypes(ctx context.Context) ([]string, error)
+	// CountTypes returns the number of agents indexed under each type.
+	CountTypes(ctx context.Context, types []string) ([]int64, error)
+
+	// IndexCapabilities moves an agent out of the removed and into the added
+	// capability indexes.
+	IndexCapabilities(ctx context.Context, agentID string, removed, added []string) error
+	// ChangeCapabilities atomically adds and removes capabilities on an
+	// agent's record and in the capability indexes, bumping the version, and
+	// returns the updated agent or ErrAgentNotFound.
+	ChangeCapabilities(ctx context.Context, agentID string, add, remove []string) (*models.Agent, error)
+	// MatchCapabilities returns the IDs of agents with all (or any) of the
+	// capabilities.
+	MatchCapabilities(ctx context.Context, capabilities []string, all bool) ([]string, error)
+
+	// TouchHeartbeat marks an agent present for ttl.
+	TouchHeartbeat(ctx context.Context, agentID string, ttl time.Duration) error
+	// RefreshHeartbeat marks an agent present for ttl if it is registered,
+	// without reading its record, and reports the outcome.
+	RefreshHeartbeat(ctx context.Context, agentID string, ttl time.Duration) (Refresh, error)
+	// RefreshHeartbeats marks each registered agent present for its ttl in
+	// one round trip, and reports the outcome for each agent.
+	RefreshHeartbeats(ctx context.Context, agentIDs []string, ttls []time.Duration) ([]Refresh, error)
+	// HeartbeatsAlive reports, for each agent, whether its heartbeat is
+	// current.
+	HeartbeatsAlive(ctx context.Context, agentIDs []string) ([]bool, error)
+	// ClaimSweep reports whether the caller won the right to sweep expired
+	// heartbeats for the next ttl.
+	ClaimSweep(ctx context.Context, ttl time.Duration) (bool, error)
+
+	// AppendEvent adds an event to the event log, keeping roughly maxLen
+	// events, and sets its ID and timestamp.
+	AppendEvent(ctx context.Context, event *models.RegistryEvent, maxLen int64) error
+	// ReadEvents returns up to count events in order, starting after the
+	// event ID after if set, otherwise at since if set, otherwise at the
+	// oldest retained event.
+	ReadEvents(ctx context.Context, after string, since time.Time, count int64) ([]models.RegistryEvent, error)
+}
+
+// NewStore creates a registry store for the named backend. The Redis client
+// is only used by the Redis backend.
+func NewStore(backend string, client *redis.Client) (Store, error) {
+	switch backend {
+	case StoreRedis, "":
+		return NewRedisStore(client), nil
+	case StoreMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"agent-comm-hub/internal/models"
+)
+
+// errNotOffline stops a resumed heartbeat from updating an agent that is not
+// offline.
+var errNotOffline = errors.New("agent not offline")
+
+// RunSweeper calls Sweep every interval until ctx is cancelled.
+func (r *AgentRegistry) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Sweep(ctx, interval); err != nil && ctx.Err() == nil {
+				log.Printf("Warning: registry sweep failed: %v", err)
+			}
+		}
+	}
+}
+
+// Sweep marks agents whose heartbeat has expired offline, recording an
+// agent_down event with each agent's capabilities so orchestrators can
+// reassign its work. Only one hub instance sweeps per interval; the others
+// return immediately. It returns the number of agents marked down.
+func (r *AgentRegistry) Sweep(ctx context.Context, interval time.Duration) (int, error) {
+	// Release the claim a little early so this instance's next tick can win it
+	claimed, err := r.store.ClaimSweep(ctx, interval*9/10)
+	if err != nil || !claimed {
+		return 0, err
+	}
+
+	agentIDs, err := r.store.AgentIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	down := 0
+	for start := 0; start < len(agentIDs); start += agentFetchBatch {
+		end := min(start+agentFetchBatch, len(agentIDs))
+		alive, err := r.store.HeartbeatsAlive(ctx, agentIDs[start:end])
+		if err != nil {
+			return down, err
+		}
+
+		for i, agentID := range agentIDs[start:end] {
+			if alive[i] {
+				continue
+			}
+			marked, err := r.markDown(ctx, agentID)
+			if err != nil && !errors.Is(err, ErrAgentNotFound) {
+				return down, err
+			}
+			if marked {
+				down++
+			}
+		}
+	}
+	return down, nil
+}
+
+// markDown marks an agent offline and records an agent_down event, unless it
+// is already offline. The write only happens if the agent is unchanged and
+// its heartbeat is still expired, so a heartbeat that lands after the sweep
+// checked it can't be overwritten; such an agent is left to the next sweep.
+func (r *AgentRegistry) markDown(ctx context.Context, agentID string) (bool, error) {
+	agent, err := r.Get(ctx, agentID)
+	if err != nil || agent.Status == models.StatusOffline {
+		return false, err
+	}
+	expected, err := json.Marshal(agent)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal agent: %w", err)
+	}
+
+	agent.Status = models.StatusOffline
+	trackOnline(agent, false)
+	marked, err := r.store.SwapExpiredAgent(ctx, agent, expected)
+	if err != nil || !marked {
+		return false, err
+	}
+
+	r.recordEvent(ctx, models.EventAgentDown, agent)
+	return true, nil
+}
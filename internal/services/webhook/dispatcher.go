@@ -0,0 +1,360 @@
+// Package webhook delivers messages to agents that receive them by HTTP
+// POST to their registered endpoint.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"agent-comm-hub/internal/config"
+	"agent-comm-hub/internal/models"
+	"agent-comm-hub/internal/services/registry"
+)
+
+const (
+	// deliveryPrefix keys the status of a message's webhook delivery.
+	deliveryPrefix = "webhook:delivery:"
+	// deliveryTTL is how long delivery status is kept, matching history.
+	deliveryTTL = 24 * time.Hour
+
+	maxRetryBackoff = time.Minute
+)
+
+// Headers sent with each delivery.
+const (
+	SignatureHeader = "X-Hub-Signature"  // t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+	MessageIDHeader = "X-Hub-Message-ID" // Lets receivers discard redelivered messages
+	AttemptHeader   = "X-Hub-Delivery-Attempt"
+)
+
+// Errors for webhook delivery.
+var (
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrQueueFull        = errors.New("webhook delivery queue is full")
+	ErrStopped          = errors.New("webhook dispatcher is stopped")
+)
+
+// Fallback holds a message whose webhook delivery failed for the recipient
+// to fetch another way, reporting whether it did.
+type Fallback func(ctx context.Context, agentID string, data []byte) (bool, error)
+
+// delivery is one message on its way to an endpoint.
+type delivery struct {
+	endpoint string
+	status   models.WebhookDelivery
+	data     []byte // Encoded message, the request body
+}
+
+// Dispatcher POSTs direct messages to the endpoints of agents registered
+// with webhook delivery, retrying failures with exponential backoff and
+// recording each delivery's status in Redis. Deliveries waiting for a worker
+// or a retry are held in memory. Those that fail for good, including those
+// still waiting when the dispatcher stops, are handed to the fallback.
+type Dispatcher struct {
+	agents     registry.Registry
+	redis      *redis.Client
+	httpClient *http.Client
+	cfg        *config.WebhookConfig
+	fallback   Fallback
+
+	queue chan *delivery
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	stopped bool
+	retries map[*delivery]*time.Timer // Deliveries waiting for a retry
+}
+
+// NewDispatcher creates a webhook dispatcher. Call Run to start delivering.
+// Deliveries only connect to public addresses, or to the hosts in the
+// configured allowlist when there is one.
+func NewDispatcher(cfg *config.WebhookConfig, agents registry.Registry, redisStd *redis.Client) (*Dispatcher, error) {
+	guard, err := newHostGuard(cfg.AllowedHosts)
+	if err != nil {
+		return nil, err
+	}
+	return &Dispatcher{
+		agents:     agents,
+		redis:      redisStd,
+		httpClient: newHTTPClient(guard, cfg.Timeout),
+		cfg:        cfg,
+		queue:      make(chan *delivery, cfg.QueueSize),
+		retries:    make(map[*delivery]*time.Timer),
+	}, nil
+}
+
+// SetFallback sets where messages go whose delivery fails for good. Without
+// one they are only recorded as failed.
+func (d *Dispatcher) SetFallback(fallback Fallback) {
+	d.fallback = fallback
+}
+
+// Run delivers queued messages with the configured number of workers until
+// ctx is cancelled, then waits for in-progress attempts to finish and hands
+// deliveries still waiting for a worker or a retry to the fallback.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for i := 0; i < max(d.cfg.Workers, 1); i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-d.queue:
+					d.attempt(ctx, job)
+				}
+			}
+		}()
+	}
+	d.wg.Wait()
+	d.stop()
+}
+
+// stop refuses new deliveries and fails those still waiting.
+func (d *Dispatcher) stop() {
+	d.mu.Lock()
+	d.stopped = true
+	var waiting []*delivery
+	for job, timer := range d.retries {
+		// A timer that already fired fails its delivery itself
+		if timer.Stop() {
+			waiting = append(waiting, job)
+		}
+	}
+	clear(d.retries)
+	d.mu.Unlock()
+
+	for {
+		select {
+		case job := <-d.queue:
+			waiting = append(waiting, job)
+		default:
+			for _, job := range waiting {
+				d.fail(job, ErrStopped)
+			}
+			return
+		}
+	}
+}
+
+// Dispatch queues a direct message for delivery if its recipient receives
+// messages by webhook, and returns the recipient's delivery mode. It
+// implements messaging.WebhookDispatcher.
+func (d *Dispatcher) Dispatch(ctx context.Context, msg *models.Message, data []byte) (models.DeliveryMode, error) {
+	agent, err := d.agents.Get(ctx, msg.ToAgent)
+	if errors.Is(err, registry.ErrAgentNotFound) {
+		return models.DeliveryPubSub, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !agent.Webhook() {
+		return agent.Delivery, nil
+	}
+
+	job := &delivery{
+		endpoint: agent.Endpoint,
+		data:     data,
+		status: models.WebhookDelivery{
+			MessageID: msg.ID,
+			FromAgent: msg.FromAgent,
+			ToAgent:   msg.ToAgent,
+			State:     models.WebhookPending,
+			UpdatedAt: models.Now(),
+		},
+	}
+	if err := d.save(ctx, &job.status); err != nil {
+		return agent.Delivery, err
+	}
+	// The caller queues a message that can't be handed over itself
+	if err := d.enqueue(job); err != nil {
+		job.status.State = models.WebhookFailed
+		job.status.LastError = err.Error()
+		d.finish(job)
+		return agent.Delivery, err
+	}
+	return agent.Delivery, nil
+}
+
+// Status returns the status of a message's webhook delivery, or
+// ErrDeliveryNotFound.
+func (d *Dispatcher) Status(ctx context.Context, messageID string) (*models.WebhookDelivery, error) {
+	data, err := d.redis.Get(ctx, deliveryPrefix+messageID).Bytes()
+	if err == redis.Nil {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+
+	var status models.WebhookDelivery
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook delivery: %w", err)
+	}
+	return &status, nil
+}
+
+// enqueue hands a delivery to the workers without blocking, failing with
+// ErrQueueFull if it doesn't fit or ErrStopped once the workers are gone.
+func (d *Dispatcher) enqueue(job *delivery) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return ErrStopped
+	}
+	select {
+	case d.queue <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// retryLater queues a delivery again after the backoff for its attempts,
+// failing it if that is no longer possible. Only workers schedule retries,
+// and stop waits for them, so a scheduled retry is always seen by stop.
+func (d *Dispatcher) retryLater(job *delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.retries[job] = time.AfterFunc(d.backoff(job.status.Attempts), func() {
+		d.mu.Lock()
+		delete(d.retries, job)
+		d.mu.Unlock()
+		if err := d.enqueue(job); err != nil {
+			d.fail(job, err)
+		}
+	})
+}
+
+// attempt POSTs a message to its endpoint once, scheduling a retry or
+// recording the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, job *delivery) {
+	job.status.Attempts++
+	code, err := d.post(ctx, job)
+	job.status.LastStatus = code
+	job.status.LastError = ""
+	if err != nil {
+		job.status.LastError = err.Error()
+	}
+
+	switch {
+	case err == nil:
+		job.status.State = models.WebhookDelivered
+		d.finish(job)
+	case job.status.Attempts >= d.cfg.MaxAttempts || ctx.Err() != nil:
+		log.Printf("Warning: webhook delivery of message %s to agent %s failed after %d attempts: %v",
+			job.status.MessageID, job.status.ToAgent, job.status.Attempts, err)
+		d.fail(job, nil)
+	default:
+		// Retry later without holding a worker
+		d.finish(job)
+		d.retryLater(job)
+	}
+}
+
+// fail records a delivery as failed, with reason as its last error if set,
+// and hands its message to the fallback.
+func (d *Dispatcher) fail(job *delivery, reason error) {
+	job.status.State = models.WebhookFailed
+	if reason != nil {
+		job.status.LastError = reason.Error()
+	}
+	if d.fallback != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		queued, err := d.fallback(ctx, job.status.ToAgent, job.data)
+		cancel()
+		if err != nil {
+			log.Printf("Warning: failed to queue undelivered message %s for agent %s: %v", job.status.MessageID, job.status.ToAgent, err)
+		}
+		job.status.Queued = queued
+	}
+	d.finish(job)
+}
+
+// post sends one delivery attempt, returning the response status. Any 2xx
+// response is a successful delivery.
+func (d *Dispatcher) post(ctx context.Context, job *delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.endpoint, bytes.NewReader(job.data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(MessageIDHeader, job.status.MessageID)
+	req.Header.Set(AttemptHeader, strconv.Itoa(job.status.Attempts))
+	if d.cfg.SigningSecret != "" {
+		req.Header.Set(SignatureHeader, Sign(d.cfg.SigningSecret, time.Now(), job.data))
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the retry following attempt.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.RetryBackoff
+	for i := 1; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryBackoff)
+}
+
+// finish records a delivery's status, with its own context so the outcome of
+// an attempt cut short by shutdown is still recorded.
+func (d *Dispatcher) finish(job *delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	job.status.UpdatedAt = models.Now()
+	if err := d.save(ctx, &job.status); err != nil {
+		log.Printf("Warning: failed to record webhook delivery of message %s: %v", job.status.MessageID, err)
+	}
+}
+
+// save writes a delivery's status to Redis.
+func (d *Dispatcher) save(ctx context.Context, status *models.WebhookDelivery) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook delivery: %w", err)
+	}
+	if err := d.redis.Set(ctx, deliveryPrefix+status.MessageID, data, deliveryTTL).Err(); err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for a delivery body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". Receivers
+// recompute the HMAC with the shared secret, compare it in constant time,
+// and reject stale timestamps to prevent replays.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
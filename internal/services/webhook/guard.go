@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// ErrAddressNotAllowed is returned when a webhook endpoint resolves to an
+// address deliveries may not be sent to.
+var ErrAddressNotAllowed = errors.New("webhook endpoint address not allowed")
+
+// hostGuard decides which endpoint hosts webhook deliveries may connect to.
+// Without an allowlist any host with a public address is allowed. With one,
+// only hosts named in it or with addresses in its prefixes are, including
+// private ones, which operators allow by listing them.
+type hostGuard struct {
+	hosts    map[string]bool
+	prefixes []netip.Prefix
+}
+
+// newHostGuard parses an allowlist of host names, IP addresses and CIDR
+// prefixes.
+func newHostGuard(allowed []string) (*hostGuard, error) {
+	g := &hostGuard{hosts: make(map[string]bool)}
+	for _, entry := range allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid webhook allowlist prefix %q: %w", entry, err)
+			}
+			g.prefixes = append(g.prefixes, prefix.Masked())
+		default:
+			if addr, err := netip.ParseAddr(entry); err == nil {
+				g.prefixes = append(g.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+				continue
+			}
+			g.hosts[entry] = true
+		}
+	}
+	return g, nil
+}
+
+// allowed reports whether a delivery to host may connect to addr.
+func (g *hostGuard) allowed(host string, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if g.hosts[strings.ToLower(host)] {
+		return true
+	}
+	for _, prefix := range g.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	if len(g.hosts) > 0 || len(g.prefixes) > 0 {
+		return false
+	}
+	return public(addr)
+}
+
+// nonPublicPrefixes are unicast ranges IsGlobalUnicast and IsPrivate don't
+// cover but that don't reach the public internet: carrier-grade NAT shared
+// space, and the NAT64 prefix, which embeds IPv4 addresses a gateway
+// translates to, private ones included.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// public reports whether addr is a public unicast address, as opposed to
+// loopback, link-local (including cloud metadata services), private, shared
+// or unspecified ones.
+func public(addr netip.Addr) bool {
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialContext resolves the address being dialed and connects to the first of
+// its IPs the guard allows. Checking the resolved IPs, and dialing them
+// directly, stops a host name that resolves to an internal address, or
+// changes to one after a check, from reaching internal services.
+func (g *hostGuard) dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+
+		err = fmt.Errorf("%w: %s", ErrAddressNotAllowed, host)
+		for _, ip := range ips {
+			if !g.allowed(host, ip) {
+				continue
+			}
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// newHTTPClient returns a client for deliveries that only connects to
+// addresses the guard allows, uses no proxy, and doesn't follow redirects,
+// which could lead to internal addresses.
+func newHTTPClient(g *hostGuard, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         g.dialContext(&net.Dialer{Timeout: timeout}),
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
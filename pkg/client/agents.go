@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ListOptions filters and pages agent listings. The zero value lists every
+// agent.
+type ListOptions struct {
+	Capabilities []string // Only agents with these capabilities
+	MatchAny     bool     // Match agents with any rather than all capabilities
+	Sort         string   // name, created_at or last_seen
+	Descending   bool
+	Offset       int
+	Limit        int // 0 = unlimited
+}
+
+// query encodes the options as list query parameters.
+func (o *ListOptions) query() url.Values {
+	q := url.Values{}
+	if o == nil {
+		return q
+	}
+	for _, capability := range o.Capabilities {
+		q.Add("capability", capability)
+	}
+	if o.MatchAny {
+		q.Set("match", "any")
+	}
+	if o.Sort != "" {
+		q.Set("sort", o.Sort)
+	}
+	if o.Descending {
+		q.Set("order", "desc")
+	}
+	if o.Offset > 0 {
+		q.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	return q
+}
+
+// Register registers an agent, or returns the existing agent registered
+// under the same name.
+func (c *Client) Register(ctx context.Context, req *RegisterAgentRequest) (*Agent, error) {
+	var agent Agent
+	if err := c.do(ctx, http.MethodPost, "/agents", nil, req, &agent); err != nil {
+		return nil, err
+	}
+	return &agent, nil
+}
+
+// Get returns an agent by ID, with its age and uptime.
+func (c *Client) Get(ctx context.Context, agentID string) (*AgentView, error) {
+	var agent AgentView
+	if err := c.do(ctx, http.MethodGet, "/agents/"+url.PathEscape(agentID), nil, nil, &agent); err != nil {
+		return nil, err
+	}
+	return &agent, nil
+}
+
+// List returns a page of registered agents.
+func (c *Client) List(ctx context.Context, opts *ListOptions) (*AgentPage, error) {
+	var page AgentPage
+	if err := c.do(ctx, http.MethodGet, "/agents", opts.query(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Update replaces an agent's registration.
+func (c *Client) Update(ctx context.Context, agentID string, req *UpdateAgentRequest) (*Agent, error) {
+	var agent Agent
+	if err := c.do(ctx, http.MethodPut, "/agents/"+url.PathEscape(agentID), nil, req, &agent); err != nil {
+		return nil, err
+	}
+	return &agent, nil
+}
+
+// Unregister removes an agent. It requires an admin key.
+func (c *Client) Unregister(ctx context.Context, agentID string) error {
+	return c.do(ctx, http.MethodDelete, "/agents/"+url.PathEscape(agentID), nil, nil, nil)
+}
+
+// Touch keeps an agent's registration alive with the lightweight HEAD
+// heartbeat, which does not update its last_seen time.
+func (c *Client) Touch(ctx context.Context, agentID string) error {
+	return c.do(ctx, http.MethodHead, "/agents/"+url.PathEscape(agentID)+"/heartbeat", nil, nil, nil)
+}
+
+// TouchMany refreshes the heartbeats of several agents in one request, like
+// Touch, and reports the outcome for each.
+func (c *Client) TouchMany(ctx context.Context, agentIDs []string) (*BulkHeartbeatResponse, error) {
+	var resp BulkHeartbeatResponse
+	if err := c.do(ctx, http.MethodPost, "/agents/heartbeat", nil, &BulkHeartbeatRequest{AgentIDs: agentIDs}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Heartbeat keeps an agent's registration alive, optionally reporting its
+// status.
+func (c *Client) Heartbeat(ctx context.Context, agentID string, report *StatusReport) error {
+	var body any
+	if report != nil {
+		body = report
+	}
+	return c.do(ctx, http.MethodPost, "/agents/"+url.PathEscape(agentID)+"/heartbeat", nil, body, nil)
+}
@@ -0,0 +1,251 @@
+// Package client provides a typed Go client for the hub's HTTP and WebSocket
+// API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultTimeout      = 30 * time.Second
+	defaultRetries      = 3
+	defaultRetryBackoff = 200 * time.Millisecond
+	maxRetryBackoff     = 5 * time.Second
+)
+
+// Sentinel errors matched by errors.Is against the *APIError returned for
+// unsuccessful responses.
+var (
+	ErrBadRequest         = errors.New("bad request")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrRateLimited        = errors.New("rate limited")
+
+	// ErrInvalidResponse is returned when a successful response can't be
+	// decoded.
+	ErrInvalidResponse = errors.New("invalid response")
+)
+
+// APIError is returned when the hub responds with an error status.
+type APIError struct {
+	StatusCode int
+	Message    string // Response body, as written by the hub
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("hub returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Is matches the sentinel error for the response status.
+func (e *APIError) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusPreconditionFailed:
+		return target == ErrPreconditionFailed
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	}
+	return false
+}
+
+// Client calls the hub API. It is safe for concurrent use.
+type Client struct {
+	baseURL      *url.URL
+	apiKey       string
+	httpClient   *http.Client
+	timeout      time.Duration // Upper bound on each attempt, 0 = none
+	retries      int           // Retries after the first attempt
+	retryBackoff time.Duration // Delay before the first retry, doubling after each
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey authenticates requests with an API key.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithHTTPClient sends requests through hc instead of a default client, e.g.
+// to configure TLS or proxies.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithTimeout bounds each request attempt (default 30s, 0 disables). The
+// context passed to each call bounds the request as a whole, retries
+// included.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithRetries sets how many times failed requests are retried (default 3)
+// and the delay before the first retry (default 200ms), which doubles after
+// each retry up to 5s. Retried POST requests are only applied once if the
+// hub keeps idempotent responses (IDEMPOTENCY_TTL > 0).
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryBackoff = backoff
+	}
+}
+
+// New creates a client for the hub at baseURL, e.g. http://localhost:8080.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hub URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid hub URL %q: scheme must be http or https", baseURL)
+	}
+
+	c := &Client{
+		baseURL:      u,
+		httpClient:   http.DefaultClient,
+		timeout:      defaultTimeout,
+		retries:      defaultRetries,
+		retryBackoff: defaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// endpoint returns the URL of an API path, with an optional query.
+func (c *Client) endpoint(path string, query url.Values) *url.URL {
+	u := *c.baseURL
+	u.Path += "/api/v1" + path
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return &u
+}
+
+// do sends a request with a JSON body, if in is non-nil, and decodes a
+// successful JSON response into out, if non-nil. Failed attempts are retried
+// when the request can safely be repeated: POST requests carry an
+// Idempotency-Key so the hub replays rather than repeats them, as long as
+// the hub keeps idempotent responses (IDEMPOTENCY_TTL > 0).
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	return c.doRetrying(ctx, method, path, query, in, out, c.retries)
+}
+
+// doRetrying is do with at most retries retries, for requests that can't
+// always be repeated safely.
+func (c *Client) doRetrying(ctx context.Context, method, path string, query url.Values, in, out any, retries int) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	header := http.Header{}
+	if body != nil {
+		header.Set("Content-Type", "application/json")
+	}
+	if method == http.MethodPost {
+		header.Set("Idempotency-Key", uuid.NewString())
+	}
+
+	u := c.endpoint(path, query).String()
+	delay := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, method, u, header, body, out)
+		if err == nil || attempt >= retries || !retryable(ctx, err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryBackoff)
+	}
+}
+
+// attempt sends a request once.
+func (c *Client) attempt(ctx context.Context, method, u string, header http.Header, body []byte, out any) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = header.Clone()
+	c.authorize(req.Header)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	return nil
+}
+
+// authorize adds the API key, if any, to request headers.
+func (c *Client) authorize(header http.Header) {
+	if c.apiKey != "" {
+		header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+}
+
+// retryable reports whether a failed attempt is worth repeating: transport
+// errors, timeouts of a single attempt, rate limiting and responses from an
+// unavailable or overloaded hub.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrInvalidResponse) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// memoryQuery selects one memory entry.
+func memoryQuery(key string, memoryType MemoryType) url.Values {
+	q := url.Values{"key": {key}}
+	if memoryType != "" {
+		q.Set("type", string(memoryType))
+	}
+	return q
+}
+
+// StoreMemory stores a value in an agent's short-term or long-term memory.
+func (c *Client) StoreMemory(ctx context.Context, agentID string, req *StoreMemoryRequest) (*StoreMemoryResponse, error) {
+	var resp StoreMemoryResponse
+	if err := c.do(ctx, http.MethodPost, "/agents/"+url.PathEscape(agentID)+"/memory", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AppendMemory appends a chunk to a list-style long-term memory value.
+func (c *Client) AppendMemory(ctx context.Context, agentID string, req *AppendMemoryRequest) (*AppendMemoryResponse, error) {
+	var resp AppendMemoryResponse
+	if err := c.do(ctx, http.MethodPost, "/agents/"+url.PathEscape(agentID)+"/memory/append", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetMemory returns a memory entry. An empty memoryType means long-term.
+func (c *Client) GetMemory(ctx context.Context, agentID, key string, memoryType MemoryType) (*Memory, error) {
+	var mem Memory
+	if err := c.do(ctx, http.MethodGet, "/agents/"+url.PathEscape(agentID)+"/memory", memoryQuery(key, memoryType), nil, &mem); err != nil {
+		return nil, err
+	}
+	return &mem, nil
+}
+
+// DeleteMemory deletes a memory entry. An empty memoryType means long-term.
+func (c *Client) DeleteMemory(ctx context.Context, agentID, key string, memoryType MemoryType) error {
+	return c.do(ctx, http.MethodDelete, "/agents/"+url.PathEscape(agentID)+"/memory", memoryQuery(key, memoryType), nil, nil)
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-comm-hub/internal/models"
+)
+
+// SendMessage sends a message from an agent.
+func (c *Client) SendMessage(ctx context.Context, fromAgentID string, req *SendMessageRequest) (*SendMessageResponse, error) {
+	var resp SendMessageResponse
+	if err := c.do(ctx, http.MethodPost, "/agents/"+url.PathEscape(fromAgentID)+"/messages", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// History returns a page of an agent's message history, oldest first. A
+// limit of 0 uses the hub's default.
+func (c *Client) History(ctx context.Context, agentID string, offset, limit int) (*MessagePage, error) {
+	q := url.Values{}
+	if offset > 0 {
+		q.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	var page MessagePage
+	if err := c.do(ctx, http.MethodGet, "/agents/"+url.PathEscape(agentID)+"/messages", q, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// MergedHistory returns a page of the combined history of several agents, as
+// one chronological timeline with each message once. The client's key must
+// be allowed to act as every agent. A limit of 0 uses the hub's default.
+func (c *Client) MergedHistory(ctx context.Context, agentIDs []string, offset, limit int) (*MessagePage, error) {
+	q := url.Values{}
+	q.Set("agents", strings.Join(agentIDs, ","))
+	if offset > 0 {
+		q.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	var page MessagePage
+	if err := c.do(ctx, http.MethodGet, "/messages/history", q, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// CompactedTopic returns the latest message sent to a topic for each
+// compaction key, oldest first.
+func (c *Client) CompactedTopic(ctx context.Context, topic string) (*CompactedTopic, error) {
+	var resp CompactedTopic
+	if err := c.do(ctx, http.MethodGet, "/topics/"+url.PathEscape(topic)+"/compacted", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Inbox pulls up to limit of the oldest messages queued for an agent. With a
+// positive visibility the messages are redelivered unless acknowledged with
+// Ack before it passes. A limit of 0 uses the hub's default.
+//
+// Without a visibility timeout the hub removes messages from the queue as it
+// returns them, so a failed pull is not retried: the messages of a response
+// lost in transit would be gone.
+func (c *Client) Inbox(ctx context.Context, agentID string, limit int, visibility time.Duration) (*InboxResponse, error) {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if visibility > 0 {
+		q.Set("visibility_timeout", visibility.String())
+	}
+
+	retries := c.retries
+	if visibility <= 0 {
+		retries = 0
+	}
+
+	var resp InboxResponse
+	if err := c.doRetrying(ctx, http.MethodGet, "/agents/"+url.PathEscape(agentID)+"/inbox", q, nil, &resp, retries); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Ack acknowledges messages pulled from an agent's inbox, and returns how
+// many were awaiting acknowledgement.
+func (c *Client) Ack(ctx context.Context, agentID string, messageIDs ...string) (int, error) {
+	var resp models.AckResponse
+	req := models.AckRequest{MessageIDs: messageIDs}
+	if err := c.do(ctx, http.MethodPost, "/agents/"+url.PathEscape(agentID)+"/inbox/ack", nil, req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Acked, nil
+}
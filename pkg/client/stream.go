@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// SubscribeOptions adds channels and filters to an agent's message stream.
+type SubscribeOptions struct {
+	Topics        []string // Topics to receive alongside direct messages
+	Groups        []string // Groups to receive alongside direct messages
+	CorrelationID string   // Only deliver messages with this correlation ID
+	Categories    []string // Broadcast categories, overriding the agent's stored ones
+}
+
+// Subscription is an open message stream. Messages is closed when the
+// stream ends, after which Err reports why.
+type Subscription struct {
+	conn     *websocket.Conn
+	messages chan Message
+	closed   chan struct{} // Closed by Close
+	once     sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// Subscribe opens a WebSocket stream of the messages sent to an agent. While
+// it is open the hub treats it as the agent's heartbeat. Cancelling ctx
+// closes the stream.
+func (c *Client) Subscribe(ctx context.Context, agentID string, opts *SubscribeOptions) (*Subscription, error) {
+	q := url.Values{}
+	if opts != nil {
+		q["topic"] = opts.Topics
+		q["group"] = opts.Groups
+		q["category"] = opts.Categories
+		if opts.CorrelationID != "" {
+			q.Set("correlation_id", opts.CorrelationID)
+		}
+	}
+
+	u := c.endpoint("/agents/"+url.PathEscape(agentID)+"/messages/stream", q)
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+
+	header := http.Header{}
+	c.authorize(header)
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.timeout,
+	}
+	conn, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, &APIError{StatusCode: resp.StatusCode, Message: resp.Status}
+		}
+		return nil, fmt.Errorf("failed to open message stream: %w", err)
+	}
+
+	sub := &Subscription{conn: conn, messages: make(chan Message), closed: make(chan struct{})}
+	go sub.read(ctx)
+	return sub, nil
+}
+
+// Messages returns the messages received on the stream.
+func (s *Subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// Err returns the error that ended the stream, or nil while it is open or
+// after Close.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close closes the stream.
+func (s *Subscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closed)
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// read delivers messages from the connection until it closes or ctx is
+// cancelled. The connection's default ping handler answers the hub's pings.
+func (s *Subscription) read(ctx context.Context) {
+	defer close(s.messages)
+
+	stop := context.AfterFunc(ctx, func() { s.Close() })
+	defer stop()
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				s.mu.Lock()
+				s.err = err
+				s.mu.Unlock()
+			}
+			return
+		}
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		select {
+		case s.messages <- msg:
+		case <-s.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package client
+
+import "agent-comm-hub/internal/models"
+
+// The client exchanges the hub's own request and response types, aliased
+// here so callers outside the module can name them.
+type (
+	Agent                = models.Agent
+	AgentView            = models.AgentView
+	AgentStatus          = models.AgentStatus
+	RegisterAgentRequest = models.RegisterAgentRequest
+	UpdateAgentRequest   = models.UpdateAgentRequest
+	StatusReport         = models.StatusReport
+	AgentPage            = models.PagedResponse[AgentView]
+
+	BulkHeartbeatRequest  = models.BulkHeartbeatRequest
+	BulkHeartbeatResponse = models.BulkHeartbeatResponse
+
+	Message             = models.Message
+	MessageType         = models.MessageType
+	SendMessageRequest  = models.SendMessageRequest
+	SendMessageResponse = models.SendMessageResponse
+	MessagePage         = models.MessageHistoryResponse
+	InboxResponse       = models.InboxResponse
+	CompactedTopic      = models.CompactedTopicResponse
+
+	Memory               = models.Memory
+	MemoryType           = models.MemoryType
+	StoreMemoryRequest   = models.StoreMemoryRequest
+	StoreMemoryResponse  = models.StoreMemoryResponse
+	AppendMemoryRequest  = models.AppendMemoryRequest
+	AppendMemoryResponse = models.AppendMemoryResponse
+)
+
+// Agent statuses, message types and memory types, re-exported from the hub.
+const (
+	StatusOnline  = models.StatusOnline
+	StatusOffline = models.StatusOffline
+	StatusBusy    = models.StatusBusy
+
+	MessageTypeRequest  = models.MessageTypeRequest
+	MessageTypeResponse = models.MessageTypeResponse
+	MessageTypeEvent    = models.MessageTypeEvent
+	MessageTypeMessage  = models.MessageTypeMessage
+
+	MemoryTypeShortTerm = models.MemoryTypeShortTerm
+	MemoryTypeLongTerm  = models.MemoryTypeLongTerm
+)